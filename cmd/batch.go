@@ -32,10 +32,11 @@ type YAMLJob struct {
 	Profile            string `yaml:"profile" json:"profile"`
 	Manual             *bool  `yaml:"manual" json:"manual"`
 	Extract            string `yaml:"extract" json:"extract"`
+	Compressed         bool   `yaml:"compressed" json:"compressed"`
+	Globoff            bool   `yaml:"globoff" json:"globoff"`
 }
 
 var batchFlags struct {
-	cookies            string
 	cookiesFromBrowser string
 	s3Profile          string
 	extract            string
@@ -56,6 +57,10 @@ var batchCmd = &cobra.Command{
 		if err != nil {
 			utils.PrintFatal("Failed to parse batch input", err)
 		}
+		jobConfigs, err = expandBatchGlobs(jobConfigs)
+		if err != nil {
+			utils.PrintFatal("Failed to expand batch URLs", err)
+		}
 
 		if len(jobConfigs) == 0 {
 			fmt.Println("No jobs found in batch input.")
@@ -156,6 +161,38 @@ func parseBatchInput(filePath string) ([]YAMLJob, error) {
 	return jobs, nil
 }
 
+// expandBatchGlobs expands curl-style URL globs in each entry into separate
+// entries, filling #1-style placeholders in the output. Torrent entries are
+// left alone since magnet URIs may legitimately contain brackets, as are
+// entries with globoff set.
+func expandBatchGlobs(jobs []YAMLJob) ([]YAMLJob, error) {
+	var expanded []YAMLJob
+	for _, job := range jobs {
+		prefix, actualURL := parsePrefix(job.URL)
+		if job.Globoff || getJobType(prefix, actualURL, job.Type) == "torrent" {
+			expanded = append(expanded, job)
+			continue
+		}
+		matches, err := utils.ExpandURLGlob(actualURL)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 1 && job.Output != "" && !utils.HasOutputTemplate(job.Output) {
+			return nil, fmt.Errorf("%s expands to %d URLs; use #1-style placeholders in its output", job.URL, len(matches))
+		}
+		for _, match := range matches {
+			entry := job
+			entry.URL = match.URL
+			if prefix != "" {
+				entry.URL = prefix + "::" + match.URL
+			}
+			entry.Output = utils.ApplyOutputTemplate(job.Output, match.Values)
+			expanded = append(expanded, entry)
+		}
+	}
+	return expanded, nil
+}
+
 func splitLine(line string) []string {
 	var parts []string
 	var current strings.Builder
@@ -201,7 +238,7 @@ func getJobType(prefix, rawURL, overrideType string) string {
 		switch prefix {
 		case "http", "https":
 			return "http"
-		case "hls", "m3u8", "livestream", "live-stream", "stream":
+		case "hls", "m3u8", "dash", "livestream", "live-stream", "stream":
 			return "live-stream"
 		case "ghr", "github-release", "ghrelease":
 			return "github-release"
@@ -220,7 +257,7 @@ func getJobType(prefix, rawURL, overrideType string) string {
 	if strings.HasPrefix(rawURL, "magnet:") || strings.HasSuffix(rawURL, ".torrent") {
 		return "torrent"
 	}
-	if strings.Contains(rawURL, ".m3u8") {
+	if strings.Contains(rawURL, ".m3u8") || strings.Contains(rawURL, ".mpd") || m3u8job.MatchExtractor(rawURL) != "" {
 		return "live-stream"
 	}
 	return "http"
@@ -234,31 +271,28 @@ func buildJob(cfg YAMLJob) (highway.Job, error) {
 	if cfg.Connections > 0 {
 		conns = cfg.Connections
 	}
+	httpConfig := globalHTTPConfig
+	if cfg.Cookies != "" {
+		httpConfig.CookiesFile = cfg.Cookies
+	}
 
 	switch jobType {
 	case "http":
-		return httpjob.New(actualURL, cfg.Output, conns, globalHTTPConfig), nil
+		return httpjob.New(actualURL, cfg.Output, conns, cfg.Compressed, httpjob.HTTPRequest{}, httpConfig), nil
 
 	case "live-stream":
 		extract := cfg.Extract
 		if extract == "" {
 			extract = batchFlags.extract
 		}
-		if extract == "" {
-			if strings.Contains(actualURL, "dailymotion.com") || strings.Contains(actualURL, "dai.ly") {
-				extract = "dailymotion"
-			} else if strings.Contains(actualURL, "rumble.com") {
-				extract = "rumble"
-			}
-		}
-		return m3u8job.New(actualURL, cfg.Output, conns, extract, globalHTTPConfig), nil
+		return m3u8job.New(actualURL, cfg.Output, conns, extract, m3u8job.Options{}, httpConfig), nil
 
 	case "github-release":
 		man := batchFlags.manual
 		if cfg.Manual != nil {
 			man = *cfg.Manual
 		}
-		return ghreleasejob.New(actualURL, cfg.Output, man, globalHTTPConfig), nil
+		return ghreleasejob.New(actualURL, cfg.Output, man, httpConfig), nil
 
 	case "s3":
 		prof := cfg.Profile
@@ -268,21 +302,17 @@ func buildJob(cfg YAMLJob) (highway.Job, error) {
 		if prof == "" {
 			prof = "default"
 		}
-		return s3job.New(actualURL, cfg.Output, conns, prof), nil
+		return s3job.New(actualURL, cfg.Output, conns, prof, httpConfig), nil
 
 	case "ytdlp":
-		cookies := cfg.Cookies
-		if cookies == "" {
-			cookies = batchFlags.cookies
-		}
 		cookiesFromBrowser := cfg.CookiesFromBrowser
 		if cookiesFromBrowser == "" {
 			cookiesFromBrowser = batchFlags.cookiesFromBrowser
 		}
-		return ytdlpjob.New(actualURL, cfg.Output, cookies, cookiesFromBrowser, globalHTTPConfig), nil
+		return ytdlpjob.New(actualURL, cfg.Output, httpConfig.CookiesFile, cookiesFromBrowser, httpConfig), nil
 
 	case "torrent":
-		return torrentjob.New(actualURL, cfg.Output, conns, globalHTTPConfig), nil
+		return torrentjob.New(actualURL, cfg.Output, conns, httpConfig), nil
 
 	default:
 		return nil, fmt.Errorf("unsupported job type: %s", jobType)
@@ -294,7 +324,6 @@ func newBatchCmd() *cobra.Command {
 }
 
 func init() {
-	batchCmd.Flags().StringVar(&batchFlags.cookies, "cookies", "", "File name to read cookies from for yt-dlp")
 	batchCmd.Flags().StringVar(&batchFlags.cookiesFromBrowser, "cookies-from-browser", "", "Browser name to load cookies from for yt-dlp")
 	batchCmd.Flags().StringVar(&batchFlags.s3Profile, "s3-profile", "default", "AWS profile for S3 downloads")
 	batchCmd.Flags().StringVarP(&batchFlags.extract, "extract", "e", "", "Site-specific extractor for live streams")
@@ -148,6 +148,20 @@ func TestGetJobType(t *testing.T) {
 			overrideType: "",
 			want:         "live-stream",
 		},
+		{
+			name:         "mpd auto-detection",
+			prefix:       "",
+			rawURL:       "https://example.com/dash/manifest.mpd?token=abc",
+			overrideType: "",
+			want:         "live-stream",
+		},
+		{
+			name:         "extractor page auto-detection",
+			prefix:       "",
+			rawURL:       "https://www.dailymotion.com/video/x8abc12",
+			overrideType: "",
+			want:         "live-stream",
+		},
 		{
 			name:         "default http fallback",
 			prefix:       "",
@@ -275,6 +289,37 @@ func TestBuildJob(t *testing.T) {
 	}
 }
 
+func TestExpandBatchGlobs(t *testing.T) {
+	jobs := []YAMLJob{
+		{URL: "http::https://example.com/part[1-3].bin", Output: "out/part#1.bin", Connections: 2},
+		{URL: "https://example.com/{a,b}.iso"},
+		{URL: "magnet:?xt=urn:btih:abc&dn=[2024] release"},
+		{URL: "https://api.example.com/export?filter[name]=x&ids[]=1"},
+		{URL: "https://example.com/{a,b}/[1-2].bin", Globoff: true},
+	}
+	got, err := expandBatchGlobs(jobs)
+	if err != nil {
+		t.Fatalf("expandBatchGlobs failed: %v", err)
+	}
+	want := []YAMLJob{
+		{URL: "http::https://example.com/part1.bin", Output: "out/part1.bin", Connections: 2},
+		{URL: "http::https://example.com/part2.bin", Output: "out/part2.bin", Connections: 2},
+		{URL: "http::https://example.com/part3.bin", Output: "out/part3.bin", Connections: 2},
+		{URL: "https://example.com/a.iso"},
+		{URL: "https://example.com/b.iso"},
+		{URL: "magnet:?xt=urn:btih:abc&dn=[2024] release"},
+		{URL: "https://api.example.com/export?filter[name]=x&ids[]=1"},
+		{URL: "https://example.com/{a,b}/[1-2].bin", Globoff: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandBatchGlobs() = %+v, want %+v", got, want)
+	}
+
+	if _, err := expandBatchGlobs([]YAMLJob{{URL: "https://example.com/[1-2].bin", Output: "same.bin"}}); err == nil {
+		t.Error("expected a fixed output for several URLs to be rejected")
+	}
+}
+
 func TestParseBatchInputStdin(t *testing.T) {
 	// Test stdin parsing by mocking stdin
 	oldStdin := os.Stdin
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tanq16/danzo/utils"
+)
+
+var credentialsFlags struct {
+	username string
+	password string
+	token    string
+}
+
+func credentialsPath() string {
+	if credsFile != "" {
+		return credsFile
+	}
+	return utils.DefaultCredentialsPath()
+}
+
+func credentialsPassphrase() string {
+	if passphrase := utils.CredentialsPassphrase(); passphrase != "" {
+		return passphrase
+	}
+	passphrase, err := utils.PromptPassword("Credentials passphrase:")
+	if err != nil {
+		utils.PrintFatal("Failed to read passphrase", err)
+	}
+	if passphrase == "" {
+		utils.PrintFatal("Failed to read passphrase", errors.New("passphrase cannot be empty"))
+	}
+	return passphrase
+}
+
+func loadCredentialsStore() (string, string, map[string]utils.Credential) {
+	path := credentialsPath()
+	passphrase := credentialsPassphrase()
+	creds, err := utils.LoadCredentials(path, passphrase)
+	if err != nil {
+		utils.PrintFatal("Failed to unlock credentials file", err)
+	}
+	return path, passphrase, creds
+}
+
+func newCredentialsCmd() *cobra.Command {
+	credentialsCmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Manage the encrypted per-host credentials file",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add [HOST] [--username USER --password PASS | --token TOKEN]",
+		Short: "Store Basic or Bearer credentials for a host",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cred := utils.Credential{
+				Username: credentialsFlags.username,
+				Password: credentialsFlags.password,
+				Token:    credentialsFlags.token,
+			}
+			if cred.Token == "" && cred.Username == "" {
+				utils.PrintFatal("Invalid credentials", errors.New("either --username or --token is required"))
+			}
+			if cred.Token == "" && cred.Password == "" {
+				password, err := utils.PromptPassword("Password for " + cred.Username + ":")
+				if err != nil {
+					utils.PrintFatal("Failed to read password", err)
+				}
+				cred.Password = password
+			}
+			path, passphrase, creds := loadCredentialsStore()
+			creds[strings.ToLower(args[0])] = cred
+			if err := utils.SaveCredentials(path, passphrase, creds); err != nil {
+				utils.PrintFatal("Failed to save credentials", err)
+			}
+			utils.PrintSuccess("Stored credentials for " + args[0])
+		},
+	}
+	addCmd.Flags().StringVar(&credentialsFlags.username, "username", "", "Username for Basic auth")
+	addCmd.Flags().StringVar(&credentialsFlags.password, "password", "", "Password for Basic auth (prompted if omitted)")
+	addCmd.Flags().StringVar(&credentialsFlags.token, "token", "", "Token for Bearer auth")
+	addCmd.MarkFlagsMutuallyExclusive("username", "token")
+	addCmd.MarkFlagsMutuallyExclusive("password", "token")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [HOST]",
+		Short: "Remove the credentials stored for a host",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path, passphrase, creds := loadCredentialsStore()
+			host := strings.ToLower(args[0])
+			if _, ok := creds[host]; !ok {
+				utils.PrintFatal("Failed to remove credentials", errors.New("no credentials stored for "+args[0]))
+			}
+			delete(creds, host)
+			if err := utils.SaveCredentials(path, passphrase, creds); err != nil {
+				utils.PrintFatal("Failed to save credentials", err)
+			}
+			utils.PrintSuccess("Removed credentials for " + args[0])
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List hosts with stored credentials",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			_, _, creds := loadCredentialsStore()
+			hosts := make([]string, 0, len(creds))
+			for host := range creds {
+				hosts = append(hosts, host)
+			}
+			slices.Sort(hosts)
+			for _, host := range hosts {
+				kind := "basic (" + creds[host].Username + ")"
+				if creds[host].Token != "" {
+					kind = "bearer"
+				}
+				utils.PrintGeneric(host + "  " + kind)
+			}
+		},
+	}
+
+	credentialsCmd.AddCommand(addCmd, removeCmd, listCmd)
+	return credentialsCmd
+}
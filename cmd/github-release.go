@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 
@@ -22,12 +23,15 @@ var ghReleaseCmd = &cobra.Command{
 	Aliases: []string{"ghrelease", "ghr"},
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if ghReleaseFlags.manual && ghReleaseFlags.outputPath == utils.StdoutPath {
+			utils.PrintFatal("Invalid options", fmt.Errorf("--manual cannot be used with -o -"))
+		}
 		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 		defer cancel()
 
 		hw := newHighway()
 
-		disp := display.New(display.DefaultConfig())
+		disp := display.New(newDisplayConfig(ghReleaseFlags.outputPath))
 
 		job := ghreleasejob.New(args[0], ghReleaseFlags.outputPath, ghReleaseFlags.manual, globalHTTPConfig)
 		job.PauseDisplay = disp.Pause
@@ -50,6 +54,6 @@ func newGHReleaseCmd() *cobra.Command {
 }
 
 func init() {
-	ghReleaseCmd.Flags().StringVarP(&ghReleaseFlags.outputPath, "output", "o", "", "Output file path")
+	ghReleaseCmd.Flags().StringVarP(&ghReleaseFlags.outputPath, "output", "o", "", "Output file path (use - for stdout)")
 	ghReleaseCmd.Flags().BoolVar(&ghReleaseFlags.manual, "manual", false, "Manually select release version and asset")
 }
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"os"
+
+	"github.com/tanq16/danzo/internal/display"
 	"github.com/tanq16/danzo/internal/highway"
 	ghreleasejob "github.com/tanq16/danzo/internal/jobs/github-release"
 	httpjob "github.com/tanq16/danzo/internal/jobs/http"
@@ -8,6 +11,7 @@ import (
 	s3job "github.com/tanq16/danzo/internal/jobs/s3"
 	torrentjob "github.com/tanq16/danzo/internal/jobs/torrent"
 	ytdlpjob "github.com/tanq16/danzo/internal/jobs/ytdlp"
+	"github.com/tanq16/danzo/utils"
 )
 
 const resumeStatePath = ".danzo-resume-state.json"
@@ -26,3 +30,13 @@ func registerJobTypes(hw *highway.Highway) {
 	hw.RegisterType("ytdlp", ytdlpjob.Unmarshal)
 	hw.RegisterType("torrent", torrentjob.Unmarshal)
 }
+
+// newDisplayConfig moves the display to stderr when the download is streamed
+// to stdout, so progress output never mixes with piped data.
+func newDisplayConfig(outputPath string) display.Config {
+	cfg := display.DefaultConfig()
+	if outputPath == utils.StdoutPath {
+		cfg.Writer = os.Stderr
+	}
+	return cfg
+}
@@ -2,8 +2,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 	"github.com/tanq16/danzo/internal/display"
@@ -13,6 +19,15 @@ import (
 
 var httpFlags struct {
 	outputPath string
+	compressed bool
+	globoff    bool
+	recursive  bool
+	depth      int
+	include    []string
+	exclude    []string
+	minSize    string
+	maxSize    string
+	request    httpjob.HTTPRequest
 }
 
 var httpCmd = &cobra.Command{
@@ -25,11 +40,40 @@ var httpCmd = &cobra.Command{
 
 		hw := newHighway()
 
-		disp := display.New(display.DefaultConfig())
+		disp := display.New(newDisplayConfig(httpFlags.outputPath))
 
-		job := httpjob.New(args[0], httpFlags.outputPath, connections, globalHTTPConfig)
-		disp.RegisterJob(job.ID())
-		hw.Submit(job)
+		if httpFlags.request.DataFile == "-" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				utils.PrintFatal("Failed to read request body", err)
+			}
+			httpFlags.request.Data, httpFlags.request.DataFile = string(data), ""
+		}
+
+		if httpFlags.recursive {
+			jobs, err := crawlHTTPJobs(ctx, args[0])
+			if err != nil {
+				utils.PrintFatal("Crawl failed", err)
+			}
+			if len(jobs) == 0 {
+				utils.PrintInfo("No files matched under " + args[0])
+				return
+			}
+			utils.PrintInfo(fmt.Sprintf("Found %d files under %s", len(jobs), args[0]))
+			for _, job := range jobs {
+				disp.RegisterJob(job.ID())
+				hw.Submit(job)
+			}
+		} else {
+			jobs, err := expandHTTPJobs(args[0])
+			if err != nil {
+				utils.PrintFatal("Invalid URL", err)
+			}
+			for _, job := range jobs {
+				disp.RegisterJob(job.ID())
+				hw.Submit(job)
+			}
+		}
 
 		disp.Start(hw.Progress())
 		err := hw.Run(ctx)
@@ -41,10 +85,102 @@ var httpCmd = &cobra.Command{
 	},
 }
 
+// expandHTTPJobs expands curl-style URL globs into one job per URL, filling
+// #1, #2, ... in --output with the glob values. --globoff takes the URL as is.
+func expandHTTPJobs(rawURL string) ([]*httpjob.HTTPJob, error) {
+	if httpFlags.globoff {
+		return []*httpjob.HTTPJob{httpjob.New(rawURL, httpFlags.outputPath, connections, httpFlags.compressed, httpFlags.request, globalHTTPConfig)}, nil
+	}
+	matches, err := utils.ExpandURLGlob(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	output := httpFlags.outputPath
+	if len(matches) > 1 && output != "" && !utils.HasOutputTemplate(output) {
+		return nil, fmt.Errorf("URL expands to %d files; use #1-style placeholders in --output or omit it", len(matches))
+	}
+	var jobs []*httpjob.HTTPJob
+	for _, match := range matches {
+		outputPath := utils.ApplyOutputTemplate(output, match.Values)
+		jobs = append(jobs, httpjob.New(match.URL, outputPath, connections, httpFlags.compressed, httpFlags.request, globalHTTPConfig))
+	}
+	return jobs, nil
+}
+
+// crawlHTTPJobs crawls the directory index at rootURL and returns one job per
+// file, with the directory tree recreated under --output (by default the name
+// of the starting directory).
+func crawlHTTPJobs(ctx context.Context, rootURL string) ([]*httpjob.HTTPJob, error) {
+	if httpFlags.outputPath == utils.StdoutPath {
+		return nil, errors.New("--recursive cannot write to stdout")
+	}
+	if httpFlags.request != (httpjob.HTTPRequest{}) {
+		return nil, errors.New("--recursive only sends plain GET requests")
+	}
+	opts := httpjob.CrawlOptions{
+		MaxDepth: httpFlags.depth,
+		Include:  httpFlags.include,
+		Exclude:  httpFlags.exclude,
+		OnSkip: func(dirURL string, err error) {
+			utils.PrintWarn(fmt.Sprintf("Skipping %s: %v", dirURL, err), err)
+		},
+	}
+	var err error
+	if httpFlags.minSize != "" {
+		if opts.MinSize, err = utils.ParseSize(httpFlags.minSize); err != nil {
+			return nil, err
+		}
+	}
+	if httpFlags.maxSize != "" {
+		if opts.MaxSize, err = utils.ParseSize(httpFlags.maxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	outputDir := httpFlags.outputPath
+	if outputDir == "" {
+		u, err := url.Parse(rootURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL: %v", err)
+		}
+		outputDir = path.Base(u.Path)
+		if outputDir == "/" || outputDir == "." {
+			outputDir = u.Hostname()
+		}
+	}
+
+	entries, err := httpjob.Crawl(ctx, rootURL, opts, utils.NewDanzoHTTPClient(globalHTTPConfig))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*httpjob.HTTPJob
+	for _, entry := range entries {
+		outputPath := filepath.Join(outputDir, filepath.FromSlash(entry.Path))
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory: %v", err)
+		}
+		jobs = append(jobs, httpjob.New(entry.URL, outputPath, connections, httpFlags.compressed, httpjob.HTTPRequest{}, globalHTTPConfig))
+	}
+	return jobs, nil
+}
+
 func newHTTPCmd() *cobra.Command {
 	return httpCmd
 }
 
 func init() {
-	httpCmd.Flags().StringVarP(&httpFlags.outputPath, "output", "o", "", "Output file path")
+	httpCmd.Flags().StringVarP(&httpFlags.outputPath, "output", "o", "", "Output file path (use - for stdout)")
+	httpCmd.Flags().BoolVar(&httpFlags.compressed, "compressed", false, "Request a compressed response (gzip, deflate, br, zstd) and decode it")
+	httpCmd.Flags().BoolVarP(&httpFlags.globoff, "globoff", "g", false, "Take [] and {} in the URL literally instead of expanding them")
+	httpCmd.Flags().BoolVarP(&httpFlags.recursive, "recursive", "r", false, "Download everything under a directory index page (output is a directory)")
+	httpCmd.Flags().IntVar(&httpFlags.depth, "depth", 5, "Subdirectory levels to descend with --recursive (-1 for unlimited)")
+	httpCmd.Flags().StringSliceVar(&httpFlags.include, "include", []string{}, "Only download files matching these globs with --recursive")
+	httpCmd.Flags().StringSliceVar(&httpFlags.exclude, "exclude", []string{}, "Skip files and directories matching these globs with --recursive")
+	httpCmd.Flags().StringVar(&httpFlags.minSize, "min-size", "", "Skip files smaller than this with --recursive (e.g. 10K)")
+	httpCmd.Flags().StringVar(&httpFlags.maxSize, "max-size", "", "Skip files larger than this with --recursive (e.g. 2G)")
+	httpCmd.Flags().StringVarP(&httpFlags.request.Method, "request", "X", "", "HTTP method to use (default GET, or POST with --data)")
+	httpCmd.Flags().StringVarP(&httpFlags.request.Data, "data", "d", "", "Request body to send")
+	httpCmd.Flags().StringVar(&httpFlags.request.DataFile, "data-file", "", "File to send as the request body (- for stdin)")
+	httpCmd.Flags().StringVar(&httpFlags.request.ContentType, "content-type", "", "Content-Type of the request body (default: JSON if it parses, else form)")
+	httpCmd.MarkFlagsMutuallyExclusive("data", "data-file")
 }
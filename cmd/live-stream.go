@@ -2,9 +2,10 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
-	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/tanq16/danzo/internal/display"
@@ -13,34 +14,77 @@ import (
 )
 
 var m3u8Flags struct {
-	outputPath string
-	extract    string
+	outputPath   string
+	extract      string
+	duration     time.Duration
+	quality      string
+	codec        string
+	audio        []string
+	subtitles    []string
+	subFormat    string
+	ffmpeg       string
+	maxMissing   int
+	listVariants bool
+	listExtract  bool
 }
 
 var m3u8Cmd = &cobra.Command{
-	Use:     "live-stream [URL] [--output OUTPUT_PATH] [--extract EXTRACTOR]",
-	Short:   "Download HLS/M3U8 live streams",
-	Aliases: []string{"hls", "m3u8", "livestream", "stream"},
-	Args:    cobra.ExactArgs(1),
+	Use:     "live-stream [URL] [--output OUTPUT_PATH] [--extract EXTRACTOR] [--duration DURATION]",
+	Short:   "Download HLS/M3U8 and MPEG-DASH streams",
+	Aliases: []string{"hls", "m3u8", "dash", "livestream", "stream"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if m3u8Flags.listExtract {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if m3u8Flags.listExtract {
+			utils.PrintInfo("Extractors")
+			for _, name := range m3u8job.ExtractorNames() {
+				utils.PrintGeneric("  " + name)
+			}
+			return
+		}
 		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 		defer cancel()
 
 		url := args[0]
 		extract := m3u8Flags.extract
-		if extract == "" {
-			if strings.Contains(url, "dailymotion.com") || strings.Contains(url, "dai.ly") {
-				extract = "dailymotion"
-			} else if strings.Contains(url, "rumble.com") {
-				extract = "rumble"
-			}
+
+		if m3u8Flags.listVariants {
+			listVariants(ctx, url, extract)
+			return
+		}
+		if err := m3u8job.ValidateQuality(m3u8Flags.quality); err != nil {
+			utils.PrintFatal("Invalid stream options", err)
+		}
+		if m3u8Flags.subFormat != "vtt" && m3u8Flags.subFormat != "srt" {
+			utils.PrintFatal("Invalid stream options", fmt.Errorf("unsupported subtitle format %q (want vtt or srt)", m3u8Flags.subFormat))
+		}
+		if m3u8Flags.maxMissing < 0 {
+			utils.PrintFatal("Invalid stream options", fmt.Errorf("--max-missing must not be negative"))
+		}
+		if ffmpeg, err := m3u8job.FindFFmpeg(m3u8Flags.ffmpeg); err != nil {
+			utils.PrintFatal("Invalid stream options", err)
+		} else if ffmpeg == "" {
+			utils.PrintWarn("ffmpeg not found, segments will be joined natively (MPEG-TS streams are saved as .ts, separate audio next to the video)", nil)
 		}
 
 		hw := newHighway()
 
 		disp := display.New(display.DefaultConfig())
 
-		job := m3u8job.New(url, m3u8Flags.outputPath, connections, extract, globalHTTPConfig)
+		job := m3u8job.New(url, m3u8Flags.outputPath, connections, extract, m3u8job.Options{
+			Duration:       m3u8Flags.duration,
+			Quality:        m3u8Flags.quality,
+			Codec:          m3u8Flags.codec,
+			Audio:          m3u8Flags.audio,
+			Subtitles:      m3u8Flags.subtitles,
+			SubtitleFormat: m3u8Flags.subFormat,
+			FFmpeg:         m3u8Flags.ffmpeg,
+			MaxMissing:     m3u8Flags.maxMissing,
+		}, globalHTTPConfig)
 		disp.RegisterJob(job.ID())
 		hw.Submit(job)
 
@@ -54,11 +98,39 @@ var m3u8Cmd = &cobra.Command{
 	},
 }
 
+// listVariants prints the variants and renditions of a master playlist.
+func listVariants(ctx context.Context, url, extract string) {
+	master, err := m3u8job.ListVariants(ctx, url, extract, globalHTTPConfig)
+	if err != nil {
+		utils.PrintFatal("Failed to list variants", err)
+	}
+	utils.PrintInfo("Variants")
+	for _, v := range master.Variants {
+		utils.PrintGeneric("  " + v.String())
+	}
+	if len(master.Renditions) > 0 {
+		utils.PrintInfo("Renditions")
+		for _, r := range master.Renditions {
+			utils.PrintGeneric("  " + r.String())
+		}
+	}
+}
+
 func newM3U8Cmd() *cobra.Command {
 	return m3u8Cmd
 }
 
 func init() {
 	m3u8Cmd.Flags().StringVarP(&m3u8Flags.outputPath, "output", "o", "", "Output file path (default: stream_[timestamp].mp4)")
-	m3u8Cmd.Flags().StringVarP(&m3u8Flags.extract, "extract", "e", "", "Site-specific extractor to use (e.g., rumble, dailymotion)")
+	m3u8Cmd.Flags().StringVarP(&m3u8Flags.extract, "extract", "e", "", "Site-specific extractor to use (default: picked by URL, see --list-extractors)")
+	m3u8Cmd.Flags().DurationVar(&m3u8Flags.duration, "duration", 0, "Stop after this much media time (e.g., 30m, 1h); live streams are recorded until they end or Ctrl-C otherwise")
+	m3u8Cmd.Flags().StringVarP(&m3u8Flags.quality, "quality", "q", "best", "Variant to download: best, worst, a height (720p) or a bandwidth (2500k)")
+	m3u8Cmd.Flags().StringVar(&m3u8Flags.codec, "codec", "", "Preferred video codec (avc, hevc, av1, vp9)")
+	m3u8Cmd.Flags().StringSliceVar(&m3u8Flags.audio, "audio", nil, "Audio renditions to download by language (en) or name; extra ones are added as tracks")
+	m3u8Cmd.Flags().StringSliceVar(&m3u8Flags.subtitles, "subs", nil, "Subtitle renditions to download by language or name, or \"all\"")
+	m3u8Cmd.Flags().StringVar(&m3u8Flags.subFormat, "sub-format", "vtt", "Subtitle file format (vtt or srt)")
+	m3u8Cmd.Flags().StringVar(&m3u8Flags.ffmpeg, "ffmpeg", "", "Path to the ffmpeg binary used for merging (default: ffmpeg on PATH, joining natively without it)")
+	m3u8Cmd.Flags().IntVar(&m3u8Flags.maxMissing, "max-missing", 0, "Segments per track allowed to fail after retries; they are left out and reported instead of failing the download")
+	m3u8Cmd.Flags().BoolVar(&m3u8Flags.listVariants, "list-variants", false, "List the variants and renditions of a master playlist and exit")
+	m3u8Cmd.Flags().BoolVar(&m3u8Flags.listExtract, "list-extractors", false, "List the site-specific extractors and exit")
 }
@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -17,8 +19,31 @@ var (
 	proxyURL      string
 	proxyUsername string
 	proxyPassword string
+	noProxy       string
 	userAgent     string
 	headers       []string
+	cookiesFile   string
+	netrcFile     string
+	noNetrc       bool
+	credsFile     string
+	authUser      string
+	authPassword  string
+	caCertFile    string
+	certFile      string
+	keyFile       string
+	certPassword  string
+	insecure      bool
+	minTLSVersion string
+	resolveHosts  []string
+	forceIPv4     bool
+	forceIPv6     bool
+	bindIface     string
+	localAddress  string
+	bindAddrs     []string
+	httpVersion   string
+	http2Prior    bool
+	writeXattrs   bool
+	syncMode      bool
 	workers       int
 	connections   int
 	debugFlag     bool
@@ -33,21 +58,147 @@ var rootCmd = &cobra.Command{
 	Version:           AppVersion,
 	CompletionOptions: cobra.CompletionOptions{HiddenDefaultCmd: true},
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if output := cmd.Flags().Lookup("output"); output != nil && output.Value.String() == utils.StdoutPath {
+			// keep messages and logs out of the streamed bytes
+			utils.Messages = os.Stderr
+			setupLogs()
+		}
+		if cookiesFile != "" {
+			if _, err := utils.LoadNetscapeCookies(cookiesFile); err != nil {
+				utils.PrintFatal("Failed to load cookies file", err)
+			}
+		}
+		authPassword = resolveAuthPassword()
+		if http2Prior {
+			if httpVersion != "" && httpVersion != "2" {
+				utils.PrintFatal("Invalid HTTP version", fmt.Errorf("--http2-prior-knowledge cannot be combined with --http-version %s", httpVersion))
+			}
+			httpVersion = utils.HTTP2PriorKnowledge
+		}
 		globalHTTPConfig = utils.HTTPClientConfig{
-			Jar:           nil,
-			ProxyURL:      proxyURL,
-			ProxyUsername: proxyUsername,
-			ProxyPassword: proxyPassword,
-			UserAgent:     userAgent,
-			Headers:       utils.ParseHeaderArgs(headers),
+			Jar:             nil,
+			CookiesFile:     cookiesFile,
+			NetrcFile:       resolveNetrcFile(),
+			CredentialsFile: resolveCredentialsFile(cmd),
+			ProxyURL:        proxyURL,
+			ProxyUsername:   proxyUsername,
+			ProxyPassword:   proxyPassword,
+			NoProxy:         noProxy,
+			Username:        authUser,
+			Password:        authPassword,
+			CACertFile:      caCertFile,
+			CertFile:        certFile,
+			KeyFile:         keyFile,
+			CertPassword:    certPassword,
+			Insecure:        insecure,
+			MinTLSVersion:   minTLSVersion,
+			Resolve:         resolveHosts,
+			IPVersion:       ipVersion(),
+			Interface:       bindIface,
+			LocalAddress:    localAddress,
+			Bind:            bindAddrs,
+			HTTPVersion:     httpVersion,
+			Xattrs:          writeXattrs,
+			Sync:            syncMode,
+			UserAgent:       userAgent,
+			Headers:         utils.ParseHeaderArgs(headers),
+		}
+		if err := utils.ValidateProxy(globalHTTPConfig); err != nil {
+			utils.PrintFatal("Invalid proxy configuration", err)
+		}
+		if err := utils.ValidateDialOptions(globalHTTPConfig); err != nil {
+			utils.PrintFatal("Invalid network options", err)
+		}
+		if err := utils.ValidateHTTPVersion(globalHTTPConfig); err != nil {
+			utils.PrintFatal("Invalid HTTP version", err)
+		}
+		if writeXattrs && runtime.GOOS != "linux" {
+			utils.PrintFatal("Invalid metadata options", fmt.Errorf("--xattr is only supported on Linux"))
+		}
+		if _, err := utils.BuildTLSConfig(globalHTTPConfig); err != nil {
+			utils.PrintFatal("Invalid TLS options", err)
 		}
 	},
 }
 
+func ipVersion() int {
+	if forceIPv4 {
+		return 4
+	}
+	if forceIPv6 {
+		return 6
+	}
+	return 0
+}
+
+// resolveAuthPassword accepts curl-style --user name:password and prompts for
+// the password when only a username is given.
+func resolveAuthPassword() string {
+	if authUser == "" || authPassword != "" {
+		return authPassword
+	}
+	if name, password, ok := strings.Cut(authUser, ":"); ok {
+		authUser = name
+		return password
+	}
+	password, err := utils.PromptPassword("Password for " + authUser + ":")
+	if err != nil {
+		utils.PrintFatal("Failed to read password", err)
+	}
+	return password
+}
+
+func resolveNetrcFile() string {
+	if noNetrc {
+		return ""
+	}
+	if netrcFile != "" {
+		if _, err := utils.LoadNetrc(netrcFile); err != nil {
+			utils.PrintFatal("Failed to load netrc file", err)
+		}
+		return netrcFile
+	}
+	path := utils.DefaultNetrcPath()
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// resolveCredentialsFile unlocks an explicit --credentials-file (prompting for
+// the passphrase if needed). The default file is only used when the passphrase
+// is available from the environment, so plain downloads never prompt.
+func resolveCredentialsFile(cmd *cobra.Command) string {
+	if cmd.Name() == "credentials" || (cmd.Parent() != nil && cmd.Parent().Name() == "credentials") {
+		return ""
+	}
+	if credsFile == "" {
+		path := utils.DefaultCredentialsPath()
+		if _, err := os.Stat(path); err != nil || utils.CredentialsPassphrase() == "" {
+			return ""
+		}
+		if err := utils.UnlockCredentials(path); err != nil {
+			utils.PrintFatal("Failed to unlock credentials file", err)
+		}
+		return path
+	}
+	if utils.CredentialsPassphrase() == "" {
+		passphrase, err := utils.PromptPassword("Credentials passphrase:")
+		if err != nil {
+			utils.PrintFatal("Failed to read passphrase", err)
+		}
+		utils.SetCredentialsPassphrase(passphrase)
+	}
+	if err := utils.UnlockCredentials(credsFile); err != nil {
+		utils.PrintFatal("Failed to unlock credentials file", err)
+	}
+	return credsFile
+}
+
 func setupLogs() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	output := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        utils.Messages,
 		TimeFormat: time.DateTime,
 		NoColor:    false,
 	}
@@ -79,11 +230,39 @@ func init() {
 	rootCmd.MarkFlagsMutuallyExclusive("debug", "for-ai")
 	cobra.OnInitialize(setupLogs)
 
-	rootCmd.PersistentFlags().StringVarP(&proxyURL, "proxy", "p", "", "HTTP/HTTPS proxy URL")
+	rootCmd.PersistentFlags().StringVarP(&proxyURL, "proxy", "p", "", "Proxy URL (http, https, socks5, socks5h)")
 	rootCmd.PersistentFlags().StringVar(&proxyUsername, "proxy-username", "", "Proxy username")
 	rootCmd.PersistentFlags().StringVar(&proxyPassword, "proxy-password", "", "Proxy password")
+	rootCmd.PersistentFlags().StringVar(&noProxy, "noproxy", "", "Comma-separated hosts, domains or CIDRs to reach directly (default $NO_PROXY)")
 	rootCmd.PersistentFlags().StringVarP(&userAgent, "user-agent", "a", "Danzo-CLI", "User agent")
 	rootCmd.PersistentFlags().StringArrayVarP(&headers, "header", "H", []string{}, "Custom headers")
+	rootCmd.PersistentFlags().StringVar(&cookiesFile, "cookies", "", "Netscape-format cookies file (cookies.txt)")
+	rootCmd.PersistentFlags().StringVar(&netrcFile, "netrc-file", "", "Netrc file for host credentials (default ~/.netrc)")
+	rootCmd.PersistentFlags().BoolVar(&noNetrc, "no-netrc", false, "Do not read credentials from netrc")
+	rootCmd.PersistentFlags().StringVar(&credsFile, "credentials-file", "", "Encrypted credentials file (see danzo credentials)")
+	rootCmd.MarkFlagsMutuallyExclusive("netrc-file", "no-netrc")
+	rootCmd.PersistentFlags().StringVarP(&authUser, "user", "u", "", "Username (or user:password) for Basic/Digest server auth")
+	rootCmd.PersistentFlags().StringVar(&authPassword, "password", "", "Password for Basic/Digest server auth (prompted if omitted)")
+	rootCmd.PersistentFlags().StringVar(&caCertFile, "cacert", "", "PEM bundle of additional CA certificates to trust")
+	rootCmd.PersistentFlags().StringVar(&certFile, "cert", "", "Client certificate (PEM or PKCS#12 .p12/.pfx)")
+	rootCmd.PersistentFlags().StringVar(&keyFile, "key", "", "Client private key (PEM, if not in --cert)")
+	rootCmd.PersistentFlags().StringVar(&certPassword, "cert-password", "", "Password for a PKCS#12 client certificate")
+	rootCmd.PersistentFlags().BoolVarP(&insecure, "insecure", "k", false, "Skip TLS certificate verification")
+	rootCmd.PersistentFlags().StringVar(&minTLSVersion, "tls-min-version", "", "Minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	rootCmd.PersistentFlags().StringArrayVar(&resolveHosts, "resolve", []string{}, "Resolve host:port to addr[,addr] (repeatable)")
+	rootCmd.PersistentFlags().BoolVarP(&forceIPv4, "ipv4", "4", false, "Connect over IPv4 only")
+	rootCmd.PersistentFlags().BoolVarP(&forceIPv6, "ipv6", "6", false, "Connect over IPv6 only")
+	rootCmd.PersistentFlags().StringVar(&bindIface, "interface", "", "Network interface (or address) to connect from")
+	rootCmd.PersistentFlags().StringVar(&localAddress, "local-address", "", "Local IP address to connect from")
+	rootCmd.MarkFlagsMutuallyExclusive("ipv4", "ipv6")
+	rootCmd.PersistentFlags().StringSliceVar(&bindAddrs, "bind", []string{}, "Stripe chunk connections across interfaces or addresses (comma-separated)")
+	rootCmd.PersistentFlags().StringVar(&httpVersion, "http-version", "", "HTTP version to use (1.1, 2 or 3)")
+	rootCmd.PersistentFlags().BoolVar(&http2Prior, "http2-prior-knowledge", false, "Use HTTP/2 without negotiation, including h2c for http:// URLs")
+	rootCmd.MarkFlagsMutuallyExclusive("interface", "local-address")
+	rootCmd.MarkFlagsMutuallyExclusive("bind", "interface")
+	rootCmd.MarkFlagsMutuallyExclusive("bind", "local-address")
+	rootCmd.PersistentFlags().BoolVar(&writeXattrs, "xattr", false, "Record the source URL and SHA-256 in extended attributes (Linux)")
+	rootCmd.PersistentFlags().BoolVar(&syncMode, "sync", false, "Only re-download existing files that changed remotely (http, s3, ghr)")
 	rootCmd.PersistentFlags().IntVarP(&workers, "workers", "w", 1, "Number of parallel workers")
 	rootCmd.PersistentFlags().IntVarP(&connections, "connections", "c", 8, "Number of connections per download")
 
@@ -96,4 +275,5 @@ func init() {
 	rootCmd.AddCommand(newYtdlpCmd())
 	rootCmd.AddCommand(newTorrentCmd())
 	rootCmd.AddCommand(newBatchCmd())
+	rootCmd.AddCommand(newCredentialsCmd())
 }
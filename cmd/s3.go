@@ -26,9 +26,9 @@ var s3Cmd = &cobra.Command{
 
 		hw := newHighway()
 
-		disp := display.New(display.DefaultConfig())
+		disp := display.New(newDisplayConfig(s3Flags.outputPath))
 
-		job := s3job.New(args[0], s3Flags.outputPath, connections, s3Flags.profile)
+		job := s3job.New(args[0], s3Flags.outputPath, connections, s3Flags.profile, globalHTTPConfig)
 		disp.RegisterJob(job.ID())
 		hw.Submit(job)
 
@@ -47,6 +47,6 @@ func newS3Cmd() *cobra.Command {
 }
 
 func init() {
-	s3Cmd.Flags().StringVarP(&s3Flags.outputPath, "output", "o", "", "Output path")
+	s3Cmd.Flags().StringVarP(&s3Flags.outputPath, "output", "o", "", "Output path (use - for stdout)")
 	s3Cmd.Flags().StringVar(&s3Flags.profile, "profile", "default", "AWS profile to use")
 }
@@ -13,7 +13,6 @@ import (
 
 var ytdlpFlags struct {
 	outputPath         string
-	cookies            string
 	cookiesFromBrowser string
 }
 
@@ -30,7 +29,7 @@ var ytdlpCmd = &cobra.Command{
 
 		disp := display.New(display.DefaultConfig())
 
-		job := ytdlpjob.New(args[0], ytdlpFlags.outputPath, ytdlpFlags.cookies, ytdlpFlags.cookiesFromBrowser, globalHTTPConfig)
+		job := ytdlpjob.New(args[0], ytdlpFlags.outputPath, cookiesFile, ytdlpFlags.cookiesFromBrowser, globalHTTPConfig)
 		disp.RegisterJob(job.ID())
 		hw.Submit(job)
 
@@ -50,6 +49,5 @@ func newYtdlpCmd() *cobra.Command {
 
 func init() {
 	ytdlpCmd.Flags().StringVarP(&ytdlpFlags.outputPath, "output", "o", "", "Output path for the download")
-	ytdlpCmd.Flags().StringVar(&ytdlpFlags.cookies, "cookies", "", "File name to read cookies from")
 	ytdlpCmd.Flags().StringVar(&ytdlpFlags.cookiesFromBrowser, "cookies-from-browser", "", "Browser name to load cookies from (e.g. chrome, firefox)")
 }
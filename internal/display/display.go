@@ -2,6 +2,8 @@ package display
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +51,7 @@ type Config struct {
 	MaxVisibleJobs int
 	RefreshRate    time.Duration
 	BoxWidth       int
+	Writer         io.Writer
 }
 
 func DefaultConfig() Config {
@@ -56,6 +59,7 @@ func DefaultConfig() Config {
 		MaxVisibleJobs: 5,
 		RefreshRate:    200 * time.Millisecond,
 		BoxWidth:       72,
+		Writer:         os.Stdout,
 	}
 }
 
@@ -77,6 +81,9 @@ type Display struct {
 }
 
 func New(config Config) *Display {
+	if config.Writer == nil {
+		config.Writer = os.Stdout
+	}
 	return &Display{
 		config: config,
 		jobs:   make(map[string]*JobState),
@@ -120,6 +127,9 @@ func (d *Display) Update(update highway.Progress) {
 		} else {
 			job.Status = StatusCompleted
 			job.Message = "Done"
+			if update.Message != "" {
+				job.Message = update.Message
+			}
 			d.removeFromSlice(&d.running, update.JobID)
 			d.removeFromSlice(&d.pending, update.JobID)
 			d.completed = append(d.completed, update.JobID)
@@ -195,23 +205,27 @@ func (d *Display) startAI(updates <-chan highway.Progress) {
 			d.Update(update)
 			if update.Done {
 				if update.Error != nil {
-					fmt.Printf("[ERROR] %s: %s\n", update.JobID, update.ErrMsg)
+					fmt.Fprintf(d.config.Writer, "[ERROR] %s: %s\n", update.JobID, update.ErrMsg)
 				} else {
-					fmt.Printf("[OK] %s: Done\n", update.JobID)
+					message := "Done"
+					if update.Message != "" {
+						message = update.Message
+					}
+					fmt.Fprintf(d.config.Writer, "[OK] %s: %s\n", update.JobID, message)
 				}
 				continue
 			}
 			if update.Type == highway.ProgressTypeProgress && update.Total > 0 {
 				percent := int(float64(update.Current) / float64(update.Total) * 100)
 				if update.Extra != "" {
-					fmt.Printf("[INFO] %s: %s %d%% %s\n", update.JobID, update.Message, percent, update.Extra)
+					fmt.Fprintf(d.config.Writer, "[INFO] %s: %s %d%% %s\n", update.JobID, update.Message, percent, update.Extra)
 				} else {
-					fmt.Printf("[INFO] %s: %s %d%%\n", update.JobID, update.Message, percent)
+					fmt.Fprintf(d.config.Writer, "[INFO] %s: %s %d%%\n", update.JobID, update.Message, percent)
 				}
 			} else if update.SubStatus != "" {
-				fmt.Printf("[INFO] %s: %s - %s\n", update.JobID, update.Message, update.SubStatus)
+				fmt.Fprintf(d.config.Writer, "[INFO] %s: %s - %s\n", update.JobID, update.Message, update.SubStatus)
 			} else if update.Message != "" {
-				fmt.Printf("[INFO] %s: %s\n", update.JobID, update.Message)
+				fmt.Fprintf(d.config.Writer, "[INFO] %s: %s\n", update.JobID, update.Message)
 			}
 		}
 	}()
@@ -234,8 +248,8 @@ func (d *Display) Resume() {
 func (d *Display) clearDisplay() {
 	if d.lastLineCount > 0 {
 		for range d.lastLineCount {
-			fmt.Print("\033[A")
-			fmt.Print("\033[K")
+			fmt.Fprint(d.config.Writer, "\033[A")
+			fmt.Fprint(d.config.Writer, "\033[K")
 		}
 	}
 }
@@ -252,7 +266,7 @@ func (d *Display) render() {
 
 	lines := d.buildDisplay()
 	output := strings.Join(lines, "\n")
-	fmt.Println(output)
+	fmt.Fprintln(d.config.Writer, output)
 
 	d.lastLineCount = len(lines)
 }
@@ -267,10 +281,10 @@ func (d *Display) renderFinal() {
 
 	if utils.GlobalForAIFlag {
 		if failedCount == 0 {
-			fmt.Printf("[OK] All %d jobs completed successfully\n", total)
+			fmt.Fprintf(d.config.Writer, "[OK] All %d jobs completed successfully\n", total)
 		} else {
-			fmt.Printf("[OK] %d completed\n", completedCount)
-			fmt.Printf("[ERROR] %d failed\n", failedCount)
+			fmt.Fprintf(d.config.Writer, "[OK] %d completed\n", completedCount)
+			fmt.Fprintf(d.config.Writer, "[ERROR] %d failed\n", failedCount)
 		}
 		return
 	}
@@ -280,10 +294,10 @@ func (d *Display) renderFinal() {
 	dimStyle := lipgloss.NewStyle().Foreground(colorSubtext0)
 
 	if failedCount == 0 {
-		fmt.Println(successStyle.Render(fmt.Sprintf("✓ All %d jobs completed successfully", total)))
+		fmt.Fprintln(d.config.Writer, successStyle.Render(fmt.Sprintf("✓ All %d jobs completed successfully", total)))
 	} else {
-		fmt.Println(successStyle.Render(fmt.Sprintf("✓ %d completed", completedCount)) +
-			dimStyle.Render("  ") +
+		fmt.Fprintln(d.config.Writer, successStyle.Render(fmt.Sprintf("✓ %d completed", completedCount))+
+			dimStyle.Render("  ")+
 			failStyle.Render(fmt.Sprintf("✗ %d failed", failedCount)))
 	}
 }
@@ -43,6 +43,12 @@ func TestDisplayUpdateMovesJobThroughRunningAndCompletedStates(t *testing.T) {
 	if len(d.running) != 0 || len(d.completed) != 1 || d.completed[0] != "job-1" {
 		t.Fatalf("expected job in completed set, running=%#v completed=%#v", d.running, d.completed)
 	}
+
+	d.RegisterJob("job-3")
+	d.Update(highway.Progress{JobID: "job-3", Done: true, Message: "Unchanged"})
+	if job := d.jobs["job-3"]; job.Status != StatusCompleted || job.Message != "Unchanged" {
+		t.Fatalf("expected the job's own done message, got %#v", job)
+	}
 }
 
 func TestDisplayUpdateRecordsFailedJobsEvenWhenTheyWereNotRegistered(t *testing.T) {
@@ -92,7 +92,8 @@ func (h *Highway) saveState() error {
 		return err
 	}
 
-	fmt.Printf("\nState saved to %s\n", h.statePath)
+	// stderr, as stdout may be carrying a streamed download
+	fmt.Fprintf(os.Stderr, "\nState saved to %s\n", h.statePath)
 	return nil
 }
 
@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tanq16/danzo/utils"
 )
@@ -136,6 +137,23 @@ func getGitHubReleaseAssets(ctx context.Context, owner, repo string, client *uti
 	return assetList, tagName, nil
 }
 
+// assetUpdatedAt returns when the asset behind downloadURL was last uploaded,
+// or the zero time if the release does not say.
+func assetUpdatedAt(assets []map[string]any, downloadURL string) time.Time {
+	for _, asset := range assets {
+		if u, _ := asset["browser_download_url"].(string); u != downloadURL {
+			continue
+		}
+		updated, _ := asset["updated_at"].(string)
+		t, err := time.Parse(time.RFC3339, updated)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+	return time.Time{}
+}
+
 func promptGitHubAssetSelection(assets []map[string]any, tagName string) (string, int64, error) {
 	fmt.Printf("Release: %s\nAvailable assets:\n", tagName)
 	for i, asset := range assets {
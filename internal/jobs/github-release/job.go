@@ -3,7 +3,9 @@ package ghrelease
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"runtime"
 	"strings"
 	"time"
@@ -24,17 +26,33 @@ type GHReleaseJob struct {
 }
 
 type ghReleaseJobState struct {
-	URL        string            `json:"url"`
-	OutputPath string            `json:"outputPath"`
-	Manual     bool              `json:"manual"`
-	ProxyURL   string            `json:"proxyURL,omitempty"`
-	UserAgent  string            `json:"userAgent,omitempty"`
-	Headers    map[string]string `json:"headers,omitempty"`
+	URL             string            `json:"url"`
+	OutputPath      string            `json:"outputPath"`
+	Manual          bool              `json:"manual"`
+	ProxyURL        string            `json:"proxyURL,omitempty"`
+	NoProxy         string            `json:"noProxy,omitempty"`
+	UserAgent       string            `json:"userAgent,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	CookiesFile     string            `json:"cookiesFile,omitempty"`
+	NetrcFile       string            `json:"netrcFile,omitempty"`
+	CredentialsFile string            `json:"credentialsFile,omitempty"`
+	CACertFile      string            `json:"caCertFile,omitempty"`
+	CertFile        string            `json:"certFile,omitempty"`
+	KeyFile         string            `json:"keyFile,omitempty"`
+	Insecure        bool              `json:"insecure,omitempty"`
+	MinTLSVersion   string            `json:"minTLSVersion,omitempty"`
+	Resolve         []string          `json:"resolve,omitempty"`
+	IPVersion       int               `json:"ipVersion,omitempty"`
+	Interface       string            `json:"interface,omitempty"`
+	LocalAddress    string            `json:"localAddress,omitempty"`
+	HTTPVersion     string            `json:"httpVersion,omitempty"`
+	Xattrs          bool              `json:"xattrs,omitempty"`
+	Sync            bool              `json:"sync,omitempty"`
 }
 
 func New(url, outputPath string, manual bool, httpConfig utils.HTTPClientConfig) *GHReleaseJob {
 	id := outputPath
-	if id == "" {
+	if id == "" || id == utils.StdoutPath {
 		id = url
 	}
 	return &GHReleaseJob{
@@ -53,6 +71,9 @@ func (j *GHReleaseJob) ID() string {
 func (j *GHReleaseJob) Type() string { return "github-release" }
 
 func (j *GHReleaseJob) Run(ctx context.Context, progress chan<- highway.Progress) error {
+	if j.Manual && j.OutputPath == utils.StdoutPath {
+		return fmt.Errorf("manual asset selection cannot be used when streaming to stdout")
+	}
 	owner, repo, err := parseGitHubURL(j.URL)
 	if err != nil {
 		return err
@@ -89,6 +110,23 @@ func (j *GHReleaseJob) Run(ctx context.Context, progress chan<- highway.Progress
 	if j.OutputPath == "" {
 		j.OutputPath = filename
 	}
+	updatedAt := assetUpdatedAt(assets, downloadURL)
+	// a nightly tag is often re-used for new uploads, so the upload time is
+	// part of what identifies the asset
+	validator := tagName + "@" + updatedAt.Format(time.RFC3339)
+	if j.HTTPConfig.Sync && j.OutputPath != utils.StdoutPath && utils.RemoteUnchanged(j.OutputPath, validator, updatedAt, size) {
+		progress <- highway.Progress{JobID: j.ID(), Done: true, Message: "Unchanged"}
+		return nil
+	}
+	if j.HTTPConfig.Sync && j.OutputPath != utils.StdoutPath {
+		// a part left by an interrupted download belongs to an older upload
+		// and must not be resumed with the new one
+		if _, err := os.Stat(j.OutputPath); err == nil {
+			if err := utils.RemovePartFiles(j.OutputPath); err != nil {
+				return fmt.Errorf("error removing partial files of %s: %v", j.OutputPath, err)
+			}
+		}
+	}
 
 	progress <- highway.Progress{
 		JobID: j.ID(), Type: highway.ProgressTypeProgress,
@@ -130,25 +168,57 @@ func (j *GHReleaseJob) Run(ctx context.Context, progress chan<- highway.Progress
 		}
 	}()
 
-	dlErr := danzohttp.PerformSimpleDownload(ctx, downloadURL, j.OutputPath, client, bytesCh)
+	var dlErr error
+	if j.OutputPath == utils.StdoutPath {
+		dlErr = danzohttp.PerformStreamDownload(ctx, downloadURL, os.Stdout, client, bytesCh)
+	} else {
+		dlErr = danzohttp.PerformSimpleDownload(ctx, downloadURL, j.OutputPath, client, bytesCh)
+	}
 	<-bytesDone
 
 	if dlErr != nil {
 		return dlErr
 	}
+	message := ""
+	if j.OutputPath != utils.StdoutPath {
+		if err := utils.SetFileMetadata(j.OutputPath, updatedAt, downloadURL, j.HTTPConfig.Xattrs); errors.Is(err, utils.ErrXattrsNotWritten) {
+			message = "Done, " + err.Error()
+		} else if err != nil {
+			return fmt.Errorf("error preserving metadata of %s: %v", j.OutputPath, err)
+		}
+		if j.HTTPConfig.Sync {
+			utils.SetSyncValidator(j.OutputPath, validator)
+		}
+	}
 
-	progress <- highway.Progress{JobID: j.ID(), Done: true}
+	progress <- highway.Progress{JobID: j.ID(), Done: true, Message: message}
 	return nil
 }
 
 func (j *GHReleaseJob) Marshal() ([]byte, error) {
 	return json.Marshal(ghReleaseJobState{
-		URL:        j.URL,
-		OutputPath: j.OutputPath,
-		Manual:     j.Manual,
-		ProxyURL:   j.HTTPConfig.ProxyURL,
-		UserAgent:  j.HTTPConfig.UserAgent,
-		Headers:    j.HTTPConfig.Headers,
+		URL:             j.URL,
+		OutputPath:      j.OutputPath,
+		Manual:          j.Manual,
+		ProxyURL:        j.HTTPConfig.ProxyURL,
+		NoProxy:         j.HTTPConfig.NoProxy,
+		UserAgent:       j.HTTPConfig.UserAgent,
+		Headers:         utils.RedactHeaders(j.HTTPConfig.Headers),
+		CookiesFile:     j.HTTPConfig.CookiesFile,
+		NetrcFile:       j.HTTPConfig.NetrcFile,
+		CredentialsFile: j.HTTPConfig.CredentialsFile,
+		CACertFile:      j.HTTPConfig.CACertFile,
+		CertFile:        j.HTTPConfig.CertFile,
+		KeyFile:         j.HTTPConfig.KeyFile,
+		Insecure:        j.HTTPConfig.Insecure,
+		MinTLSVersion:   j.HTTPConfig.MinTLSVersion,
+		Resolve:         j.HTTPConfig.Resolve,
+		IPVersion:       j.HTTPConfig.IPVersion,
+		Interface:       j.HTTPConfig.Interface,
+		LocalAddress:    j.HTTPConfig.LocalAddress,
+		HTTPVersion:     j.HTTPConfig.HTTPVersion,
+		Xattrs:          j.HTTPConfig.Xattrs,
+		Sync:            j.HTTPConfig.Sync,
 	})
 }
 
@@ -157,9 +227,29 @@ func Unmarshal(data []byte) (highway.Job, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
-	return New(state.URL, state.OutputPath, state.Manual, utils.HTTPClientConfig{
-		ProxyURL:  state.ProxyURL,
-		UserAgent: state.UserAgent,
-		Headers:   state.Headers,
-	}), nil
+	job := New(state.URL, state.OutputPath, state.Manual, utils.HTTPClientConfig{
+		ProxyURL:        state.ProxyURL,
+		NoProxy:         state.NoProxy,
+		UserAgent:       state.UserAgent,
+		Headers:         state.Headers,
+		CookiesFile:     state.CookiesFile,
+		NetrcFile:       state.NetrcFile,
+		CredentialsFile: state.CredentialsFile,
+		CACertFile:      state.CACertFile,
+		CertFile:        state.CertFile,
+		KeyFile:         state.KeyFile,
+		Insecure:        state.Insecure,
+		MinTLSVersion:   state.MinTLSVersion,
+		Resolve:         state.Resolve,
+		IPVersion:       state.IPVersion,
+		Interface:       state.Interface,
+		LocalAddress:    state.LocalAddress,
+		HTTPVersion:     state.HTTPVersion,
+		Xattrs:          state.Xattrs,
+		Sync:            state.Sync,
+	})
+	if err := utils.RestoreCertPassword(&job.HTTPConfig); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
@@ -0,0 +1,118 @@
+package danzohttp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/tanq16/danzo/utils"
+)
+
+// acceptEncoding is advertised by --compressed downloads.
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// newDecoder undoes the codings listed in a Content-Encoding header, which
+// were applied in the order listed.
+func newDecoder(contentEncoding string, body io.Reader) (io.ReadCloser, error) {
+	var codings []string
+	for coding := range strings.SplitSeq(contentEncoding, ",") {
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	decoded := io.NopCloser(body)
+	var closers []io.Closer
+	for i := len(codings) - 1; i >= 0; i-- {
+		var next io.ReadCloser
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(decoded)
+			if err != nil {
+				return nil, fmt.Errorf("error reading gzip body: %v", err)
+			}
+			next = gz
+		case "deflate":
+			next = newDeflateReader(decoded)
+		case "br":
+			next = io.NopCloser(brotli.NewReader(decoded))
+		case "zstd":
+			zr, err := zstd.NewReader(decoded)
+			if err != nil {
+				return nil, fmt.Errorf("error reading zstd body: %v", err)
+			}
+			next = zr.IOReadCloser()
+		default:
+			return nil, fmt.Errorf("unsupported Content-Encoding %q", codings[i])
+		}
+		closers = append(closers, next)
+		decoded = next
+	}
+	return &decoderChain{Reader: decoded, closers: closers}, nil
+}
+
+// newDeflateReader accepts both zlib-wrapped data (what "deflate" means per
+// RFC 9110) and the raw deflate streams some servers send instead.
+func newDeflateReader(r io.Reader) io.ReadCloser {
+	br := bufio.NewReader(r)
+	if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		if zr, err := zlib.NewReader(br); err == nil {
+			return zr
+		}
+	}
+	return flate.NewReader(br)
+}
+
+type decoderChain struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decoderChain) Close() error {
+	for _, c := range d.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// wireCounter reports bytes read off the network, before decoding.
+type wireCounter struct {
+	r          io.Reader
+	progressCh chan<- int64
+	reported   *int64
+}
+
+func (c *wireCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		*c.reported += int64(n)
+		c.progressCh <- int64(n)
+	}
+	return n, err
+}
+
+// encodedSize asks for the size of the body as it will be sent with
+// --compressed, which is what wire-byte progress is measured against.
+func encodedSize(ctx context.Context, url string, client *utils.DanzoHTTPClient) int64 {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return 0
+	}
+	return resp.ContentLength
+}
@@ -0,0 +1,274 @@
+package danzohttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/tanq16/danzo/utils"
+	"golang.org/x/net/html"
+)
+
+// CrawlOptions limit a recursive crawl of directory-index pages.
+type CrawlOptions struct {
+	MaxDepth int      // subdirectory levels to descend, negative for unlimited
+	Include  []string // globs a file must match (relative path or name)
+	Exclude  []string // globs that skip a file or a whole directory
+	MinSize  int64
+	MaxSize  int64 // 0 for no limit
+	// OnSkip is told about subdirectories whose index could not be read; the
+	// crawl goes on without them
+	OnSkip func(dirURL string, err error)
+}
+
+// CrawlEntry is a file found by Crawl. Path is relative to the starting
+// directory and uses forward slashes.
+type CrawlEntry struct {
+	URL  string
+	Path string
+	Size int64 // -1 when unknown
+}
+
+// indexEntry is one link of a directory listing.
+type indexEntry struct {
+	url   *url.URL
+	isDir bool
+	size  int64
+}
+
+// Crawl walks Apache/nginx/Caddy style autoindex pages (HTML, or JSON as
+// served by nginx's autoindex_format json and Caddy's browse) below rootURL
+// and returns the files that pass the filters. Links leaving the starting
+// directory, such as parent links and column-sort queries, are ignored. When
+// the starting page redirects, links are matched against where it ended up.
+func Crawl(ctx context.Context, rootURL string, opts CrawlOptions, client *utils.DanzoHTTPClient) ([]CrawlEntry, error) {
+	root, err := url.Parse(rootURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+	root.RawQuery, root.Fragment = "", ""
+	entries, base, err := fetchIndex(ctx, root, client)
+	if err != nil {
+		return nil, err
+	}
+	root = dirURL(base)
+
+	type dir struct {
+		url   *url.URL
+		depth int
+	}
+	current := dir{root, 0}
+	visited := map[string]bool{root.String(): true}
+	var queue []dir
+	var files []CrawlEntry
+	for {
+		for _, entry := range entries {
+			rel, ok := relativePath(root, entry.url)
+			if !ok || visited[entry.url.String()] {
+				continue
+			}
+			visited[entry.url.String()] = true
+			if matchesAny(opts.Exclude, rel) {
+				continue
+			}
+			if entry.isDir {
+				if opts.MaxDepth < 0 || current.depth < opts.MaxDepth {
+					queue = append(queue, dir{entry.url, current.depth + 1})
+				}
+				continue
+			}
+			if len(opts.Include) > 0 && !matchesAny(opts.Include, rel) {
+				continue
+			}
+			if entry.size < 0 && (opts.MinSize > 0 || opts.MaxSize > 0) {
+				entry.size = contentLength(ctx, entry.url.String(), client)
+			}
+			if entry.size >= 0 && (entry.size < opts.MinSize || (opts.MaxSize > 0 && entry.size > opts.MaxSize)) {
+				continue
+			}
+			files = append(files, CrawlEntry{URL: entry.url.String(), Path: rel, Size: entry.size})
+		}
+		if len(queue) == 0 {
+			return files, nil
+		}
+		current, queue = queue[0], queue[1:]
+		entries, _, err = fetchIndex(ctx, current.url, client)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if opts.OnSkip != nil {
+				opts.OnSkip(current.url.String(), err)
+			}
+			entries = nil
+		}
+	}
+}
+
+// dirURL is the directory relative links on page u resolve against, without
+// a query.
+func dirURL(u *url.URL) *url.URL {
+	d := *u
+	d.Path = d.Path[:strings.LastIndex(d.Path, "/")+1]
+	if d.Path == "" {
+		d.Path = "/"
+	}
+	d.RawPath, d.RawQuery, d.Fragment = "", "", ""
+	return &d
+}
+
+// fetchIndex reads a listing and returns its entries with the URL it was
+// served from after redirects.
+func fetchIndex(ctx context.Context, dirURL *url.URL, client *utils.DanzoHTTPClient) ([]indexEntry, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", dirURL.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Caddy answers with JSON when asked; other servers ignore the preference.
+	req.Header.Set("Accept", "application/json, text/html;q=0.9, */*;q=0.8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	// a redirect (e.g. to add the trailing slash) moves the base for links
+	base := dirURL
+	if resp.Request != nil && resp.Request.URL != nil {
+		base = resp.Request.URL
+	}
+	trimmed := bytes.TrimSpace(body)
+	var entries []indexEntry
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") || bytes.HasPrefix(trimmed, []byte("[")) {
+		entries, err = parseJSONIndex(base, trimmed)
+	} else {
+		entries, err = parseHTMLIndex(base, body)
+	}
+	return entries, base, err
+}
+
+// parseJSONIndex reads nginx ({name, type, size}) and Caddy ({name, url,
+// size, is_dir}) listings.
+func parseJSONIndex(base *url.URL, data []byte) ([]indexEntry, error) {
+	var items []struct {
+		Name  string `json:"name"`
+		URL   string `json:"url"`
+		Type  string `json:"type"`
+		IsDir bool   `json:"is_dir"`
+		Size  *int64 `json:"size"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("error parsing JSON index: %v", err)
+	}
+	var entries []indexEntry
+	for _, item := range items {
+		isDir := item.IsDir || item.Type == "directory"
+		ref := item.URL
+		if ref == "" {
+			ref = (&url.URL{Path: item.Name}).EscapedPath()
+			if isDir && !strings.HasSuffix(ref, "/") {
+				ref += "/"
+			}
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			continue
+		}
+		size := int64(-1)
+		if item.Size != nil && !isDir {
+			size = *item.Size
+		}
+		entries = append(entries, indexEntry{url: u, isDir: isDir || strings.HasSuffix(u.Path, "/"), size: size})
+	}
+	return entries, nil
+}
+
+// parseHTMLIndex collects the <a href> links of a listing; directories are the
+// links ending in "/". Sizes are not parsed from the page.
+func parseHTMLIndex(base *url.URL, data []byte) ([]indexEntry, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing HTML index: %v", err)
+	}
+	var entries []indexEntry
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.Data != "a" {
+			continue
+		}
+		for _, attr := range n.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+			u, err := base.Parse(strings.TrimSpace(attr.Val))
+			if err != nil || u.RawQuery != "" {
+				continue
+			}
+			u.Fragment = ""
+			entries = append(entries, indexEntry{url: u, isDir: strings.HasSuffix(u.Path, "/"), size: -1})
+		}
+	}
+	return entries, nil
+}
+
+// relativePath returns the decoded path of u below root, rejecting links to
+// other hosts, outside the root, or to the listing itself.
+func relativePath(root, u *url.URL) (string, bool) {
+	if u.Scheme != root.Scheme || u.Host != root.Host || u.RawQuery != "" {
+		return "", false
+	}
+	if !strings.HasPrefix(u.Path, root.Path) || u.Path == root.Path {
+		return "", false
+	}
+	rel := strings.TrimSuffix(strings.TrimPrefix(u.Path, root.Path), "/")
+	for part := range strings.SplitSeq(rel, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+	}
+	return rel, true
+}
+
+// matchesAny reports whether a glob matches the relative path or its last
+// element, so "*.deb" matches at any depth and "main/*.deb" only in main/.
+func matchesAny(globs []string, rel string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contentLength(ctx context.Context, link string, client *utils.DanzoHTTPClient) int64 {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", link, nil)
+	if err != nil {
+		return -1
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return resp.ContentLength
+}
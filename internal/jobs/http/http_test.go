@@ -1,18 +1,29 @@
 package danzohttp
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/tanq16/danzo/internal/highway"
 	"github.com/tanq16/danzo/utils"
 )
@@ -451,7 +462,7 @@ func TestHTTPJobHEADFailureGETFallback(t *testing.T) {
 
 	dir := t.TempDir()
 	outputPath := filepath.Join(dir, "output.txt")
-	job := New(server.URL, outputPath, 4, utils.HTTPClientConfig{})
+	job := New(server.URL, outputPath, 4, false, HTTPRequest{}, utils.HTTPClientConfig{})
 	progressCh := make(chan highway.Progress, 100)
 
 	go func() {
@@ -484,3 +495,547 @@ func TestHTTPJobHEADFailureGETFallback(t *testing.T) {
 	}
 }
 
+func TestPerformStreamDownloadSkipsWrittenPrefixWhenRangeIsIgnored(t *testing.T) {
+	if testing.Short() {
+		t.Skip("relies on retry backoff sleeps")
+	}
+
+	const body = "hello world"
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				http.Error(w, "hijack unavailable", http.StatusInternalServerError)
+				return
+			}
+			conn, buf, err := hj.Hijack()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", len(body))
+			_, _ = buf.WriteString(body[:4])
+			_ = buf.Flush()
+			_ = conn.Close()
+			return
+		}
+		// Range is deliberately ignored so the client has to discard the prefix.
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	progressCh := make(chan int64, 64)
+	if err := PerformStreamDownload(context.Background(), server.URL, &out, utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}), progressCh); err != nil {
+		t.Fatalf("stream download: %v", err)
+	}
+	var total int64
+	for n := range progressCh {
+		total += n
+	}
+
+	if out.String() != body {
+		t.Fatalf("expected streamed body %q, got %q", body, out.String())
+	}
+	if total != int64(len(body)) {
+		t.Fatalf("expected progress total %d, got %d", len(body), total)
+	}
+}
+
+func TestPerformMultiStreamDownloadWritesBlocksInOrder(t *testing.T) {
+	body := make([]byte, 3*streamBlockSize+123)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			http.Error(w, "missing range", http.StatusBadRequest)
+			return
+		}
+		// Earlier blocks answer slower so completion order differs from file order.
+		if start == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(body[start : end+1])
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	progressCh := make(chan int64, 1024)
+	config := HTTPDownloadConfig{URL: server.URL, Connections: 4}
+	if err := PerformMultiStreamDownload(context.Background(), config, utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}), int64(len(body)), &out, progressCh); err != nil {
+		t.Fatalf("multi stream download: %v", err)
+	}
+	var total int64
+	for n := range progressCh {
+		total += n
+	}
+
+	if !bytes.Equal(out.Bytes(), body) {
+		t.Fatalf("streamed output does not match source (got %d bytes)", out.Len())
+	}
+	if total != int64(len(body)) {
+		t.Fatalf("expected progress total %d, got %d", len(body), total)
+	}
+}
+
+func TestPerformMultiDownloadStripesChunksAcrossBindAddresses(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	var mu sync.Mutex
+	sources := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := strings.Cut(r.RemoteAddr, ":")
+		mu.Lock()
+		sources[host]++
+		mu.Unlock()
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			http.Error(w, "missing range", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(body[start : end+1])
+	}))
+	defer server.Close()
+
+	cfg := utils.HTTPClientConfig{Bind: []string{"127.0.0.1", "127.0.0.2"}}
+	stripes := newBindStripes(cfg)
+	outputPath := filepath.Join(t.TempDir(), "striped.bin")
+	config := HTTPDownloadConfig{URL: server.URL, OutputPath: outputPath, Connections: 4, Stripes: stripes}
+	progressCh := make(chan int64, 1024)
+	if err := PerformMultiDownload(context.Background(), config, utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}), int64(len(body)), progressCh); err != nil {
+		t.Fatalf("multi download: %v", err)
+	}
+
+	got, err := os.ReadFile(outputPath)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("assembled file does not match source (err %v)", err)
+	}
+	if sources["127.0.0.1"] != 2 || sources["127.0.0.2"] != 2 {
+		t.Fatalf("expected two chunks per source address, got %v", sources)
+	}
+	for _, s := range stripes {
+		if s.Downloaded.Load() != int64(len(body)/2) {
+			t.Errorf("stripe %s: expected %d bytes, got %d", s.Name, len(body)/2, s.Downloaded.Load())
+		}
+	}
+	if extra := stripeSpeeds(stripes, 1); !strings.Contains(extra, "127.0.0.1 ") || !strings.Contains(extra, "127.0.0.2 ") {
+		t.Errorf("unexpected per-address progress text %q", extra)
+	}
+}
+
+func TestPerformRequestDownloadDecodesEachEncodingAndCountsWireBytes(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"name":"danzo","ok":true}`+"\n"), 2000)
+	encode := func(enc string) []byte {
+		var buf bytes.Buffer
+		switch enc {
+		case "gzip":
+			w := gzip.NewWriter(&buf)
+			w.Write(body)
+			w.Close()
+		case "deflate":
+			w := zlib.NewWriter(&buf)
+			w.Write(body)
+			w.Close()
+		case "raw-deflate":
+			w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+			w.Write(body)
+			w.Close()
+		case "br":
+			w := brotli.NewWriter(&buf)
+			w.Write(body)
+			w.Close()
+		case "zstd":
+			w, _ := zstd.NewWriter(&buf)
+			w.Write(body)
+			w.Close()
+		default:
+			buf.Write(body)
+		}
+		return buf.Bytes()
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != acceptEncoding {
+			t.Errorf("unexpected Accept-Encoding %q", r.Header.Get("Accept-Encoding"))
+		}
+		enc := r.URL.Query().Get("enc")
+		if enc != "identity" {
+			w.Header().Set("Content-Encoding", strings.TrimPrefix(enc, "raw-"))
+		}
+		_, _ = w.Write(encode(enc))
+	}))
+	defer server.Close()
+
+	for _, enc := range []string{"gzip", "deflate", "raw-deflate", "br", "zstd", "identity"} {
+		outputPath := filepath.Join(t.TempDir(), "data.json")
+		progressCh := make(chan int64, 1024)
+		client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+		if err := PerformRequestDownload(context.Background(), server.URL+"/?enc="+enc, outputPath, HTTPRequest{}, true, client, progressCh); err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		var wire int64
+		for n := range progressCh {
+			wire += n
+		}
+		got, err := os.ReadFile(outputPath)
+		if err != nil || !bytes.Equal(got, body) {
+			t.Fatalf("%s: decoded output does not match (err %v, %d bytes)", enc, err, len(got))
+		}
+		if want := int64(len(encode(enc))); wire != want {
+			t.Errorf("%s: expected progress of %d wire bytes, got %d", enc, want, wire)
+		}
+	}
+
+	if _, err := newDecoder("compress", bytes.NewReader(nil)); err == nil {
+		t.Error("expected unsupported Content-Encoding to be rejected")
+	}
+}
+
+func TestCrawlWalksHTMLAndJSONIndexesWithFilters(t *testing.T) {
+	files := map[string]int{
+		"/pool/a.deb":           100,
+		"/pool/readme.txt":      10,
+		"/pool/main/b.deb":      2000,
+		"/pool/main/deep/c.deb": 300,
+		"/pool/skip/d.deb":      100,
+		"/pool/json/e.deb":      400,
+		"/pool/json/f%20g.deb":  50,
+		"/outside/nope.deb":     1,
+	}
+	html := map[string]string{
+		"/pool/": `<html><body><h1>Index of /pool</h1>
+<a href="?C=N;O=D">Name</a> <a href="/">Parent Directory</a>
+<a href="a.deb">a.deb</a> <a href="readme.txt">readme.txt</a>
+<a href="main/">main/</a> <a href="skip/">skip/</a> <a href="json/">json/</a> <a href="broken/">broken/</a>
+<a href="/outside/nope.deb">elsewhere</a> <a href="https://mirror.example/x.deb">mirror</a></body></html>`,
+		"/pool/main/":      `<pre><a href="../">../</a><a href="b.deb">b.deb</a><a href="deep/">deep/</a></pre>`,
+		"/pool/main/deep/": `<a href="c.deb">c.deb</a>`,
+		"/pool/skip/":      `<a href="d.deb">d.deb</a>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if page, ok := html[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, page)
+			return
+		}
+		if r.URL.Path == "/old/" {
+			http.Redirect(w, r, "/pool/", http.StatusMovedPermanently)
+			return
+		}
+		if r.URL.Path == "/pool/broken/" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/pool/json/" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"name":"e.deb","type":"file","size":400},{"name":"f g.deb","type":"file","size":50}]`)
+			return
+		}
+		if size, ok := files[r.URL.EscapedPath()]; ok {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+
+	crawlFrom := func(start string, opts CrawlOptions) map[string]int64 {
+		t.Helper()
+		entries, err := Crawl(context.Background(), server.URL+start, opts, client)
+		if err != nil {
+			t.Fatalf("crawl %+v: %v", opts, err)
+		}
+		got := map[string]int64{}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.URL, server.URL+"/pool/") {
+				t.Errorf("entry %s escapes the starting directory", e.URL)
+			}
+			got[e.Path] = e.Size
+		}
+		return got
+	}
+	crawl := func(opts CrawlOptions) map[string]int64 {
+		t.Helper()
+		return crawlFrom("/pool", opts)
+	}
+	paths := func(m map[string]int64) string {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+
+	all := crawl(CrawlOptions{MaxDepth: -1})
+	if got := paths(all); got != "a.deb,json/e.deb,json/f g.deb,main/b.deb,main/deep/c.deb,readme.txt,skip/d.deb" {
+		t.Fatalf("unexpected files: %s", got)
+	}
+	if all["json/e.deb"] != 400 || all["a.deb"] != -1 {
+		t.Errorf("expected JSON sizes only, got %v", all)
+	}
+	if got := paths(crawl(CrawlOptions{MaxDepth: 1, Include: []string{"*.deb"}, Exclude: []string{"skip"}})); got != "a.deb,json/e.deb,json/f g.deb,main/b.deb" {
+		t.Errorf("depth/include/exclude: got %s", got)
+	}
+	if got := paths(crawl(CrawlOptions{MaxDepth: -1, MinSize: 100, MaxSize: 1000})); got != "a.deb,json/e.deb,main/deep/c.deb,skip/d.deb" {
+		t.Errorf("size filters: got %s", got)
+	}
+
+	// the unreadable subdirectory is reported and the rest still crawled,
+	// also when the starting URL redirects to another path
+	var skipped []string
+	moved := crawlFrom("/old", CrawlOptions{MaxDepth: -1, OnSkip: func(dirURL string, err error) { skipped = append(skipped, dirURL) }})
+	if got := paths(moved); got != paths(all) {
+		t.Errorf("redirected crawl: got %s", got)
+	}
+	if want := []string{server.URL + "/pool/broken/"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("expected %q to be skipped, got %q", want, skipped)
+	}
+}
+
+func TestHTTPJobStreamsToStdoutWithoutCreatingFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte("hello"))
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	oldStdout := stdout
+	stdout = &out
+	defer func() { stdout = oldStdout }()
+
+	dir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(oldWd)
+
+	job := New(server.URL+"/file.txt", utils.StdoutPath, 4, false, HTTPRequest{}, utils.HTTPClientConfig{})
+	if job.ID() != server.URL+"/file.txt" {
+		t.Fatalf("expected stdout job to be identified by URL, got %q", job.ID())
+	}
+	progressCh := make(chan highway.Progress, 100)
+	go func() {
+		for range progressCh {
+		}
+	}()
+	err := job.Run(context.Background(), progressCh)
+	close(progressCh)
+	if err != nil {
+		t.Fatalf("stream job: %v", err)
+	}
+
+	if out.String() != "hello" {
+		t.Fatalf("expected stdout to receive %q, got %q", "hello", out.String())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no files on disk when streaming, found %d entries", len(entries))
+	}
+}
+
+func TestHTTPJobSendsCustomMethodOnceWithoutRanges(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	fail := atomic.Bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		seen = append(seen, fmt.Sprintf("%s %s %s range=%q", r.Method, r.Header.Get("Content-Type"), body, r.Header.Get("Range")))
+		mu.Unlock()
+		if fail.Load() {
+			http.Error(w, "export failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		_, _ = w.Write([]byte("id,name\n1,danzo\n"))
+	}))
+	defer server.Close()
+
+	run := func(job *HTTPJob) error {
+		progressCh := make(chan highway.Progress, 100)
+		go func() {
+			for range progressCh {
+			}
+		}()
+		defer close(progressCh)
+		return job.Run(context.Background(), progressCh)
+	}
+	outputPath := filepath.Join(t.TempDir(), "export.csv")
+	request := HTTPRequest{Data: `{"format":"csv"}`}
+	if err := run(New(server.URL+"/export", outputPath, 8, false, request, utils.HTTPClientConfig{})); err != nil {
+		t.Fatalf("POST download: %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); string(got) != "id,name\n1,danzo\n" {
+		t.Fatalf("unexpected output %q", got)
+	}
+	if want := `POST application/json {"format":"csv"} range=""`; len(seen) != 1 || seen[0] != want {
+		t.Fatalf("expected a single %q, got %q", want, seen)
+	}
+
+	seen = nil
+	fail.Store(true)
+	if err := run(New(server.URL+"/export", filepath.Join(t.TempDir(), "x.csv"), 8, false, HTTPRequest{Method: "post", Data: "a=1"}, utils.HTTPClientConfig{})); err == nil {
+		t.Fatal("expected the failed POST to be reported")
+	}
+	if len(seen) != 1 || !strings.HasPrefix(seen[0], "POST application/x-www-form-urlencoded a=1") {
+		t.Fatalf("expected a failed POST not to be retried, got %q", seen)
+	}
+
+	data, err := New(server.URL, "x", 1, false, HTTPRequest{Method: "PUT", DataFile: "body.json", ContentType: "text/plain"}, utils.HTTPClientConfig{}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.(*HTTPJob).Request; got != (HTTPRequest{Method: "PUT", DataFile: "body.json", ContentType: "text/plain"}) {
+		t.Errorf("request not restored from state: %+v", got)
+	}
+
+	// an inline body is asked for again instead of being saved
+	data, err = New(server.URL, "x", 1, false, HTTPRequest{Data: "token=s3cr3t"}, utils.HTTPClientConfig{}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("s3cr3t")) {
+		t.Fatalf("resume state leaked the request body: %s", data)
+	}
+	defer func(prompt func(string) (string, error)) { promptRequestBody = prompt }(promptRequestBody)
+	promptRequestBody = func(url string) (string, error) { return "token=again", nil }
+	restored, err = Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.(*HTTPJob).Request; got != (HTTPRequest{Data: "token=again"}) {
+		t.Errorf("expected the prompted body on resume, got %+v", got)
+	}
+	promptRequestBody = func(url string) (string, error) { return "", nil }
+	if _, err := Unmarshal(data); err == nil {
+		t.Error("expected resuming without a body to fail")
+	}
+}
+
+func TestHTTPJobSetsModTimeFromLastModified(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	content := bytes.Repeat([]byte("danzo"), 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "release.tar", modTime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "release.tar")
+	progressCh := make(chan highway.Progress, 100)
+	go func() {
+		for range progressCh {
+		}
+	}()
+	defer close(progressCh)
+	if err := New(server.URL+"/release.tar", outputPath, 1, false, HTTPRequest{}, utils.HTTPClientConfig{}).Run(context.Background(), progressCh); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("expected mtime %v, got %v", modTime, info.ModTime())
+	}
+}
+
+func TestHTTPJobSyncSkipsUnchangedAndReplacesChangedFiles(t *testing.T) {
+	var mu sync.Mutex
+	content, modTime, etag := []byte("nightly-1"), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), `"v1"`
+	var gets int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		body, mt, tag := content, modTime, etag
+		if r.Method == "GET" && r.Header.Get("Range") == "" {
+			gets++
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, r, "nightly.bin", mt, bytes.NewReader(body))
+	}))
+	defer server.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "nightly.bin")
+	run := func() string {
+		progressCh := make(chan highway.Progress, 100)
+		messages := make(chan string, 1)
+		go func() {
+			last := ""
+			for p := range progressCh {
+				if p.Done {
+					last = p.Message
+				}
+			}
+			messages <- last
+		}()
+		err := New(server.URL+"/nightly.bin", outputPath, 1, false, HTTPRequest{}, utils.HTTPClientConfig{Sync: true}).Run(context.Background(), progressCh)
+		close(progressCh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return <-messages
+	}
+
+	run()
+	if msg := run(); msg != "Unchanged" {
+		t.Fatalf("expected the second sync to report Unchanged, got %q", msg)
+	}
+	mu.Lock()
+	if gets != 1 {
+		t.Fatalf("expected a single full download, got %d", gets)
+	}
+
+	content, modTime, etag = []byte("nightly-2-longer"), modTime.Add(24*time.Hour), `"v2"`
+	mu.Unlock()
+	if msg := run(); msg == "Unchanged" {
+		t.Fatal("expected the changed file to be downloaded again")
+	}
+	if got, _ := os.ReadFile(outputPath); string(got) != "nightly-2-longer" {
+		t.Fatalf("expected the file to be replaced in place, got %q", got)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(outputPath), "nightly*")); len(matches) != 1 {
+		t.Fatalf("expected no renamed copies, got %v", matches)
+	}
+}
+
+func TestHTTPJobMarshalKeepsCredentialSourcesButNotSecrets(t *testing.T) {
+	job := New("https://example.com/file.bin", "file.bin", 4, false, HTTPRequest{}, utils.HTTPClientConfig{
+		Headers:         map[string]string{"Authorization": "Bearer secret", "X-Trace-ID": "abc"},
+		NetrcFile:       "/home/user/.netrc",
+		CredentialsFile: "/home/user/.config/danzo/credentials.enc",
+	})
+	data, err := job.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatalf("resume state leaked a secret: %s", data)
+	}
+	restored, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := restored.(*HTTPJob).HTTPConfig
+	if cfg.Headers["X-Trace-ID"] != "abc" || cfg.NetrcFile != "/home/user/.netrc" || cfg.CredentialsFile == "" {
+		t.Fatalf("expected non-secret config to round-trip, got %#v", cfg)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"net/http"
 	"net/url"
@@ -12,17 +13,36 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/tanq16/danzo/internal/highway"
 	"github.com/tanq16/danzo/utils"
 )
 
+// stdout is where downloads with utils.StdoutPath as output path are written.
+var stdout io.Writer = os.Stdout
+
+// promptRequestBody asks again for an inline --data body when a job is
+// resumed, since it may hold secrets and is never written to the state file.
+var promptRequestBody = func(url string) (string, error) {
+	return utils.PromptPassword("Request body (--data) for " + url + ":")
+}
+
 type HTTPDownloadConfig struct {
 	URL              string
 	OutputPath       string
 	Connections      int
 	HTTPClientConfig utils.HTTPClientConfig
+	Stripes          []*BindStripe
+}
+
+// BindStripe is one --bind source address. Chunks assigned to it use a client
+// dialing from that address, and Downloaded counts the bytes received over it.
+type BindStripe struct {
+	Name       string
+	Client     *utils.DanzoHTTPClient
+	Downloaded atomic.Int64
 }
 
 type HTTPDownloadChunk struct {
@@ -35,6 +55,7 @@ type HTTPDownloadChunk struct {
 	LastError  error
 	StartTime  time.Time
 	FinishTime time.Time
+	Stripe     *BindStripe
 }
 
 type HTTPDownloadJob struct {
@@ -50,21 +71,48 @@ type HTTPJob struct {
 	URL         string
 	OutputPath  string
 	Connections int
+	Compressed  bool
+	Request     HTTPRequest
 	HTTPConfig  utils.HTTPClientConfig
+
+	lastModified time.Time
+	etag         string
 }
 
 type httpJobState struct {
-	URL         string            `json:"url"`
-	OutputPath  string            `json:"outputPath"`
-	Connections int               `json:"connections"`
-	ProxyURL    string            `json:"proxyURL,omitempty"`
-	UserAgent   string            `json:"userAgent,omitempty"`
-	Headers     map[string]string `json:"headers,omitempty"`
+	URL             string            `json:"url"`
+	OutputPath      string            `json:"outputPath"`
+	Connections     int               `json:"connections"`
+	Compressed      bool              `json:"compressed,omitempty"`
+	Method          string            `json:"method,omitempty"`
+	DataOmitted     bool              `json:"dataOmitted,omitempty"` // inline --data body, asked for on resume
+	DataFile        string            `json:"dataFile,omitempty"`
+	ContentType     string            `json:"contentType,omitempty"`
+	ProxyURL        string            `json:"proxyURL,omitempty"`
+	NoProxy         string            `json:"noProxy,omitempty"`
+	UserAgent       string            `json:"userAgent,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	CookiesFile     string            `json:"cookiesFile,omitempty"`
+	NetrcFile       string            `json:"netrcFile,omitempty"`
+	CredentialsFile string            `json:"credentialsFile,omitempty"`
+	CACertFile      string            `json:"caCertFile,omitempty"`
+	CertFile        string            `json:"certFile,omitempty"`
+	KeyFile         string            `json:"keyFile,omitempty"`
+	Insecure        bool              `json:"insecure,omitempty"`
+	MinTLSVersion   string            `json:"minTLSVersion,omitempty"`
+	Resolve         []string          `json:"resolve,omitempty"`
+	IPVersion       int               `json:"ipVersion,omitempty"`
+	Interface       string            `json:"interface,omitempty"`
+	LocalAddress    string            `json:"localAddress,omitempty"`
+	HTTPVersion     string            `json:"httpVersion,omitempty"`
+	Bind            []string          `json:"bind,omitempty"`
+	Xattrs          bool              `json:"xattrs,omitempty"`
+	Sync            bool              `json:"sync,omitempty"`
 }
 
-func New(url, outputPath string, connections int, httpConfig utils.HTTPClientConfig) *HTTPJob {
+func New(url, outputPath string, connections int, compressed bool, request HTTPRequest, httpConfig utils.HTTPClientConfig) *HTTPJob {
 	id := outputPath
-	if id == "" {
+	if id == "" || id == utils.StdoutPath {
 		id = url
 	}
 	return &HTTPJob{
@@ -72,6 +120,8 @@ func New(url, outputPath string, connections int, httpConfig utils.HTTPClientCon
 		URL:         url,
 		OutputPath:  outputPath,
 		Connections: connections,
+		Compressed:  compressed,
+		Request:     request,
 		HTTPConfig:  httpConfig,
 	}
 }
@@ -91,55 +141,21 @@ func (j *HTTPJob) Run(ctx context.Context, progress chan<- highway.Progress) err
 		return fmt.Errorf("unsupported scheme: %s", parsedURL.Scheme)
 	}
 
-	client := utils.NewDanzoHTTPClient(j.HTTPConfig)
-	req, err := http.NewRequestWithContext(ctx, "HEAD", j.URL, nil)
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error checking URL: %v", err)
-	}
-	resp.Body.Close()
-
-	headBlocked := false
-	if resp.StatusCode == http.StatusMovedPermanently || resp.StatusCode == http.StatusFound {
-		if location := resp.Header.Get("Location"); location != "" {
-			j.URL = location
-		}
-	} else if resp.StatusCode == http.StatusNotFound {
-		return fmt.Errorf("URL not found (404)")
-	} else if resp.StatusCode >= 400 {
-		// Fallback: Some CDNs/hosts block HEAD requests (returning 403 or 405).
-		// Attempt a lightweight GET request with Range: bytes=0-0 to verify accessibility.
-		getReq, getErr := http.NewRequestWithContext(ctx, "GET", j.URL, nil)
-		if getErr != nil {
-			return fmt.Errorf("server returned error: %d", resp.StatusCode)
-		}
-		getReq.Header.Set("Range", "bytes=0-0")
-		getResp, getRespErr := client.Do(getReq)
-		if getRespErr != nil {
-			return fmt.Errorf("server returned error: %d", resp.StatusCode)
-		}
-		getResp.Body.Close()
-
-		if getResp.StatusCode >= 400 {
-			return fmt.Errorf("server returned error: %d (GET fallback returned: %d)", resp.StatusCode, getResp.StatusCode)
+	var client *utils.DanzoHTTPClient
+	var fileSize int64
+	var fileName string
+	rangeSupported := false
+	if j.Request.isGET() {
+		if client, fileSize, fileName, rangeSupported, err = j.probe(ctx); err != nil {
+			return err
 		}
-		if getResp.Request != nil && getResp.Request.URL != nil {
-			j.URL = getResp.Request.URL.String()
-		}
-		headBlocked = true
-	}
-
-	j.HTTPConfig.HighThreadMode = j.Connections > 5
-	client = utils.NewDanzoHTTPClient(j.HTTPConfig)
-	fileSize, fileName, err := getFileInfo(ctx, j.URL, client, headBlocked)
-	rangeSupported := err != utils.ErrRangeRequestsNotSupported
-	if err != nil && err != utils.ErrRangeRequestsNotSupported {
-		return fmt.Errorf("error getting file info: %v", err)
+	} else {
+		// HEAD says nothing about what another method returns, and ranges are
+		// only defined for GET, so custom requests are sent as a whole
+		client = utils.NewDanzoHTTPClient(j.HTTPConfig)
 	}
 
+	toStdout := j.OutputPath == utils.StdoutPath
 	if j.OutputPath == "" && fileName != "" {
 		j.OutputPath = fileName
 	} else if j.OutputPath == "" {
@@ -151,12 +167,30 @@ func (j *HTTPJob) Run(ctx context.Context, progress chan<- highway.Progress) err
 		}
 	}
 
-	if existingFile, statErr := os.Stat(j.OutputPath); statErr == nil {
-		if fileSize > 0 && existingFile.Size() == fileSize {
-			progress <- highway.Progress{JobID: j.ID(), Done: true, Message: "Already exists"}
-			return nil
+	if !toStdout {
+		if existingFile, statErr := os.Stat(j.OutputPath); statErr == nil {
+			switch {
+			case j.HTTPConfig.Sync && j.Request.isGET():
+				unchanged, err := j.notModified(ctx, client, existingFile, fileSize)
+				if err != nil {
+					return err
+				}
+				if unchanged {
+					progress <- highway.Progress{JobID: j.ID(), Done: true, Message: "Unchanged"}
+					return nil
+				}
+				// parts left by an interrupted download belong to an older
+				// version; the new one is renamed over the file when complete
+				if err := utils.RemovePartFiles(j.OutputPath); err != nil {
+					return fmt.Errorf("error removing partial files of %s: %v", j.OutputPath, err)
+				}
+			case !j.Compressed && fileSize > 0 && existingFile.Size() == fileSize:
+				progress <- highway.Progress{JobID: j.ID(), Done: true, Message: "Already exists"}
+				return nil
+			default:
+				j.OutputPath = utils.RenewOutputPath(j.OutputPath)
+			}
 		}
-		j.OutputPath = utils.RenewOutputPath(j.OutputPath)
 	}
 
 	progress <- highway.Progress{
@@ -164,6 +198,12 @@ func (j *HTTPJob) Run(ctx context.Context, progress chan<- highway.Progress) err
 		Message: "Downloading", Current: 0, Total: fileSize,
 	}
 
+	simple := j.Compressed || !j.Request.isGET() || !rangeSupported || j.Connections == 1 || fileSize/int64(j.Connections) < 2*utils.DefaultBufferSize
+	var stripes []*BindStripe
+	if !simple && !toStdout {
+		stripes = newBindStripes(j.HTTPConfig)
+	}
+
 	bytesCh := make(chan int64, 100)
 	bytesDone := make(chan struct{})
 	startTime := time.Now()
@@ -192,7 +232,7 @@ func (j *HTTPJob) Run(ctx context.Context, progress chan<- highway.Progress) err
 					progress <- highway.Progress{
 						JobID: j.ID(), Type: highway.ProgressTypeProgress,
 						Message: "Downloading", Current: totalDownloaded, Total: fileSize,
-						Extra: speed,
+						Extra: speed + stripeSpeeds(stripes, elapsed),
 					}
 				}
 			}
@@ -200,17 +240,25 @@ func (j *HTTPJob) Run(ctx context.Context, progress chan<- highway.Progress) err
 	}()
 
 	var dlErr error
-	if !rangeSupported || j.Connections == 1 {
-		dlErr = PerformSimpleDownload(ctx, j.URL, j.OutputPath, client, bytesCh)
-	} else if fileSize/int64(j.Connections) < 2*utils.DefaultBufferSize {
+	config := HTTPDownloadConfig{
+		URL:              j.URL,
+		OutputPath:       j.OutputPath,
+		Connections:      j.Connections,
+		HTTPClientConfig: j.HTTPConfig,
+		Stripes:          stripes,
+	}
+	switch {
+	case toStdout && (j.Compressed || !j.Request.isGET()):
+		dlErr = PerformRequestStream(ctx, j.URL, stdout, j.Request, j.Compressed, client, bytesCh)
+	case j.Compressed || !j.Request.isGET():
+		dlErr = PerformRequestDownload(ctx, j.URL, j.OutputPath, j.Request, j.Compressed, client, bytesCh)
+	case toStdout && simple:
+		dlErr = PerformStreamDownload(ctx, j.URL, stdout, client, bytesCh)
+	case toStdout:
+		dlErr = PerformMultiStreamDownload(ctx, config, client, fileSize, stdout, bytesCh)
+	case simple:
 		dlErr = PerformSimpleDownload(ctx, j.URL, j.OutputPath, client, bytesCh)
-	} else {
-		config := HTTPDownloadConfig{
-			URL:              j.URL,
-			OutputPath:       j.OutputPath,
-			Connections:      j.Connections,
-			HTTPClientConfig: j.HTTPConfig,
-		}
+	default:
 		dlErr = PerformMultiDownload(ctx, config, client, fileSize, bytesCh)
 	}
 
@@ -219,19 +267,187 @@ func (j *HTTPJob) Run(ctx context.Context, progress chan<- highway.Progress) err
 	if dlErr != nil {
 		return dlErr
 	}
+	message := ""
+	if !toStdout {
+		// the response to another method says nothing about a remote file's age
+		modTime := j.lastModified
+		if !j.Request.isGET() {
+			modTime = time.Time{}
+		}
+		if err := utils.SetFileMetadata(j.OutputPath, modTime, j.URL, j.HTTPConfig.Xattrs); errors.Is(err, utils.ErrXattrsNotWritten) {
+			message = "Done, " + err.Error()
+		} else if err != nil {
+			return fmt.Errorf("error preserving metadata of %s: %v", j.OutputPath, err)
+		}
+		if j.HTTPConfig.Sync && j.Request.isGET() {
+			utils.SetSyncValidator(j.OutputPath, j.etag)
+		}
+	}
 
-	progress <- highway.Progress{JobID: j.ID(), Done: true}
+	progress <- highway.Progress{JobID: j.ID(), Done: true, Message: message}
 	return nil
 }
 
+// probe checks the URL with HEAD (falling back to a one-byte ranged GET when
+// HEAD is blocked), follows a redirect, and reads the size, file name, range
+// support and Last-Modified time of the download.
+func (j *HTTPJob) probe(ctx context.Context) (*utils.DanzoHTTPClient, int64, string, bool, error) {
+	client := utils.NewDanzoHTTPClient(j.HTTPConfig)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", j.URL, nil)
+	if err != nil {
+		return nil, 0, "", false, fmt.Errorf("error creating request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, "", false, fmt.Errorf("error checking URL: %v", err)
+	}
+	resp.Body.Close()
+	j.lastModified = utils.ParseLastModified(resp.Header)
+	j.etag = resp.Header.Get("ETag")
+
+	headBlocked := false
+	if resp.StatusCode == http.StatusMovedPermanently || resp.StatusCode == http.StatusFound {
+		if location := resp.Header.Get("Location"); location != "" {
+			j.URL = location
+		}
+	} else if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, "", false, fmt.Errorf("URL not found (404)")
+	} else if resp.StatusCode >= 400 {
+		// Fallback: Some CDNs/hosts block HEAD requests (returning 403 or 405).
+		// Attempt a lightweight GET request with Range: bytes=0-0 to verify accessibility.
+		getReq, getErr := http.NewRequestWithContext(ctx, "GET", j.URL, nil)
+		if getErr != nil {
+			return nil, 0, "", false, fmt.Errorf("server returned error: %d", resp.StatusCode)
+		}
+		getReq.Header.Set("Range", "bytes=0-0")
+		getResp, getRespErr := client.Do(getReq)
+		if getRespErr != nil {
+			return nil, 0, "", false, fmt.Errorf("server returned error: %d", resp.StatusCode)
+		}
+		getResp.Body.Close()
+
+		if getResp.StatusCode >= 400 {
+			return nil, 0, "", false, fmt.Errorf("server returned error: %d (GET fallback returned: %d)", resp.StatusCode, getResp.StatusCode)
+		}
+		if getResp.Request != nil && getResp.Request.URL != nil {
+			j.URL = getResp.Request.URL.String()
+		}
+		j.lastModified = utils.ParseLastModified(getResp.Header)
+		j.etag = getResp.Header.Get("ETag")
+		headBlocked = true
+	}
+
+	j.HTTPConfig.HighThreadMode = j.Connections > 5
+	client = utils.NewDanzoHTTPClient(j.HTTPConfig)
+	fileSize, fileName, err := getFileInfo(ctx, j.URL, client, headBlocked)
+	rangeSupported := err != utils.ErrRangeRequestsNotSupported
+	if err != nil && err != utils.ErrRangeRequestsNotSupported {
+		return nil, 0, "", false, fmt.Errorf("error getting file info: %v", err)
+	}
+	if j.Compressed {
+		// the decoded size is unknown up front; progress follows wire bytes
+		fileSize = encodedSize(ctx, j.URL, client)
+	}
+	return client, fileSize, fileName, rangeSupported, nil
+}
+
+// notModified asks the server whether the local copy of a --sync download is
+// current, sending the ETag recorded by the last sync and the file's mtime
+// (taken from Last-Modified) as conditions. A size that differs from the
+// probed one settles it without asking.
+func (j *HTTPJob) notModified(ctx context.Context, client *utils.DanzoHTTPClient, local os.FileInfo, fileSize int64) (bool, error) {
+	if !j.Compressed && fileSize > 0 && local.Size() != fileSize {
+		return false, nil
+	}
+	conditional := func(method string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, j.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+		if etag := utils.SyncValidator(j.OutputPath); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		req.Header.Set("If-Modified-Since", local.ModTime().UTC().Format(http.TimeFormat))
+		if method == "GET" {
+			req.Header.Set("Range", "bytes=0-0")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error checking for changes: %v", err)
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+	resp, err := conditional("HEAD")
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode >= 400 {
+		// same fallback as probe for hosts that block HEAD
+		if resp, err = conditional("GET"); err != nil {
+			return false, err
+		}
+	}
+	return resp.StatusCode == http.StatusNotModified, nil
+}
+
+// newBindStripes creates one client per --bind entry, each dialing from that
+// interface or address.
+func newBindStripes(cfg utils.HTTPClientConfig) []*BindStripe {
+	var stripes []*BindStripe
+	for _, name := range cfg.Bind {
+		stripeConfig := cfg
+		stripeConfig.Bind = nil
+		stripeConfig.LocalAddress = ""
+		stripeConfig.Interface = name
+		stripes = append(stripes, &BindStripe{Name: name, Client: utils.NewDanzoHTTPClient(stripeConfig)})
+	}
+	return stripes
+}
+
+// stripeSpeeds formats the average throughput of each --bind address, e.g.
+// " [eth0 12.0 MB/s, eth1 9.5 MB/s]".
+func stripeSpeeds(stripes []*BindStripe, elapsed float64) string {
+	if len(stripes) == 0 {
+		return ""
+	}
+	parts := make([]string, len(stripes))
+	for i, s := range stripes {
+		parts[i] = s.Name + " " + utils.FormatSpeed(s.Downloaded.Load(), elapsed)
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
+
 func (j *HTTPJob) Marshal() ([]byte, error) {
 	return json.Marshal(httpJobState{
-		URL:         j.URL,
-		OutputPath:  j.OutputPath,
-		Connections: j.Connections,
-		ProxyURL:    j.HTTPConfig.ProxyURL,
-		UserAgent:   j.HTTPConfig.UserAgent,
-		Headers:     j.HTTPConfig.Headers,
+		URL:             j.URL,
+		OutputPath:      j.OutputPath,
+		Connections:     j.Connections,
+		Compressed:      j.Compressed,
+		Method:          j.Request.Method,
+		DataOmitted:     j.Request.Data != "",
+		DataFile:        j.Request.DataFile,
+		ContentType:     j.Request.ContentType,
+		ProxyURL:        j.HTTPConfig.ProxyURL,
+		NoProxy:         j.HTTPConfig.NoProxy,
+		UserAgent:       j.HTTPConfig.UserAgent,
+		Headers:         utils.RedactHeaders(j.HTTPConfig.Headers),
+		CookiesFile:     j.HTTPConfig.CookiesFile,
+		NetrcFile:       j.HTTPConfig.NetrcFile,
+		CredentialsFile: j.HTTPConfig.CredentialsFile,
+		CACertFile:      j.HTTPConfig.CACertFile,
+		CertFile:        j.HTTPConfig.CertFile,
+		KeyFile:         j.HTTPConfig.KeyFile,
+		Insecure:        j.HTTPConfig.Insecure,
+		MinTLSVersion:   j.HTTPConfig.MinTLSVersion,
+		Resolve:         j.HTTPConfig.Resolve,
+		IPVersion:       j.HTTPConfig.IPVersion,
+		Interface:       j.HTTPConfig.Interface,
+		LocalAddress:    j.HTTPConfig.LocalAddress,
+		HTTPVersion:     j.HTTPConfig.HTTPVersion,
+		Bind:            j.HTTPConfig.Bind,
+		Xattrs:          j.HTTPConfig.Xattrs,
+		Sync:            j.HTTPConfig.Sync,
 	})
 }
 
@@ -240,11 +456,47 @@ func Unmarshal(data []byte) (highway.Job, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
-	return New(state.URL, state.OutputPath, state.Connections, utils.HTTPClientConfig{
-		ProxyURL:  state.ProxyURL,
-		UserAgent: state.UserAgent,
-		Headers:   state.Headers,
-	}), nil
+	request := HTTPRequest{
+		Method:      state.Method,
+		DataFile:    state.DataFile,
+		ContentType: state.ContentType,
+	}
+	if state.DataOmitted {
+		body, err := promptRequestBody(state.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+		if body == "" {
+			return nil, fmt.Errorf("the request body of %s is needed to resume it", state.URL)
+		}
+		request.Data = body
+	}
+	job := New(state.URL, state.OutputPath, state.Connections, state.Compressed, request, utils.HTTPClientConfig{
+		ProxyURL:        state.ProxyURL,
+		NoProxy:         state.NoProxy,
+		UserAgent:       state.UserAgent,
+		Headers:         state.Headers,
+		CookiesFile:     state.CookiesFile,
+		NetrcFile:       state.NetrcFile,
+		CredentialsFile: state.CredentialsFile,
+		CACertFile:      state.CACertFile,
+		CertFile:        state.CertFile,
+		KeyFile:         state.KeyFile,
+		Insecure:        state.Insecure,
+		MinTLSVersion:   state.MinTLSVersion,
+		Resolve:         state.Resolve,
+		IPVersion:       state.IPVersion,
+		Interface:       state.Interface,
+		LocalAddress:    state.LocalAddress,
+		HTTPVersion:     state.HTTPVersion,
+		Bind:            state.Bind,
+		Xattrs:          state.Xattrs,
+		Sync:            state.Sync,
+	})
+	if err := utils.RestoreCertPassword(&job.HTTPConfig); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
 
 func getFileInfo(ctx context.Context, link string, client *utils.DanzoHTTPClient, useGET bool) (int64, string, error) {
@@ -125,6 +125,9 @@ func downloadSingleChunk(ctx context.Context, job *HTTPDownloadJob, chunk *HTTPD
 			}
 			newBytes += int64(bytesRead)
 			chunk.Downloaded += int64(bytesRead)
+			if chunk.Stripe != nil {
+				chunk.Stripe.Downloaded.Add(int64(bytesRead))
+			}
 			progressCh <- int64(bytesRead)
 		}
 		if err != nil {
@@ -43,11 +43,15 @@ func PerformMultiDownload(ctx context.Context, config HTTPDownloadConfig, client
 			endByte = fileSize - 1
 		}
 		if endByte >= startByte {
-			job.Chunks = append(job.Chunks, HTTPDownloadChunk{
+			chunk := HTTPDownloadChunk{
 				ID:        i,
 				StartByte: startByte,
 				EndByte:   endByte,
-			})
+			}
+			if n := len(config.Stripes); n > 0 {
+				chunk.Stripe = config.Stripes[len(job.Chunks)%n]
+			}
+			job.Chunks = append(job.Chunks, chunk)
 		}
 		currentPosition = endByte + 1
 	}
@@ -55,8 +59,12 @@ func PerformMultiDownload(ctx context.Context, config HTTPDownloadConfig, client
 	g, ctx := errgroup.WithContext(ctx)
 	g.SetLimit(config.Connections)
 	for i := range job.Chunks {
+		chunkClient := client
+		if job.Chunks[i].Stripe != nil {
+			chunkClient = job.Chunks[i].Stripe.Client
+		}
 		g.Go(func() error {
-			return chunkedDownload(ctx, &job, &job.Chunks[i], client, progressCh, mutex)
+			return chunkedDownload(ctx, &job, &job.Chunks[i], chunkClient, progressCh, mutex)
 		})
 	}
 
@@ -103,10 +111,18 @@ func assembleFile(job HTTPDownloadJob) error {
 		}
 		return idI < idJ
 	})
-	destFile, err := os.Create(job.Config.OutputPath)
+	// assemble next to the chunks and rename, so an existing file (e.g. one
+	// being re-synced) is replaced in one step
+	tempDir := filepath.Join(filepath.Dir(job.Config.OutputPath), ".danzo-temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return err
+	}
+	assembledPath := filepath.Join(tempDir, filepath.Base(job.Config.OutputPath)+".part.assembled")
+	destFile, err := os.Create(assembledPath)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(assembledPath)
 	defer destFile.Close()
 
 	var totalWritten int64 = 0
@@ -134,6 +150,12 @@ func assembleFile(job HTTPDownloadJob) error {
 	if totalWritten != job.FileSize {
 		return fmt.Errorf("error: total written bytes (%d) doesn't match expected file size (%d)", totalWritten, job.FileSize)
 	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(assembledPath, job.Config.OutputPath); err != nil {
+		return fmt.Errorf("error renaming (finalizing) output file: %v", err)
+	}
 
 	for _, tempFilePath := range tempFiles {
 		os.Remove(tempFilePath)
@@ -0,0 +1,212 @@
+package danzohttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/danzo/utils"
+)
+
+// HTTPRequest customizes the download request with a method and a body given
+// inline or read from a file. The zero value is a plain GET.
+type HTTPRequest struct {
+	Method      string
+	Data        string
+	DataFile    string
+	ContentType string
+}
+
+// idempotentMethods may be repeated safely (RFC 9110, section 9.2.2).
+var idempotentMethods = []string{"GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE"}
+
+func (r HTTPRequest) hasBody() bool {
+	return r.Data != "" || r.DataFile != ""
+}
+
+// method defaults to POST when there is a body, like curl's --data.
+func (r HTTPRequest) method() string {
+	switch {
+	case r.Method != "":
+		return strings.ToUpper(r.Method)
+	case r.hasBody():
+		return "POST"
+	default:
+		return "GET"
+	}
+}
+
+func (r HTTPRequest) isGET() bool {
+	return r.method() == "GET" && !r.hasBody()
+}
+
+func (r HTTPRequest) idempotent() bool {
+	return slices.Contains(idempotentMethods, r.method())
+}
+
+// newRequest builds the request for url. The body is read on every call so a
+// retry resends it in full; without --content-type, a body that parses as
+// JSON is sent as application/json and anything else as a form, as curl does.
+func (r HTTPRequest) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	if !r.hasBody() {
+		return http.NewRequestWithContext(ctx, r.method(), url, nil)
+	}
+	body := []byte(r.Data)
+	if r.DataFile != "" {
+		var err error
+		if body, err = os.ReadFile(r.DataFile); err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method(), url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	contentType := r.ContentType
+	if contentType == "" && json.Valid(body) {
+		contentType = "application/json"
+	} else if contentType == "" {
+		contentType = "application/x-www-form-urlencoded"
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+// PerformRequestDownload sends request to url and writes the whole response
+// body to outputPath, decoding it when compressed is set. Neither a custom
+// request nor a compressed stream can resume at an offset, so a retry starts
+// over, and a request that is not idempotent is only sent once.
+func PerformRequestDownload(ctx context.Context, url, outputPath string, request HTTPRequest, compressed bool, client *utils.DanzoHTTPClient, progressCh chan<- int64) error {
+	defer close(progressCh)
+	tempDir := filepath.Join(filepath.Dir(outputPath), ".danzo-temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("error creating temp directory: %v", err)
+	}
+	tempOutputPath := fmt.Sprintf("%s.part", filepath.Join(tempDir, filepath.Base(outputPath)))
+
+	var reported int64
+	maxRetries := 5
+	if !request.idempotent() {
+		maxRetries = 1
+	}
+	var lastErr error
+	for retry := range maxRetries {
+		if retry > 0 {
+			time.Sleep(time.Duration(retry+1) * 500 * time.Millisecond)
+		}
+		if reported != 0 {
+			progressCh <- -reported
+			reported = 0
+		}
+		outFile, err := os.OpenFile(tempOutputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %v", err)
+		}
+		err = requestAttempt(ctx, url, request, compressed, outFile, client, progressCh, &reported)
+		outFile.Close()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if err := os.Rename(tempOutputPath, outputPath); err != nil {
+			return fmt.Errorf("error renaming (finalizing) output file: %v", err)
+		}
+		return nil
+	}
+	os.Remove(tempOutputPath)
+	if maxRetries == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("download failed after %d retries: %w", maxRetries, lastErr)
+}
+
+// PerformRequestStream is PerformRequestDownload for stdout. Bytes that were
+// already written cannot be taken back, so it only retries when the failed
+// attempt wrote nothing.
+func PerformRequestStream(ctx context.Context, url string, w io.Writer, request HTTPRequest, compressed bool, client *utils.DanzoHTTPClient, progressCh chan<- int64) error {
+	defer close(progressCh)
+	var reported int64
+	maxRetries := 5
+	if !request.idempotent() {
+		maxRetries = 1
+	}
+	var lastErr error
+	for retry := range maxRetries {
+		if retry > 0 {
+			time.Sleep(time.Duration(retry+1) * 500 * time.Millisecond)
+		}
+		if reported != 0 {
+			progressCh <- -reported
+			reported = 0
+		}
+		cw := &countingWriter{w: w}
+		err := requestAttempt(ctx, url, request, compressed, cw, client, progressCh, &reported)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if cw.n > 0 {
+			return fmt.Errorf("error streaming response body: %v", err)
+		}
+		lastErr = err
+	}
+	if maxRetries == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("download failed after %d retries: %w", maxRetries, lastErr)
+}
+
+func requestAttempt(ctx context.Context, url string, request HTTPRequest, compressed bool, w io.Writer, client *utils.DanzoHTTPClient, progressCh chan<- int64, reported *int64) error {
+	req, err := request.newRequest(ctx, url)
+	if err != nil {
+		return fmt.Errorf("error creating %s request: %v", request.method(), err)
+	}
+	if compressed {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error executing %s request: %v", request.method(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var body io.ReadCloser = io.NopCloser(&wireCounter{r: resp.Body, progressCh: progressCh, reported: reported})
+	if compressed {
+		if body, err = newDecoder(resp.Header.Get("Content-Encoding"), body); err != nil {
+			return err
+		}
+	}
+	defer body.Close()
+	buffer := make([]byte, utils.DefaultBufferSize)
+	if _, err := io.CopyBuffer(w, body, buffer); err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,214 @@
+package danzohttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tanq16/danzo/utils"
+	"golang.org/x/sync/errgroup"
+)
+
+// streamBlockSize is the size of each ranged request in a multi-connection
+// stream. The reassembly window holds at most 2x connections blocks in memory.
+const streamBlockSize = utils.DefaultBufferSize / 4
+
+// PerformStreamDownload writes the body of url to w sequentially. Retries
+// resume with a Range request, or discard the already written prefix when the
+// server ignores the Range header, since w cannot be rewound.
+func PerformStreamDownload(ctx context.Context, url string, w io.Writer, client *utils.DanzoHTTPClient, progressCh chan<- int64) error {
+	defer close(progressCh)
+	var written int64
+	maxRetries := 5
+	var lastErr error
+	for retry := range maxRetries {
+		if retry > 0 {
+			time.Sleep(time.Duration(retry+1) * 500 * time.Millisecond)
+		}
+		err := streamAttempt(ctx, url, w, client, progressCh, &written)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("stream failed after %d retries: %w", maxRetries, lastErr)
+}
+
+func streamAttempt(ctx context.Context, url string, w io.Writer, client *utils.DanzoHTTPClient, progressCh chan<- int64, written *int64) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating GET request: %v", err)
+	}
+	if *written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", *written))
+	}
+	req.Header.Set("Connection", "keep-alive")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error executing GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case *written > 0 && resp.StatusCode == http.StatusPartialContent:
+		// happy path: server is honoring the Range request
+	case *written > 0 && resp.StatusCode == http.StatusOK:
+		// server ignored Range; skip the prefix that was already written
+		if _, err := io.CopyN(io.Discard, resp.Body, *written); err != nil {
+			return fmt.Errorf("error skipping already written bytes: %v", err)
+		}
+	case *written == 0 && resp.StatusCode == http.StatusOK:
+		// fresh download
+	default:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	buffer := make([]byte, utils.DefaultBufferSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		bytesRead, readErr := resp.Body.Read(buffer)
+		if bytesRead > 0 {
+			if _, writeErr := w.Write(buffer[:bytesRead]); writeErr != nil {
+				return fmt.Errorf("error writing output: %v", writeErr)
+			}
+			*written += int64(bytesRead)
+			progressCh <- int64(bytesRead)
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("error reading response body: %v", readErr)
+		}
+	}
+}
+
+// PerformMultiStreamDownload fetches fileSize bytes in fixed-size blocks over
+// parallel ranged requests and writes them to w strictly in order. Blocks are
+// only requested while they fit in the reassembly window, so memory use stays
+// bounded no matter how far ahead the fastest connection gets.
+func PerformMultiStreamDownload(ctx context.Context, config HTTPDownloadConfig, client *utils.DanzoHTTPClient, fileSize int64, w io.Writer, progressCh chan<- int64) error {
+	defer close(progressCh)
+	if config.Connections < 1 {
+		config.Connections = 1
+	}
+	numBlocks := int((fileSize + streamBlockSize - 1) / streamBlockSize)
+	blocks := make([]chan []byte, numBlocks)
+	for i := range blocks {
+		blocks[i] = make(chan []byte, 1)
+	}
+	window := make(chan struct{}, 2*config.Connections)
+	workers := make(chan struct{}, config.Connections)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		for i := range numBlocks {
+			select {
+			case window <- struct{}{}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			select {
+			case workers <- struct{}{}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			g.Go(func() error {
+				defer func() { <-workers }()
+				start := int64(i) * streamBlockSize
+				end := min(start+streamBlockSize, fileSize) - 1
+				data, err := fetchStreamBlock(gctx, config.URL, start, end, client, progressCh)
+				if err != nil {
+					return fmt.Errorf("block %d failed: %w", i, err)
+				}
+				blocks[i] <- data
+				return nil
+			})
+		}
+		return nil
+	})
+	g.Go(func() error {
+		for i := range numBlocks {
+			select {
+			case data := <-blocks[i]:
+				if _, err := w.Write(data); err != nil {
+					return fmt.Errorf("error writing output: %v", err)
+				}
+				<-window
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+		return nil
+	})
+	return g.Wait()
+}
+
+func fetchStreamBlock(ctx context.Context, url string, start, end int64, client *utils.DanzoHTTPClient, progressCh chan<- int64) ([]byte, error) {
+	data := make([]byte, end-start+1)
+	maxRetries := 5
+	var lastErr error
+	for retry := range maxRetries {
+		if retry > 0 {
+			time.Sleep(time.Duration(retry+1) * 500 * time.Millisecond)
+		}
+		n, err := fetchStreamBlockAttempt(ctx, url, start, end, data, client, progressCh)
+		if err == nil {
+			return data, nil
+		}
+		// take back this attempt's progress so the total never overshoots
+		if n > 0 {
+			progressCh <- -n
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+}
+
+func fetchStreamBlockAttempt(ctx context.Context, url string, start, end int64, data []byte, client *utils.DanzoHTTPClient, progressCh chan<- int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Set("Connection", "keep-alive")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var filled int64
+	for filled < int64(len(data)) {
+		n, err := resp.Body.Read(data[filled:])
+		if n > 0 {
+			filled += int64(n)
+			progressCh <- int64(n)
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return filled, err
+		}
+	}
+	if filled != int64(len(data)) {
+		return filled, fmt.Errorf("size mismatch: expected %d bytes, got %d", len(data), filled)
+	}
+	return filled, nil
+}
@@ -0,0 +1,809 @@
+package m3u8
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/danzo/utils"
+)
+
+// DASH MPD elements, limited to what on-demand downloads need. Segment
+// information may sit on the Period, AdaptationSet or Representation; the
+// most specific one wins attribute by attribute.
+type mpd struct {
+	Type                      string       `xml:"type,attr"`
+	MediaPresentationDuration string       `xml:"mediaPresentationDuration,attr"`
+	BaseURL                   []string     `xml:"BaseURL"`
+	Periods                   []dashPeriod `xml:"Period"`
+}
+
+type dashPeriod struct {
+	Start          string              `xml:"start,attr"`
+	Duration       string              `xml:"duration,attr"`
+	BaseURL        []string            `xml:"BaseURL"`
+	AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+	dashSegmentInfo
+}
+
+type dashAdaptationSet struct {
+	MimeType          string               `xml:"mimeType,attr"`
+	ContentType       string               `xml:"contentType,attr"`
+	Lang              string               `xml:"lang,attr"`
+	Codecs            string               `xml:"codecs,attr"`
+	FrameRate         string               `xml:"frameRate,attr"`
+	Label             string               `xml:"Label"`
+	Roles             []dashDescriptor     `xml:"Role"`
+	ContentProtection []dashDescriptor     `xml:"ContentProtection"`
+	BaseURL           []string             `xml:"BaseURL"`
+	Representations   []dashRepresentation `xml:"Representation"`
+	dashSegmentInfo
+}
+
+type dashRepresentation struct {
+	ID                string           `xml:"id,attr"`
+	Bandwidth         int              `xml:"bandwidth,attr"`
+	Width             int              `xml:"width,attr"`
+	Height            int              `xml:"height,attr"`
+	Codecs            string           `xml:"codecs,attr"`
+	MimeType          string           `xml:"mimeType,attr"`
+	FrameRate         string           `xml:"frameRate,attr"`
+	ContentProtection []dashDescriptor `xml:"ContentProtection"`
+	BaseURL           []string         `xml:"BaseURL"`
+	dashSegmentInfo
+}
+
+type dashDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type dashSegmentInfo struct {
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *dashSegmentList     `xml:"SegmentList"`
+	SegmentBase     *dashSegmentBase     `xml:"SegmentBase"`
+}
+
+type dashSegmentTemplate struct {
+	Media                  string           `xml:"media,attr"`
+	Initialization         string           `xml:"initialization,attr"`
+	StartNumber            *int64           `xml:"startNumber,attr"`
+	Timescale              *int64           `xml:"timescale,attr"`
+	Duration               *int64           `xml:"duration,attr"`
+	PresentationTimeOffset *int64           `xml:"presentationTimeOffset,attr"`
+	Timeline               *dashTimeline    `xml:"SegmentTimeline"`
+	InitializationElement  *dashURLWithByte `xml:"Initialization"`
+}
+
+type dashTimeline struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type dashSegmentList struct {
+	Timescale      *int64           `xml:"timescale,attr"`
+	Duration       *int64           `xml:"duration,attr"`
+	Initialization *dashURLWithByte `xml:"Initialization"`
+	SegmentURLs    []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+type dashSegmentBase struct {
+	IndexRange     string           `xml:"indexRange,attr"`
+	Timescale      *int64           `xml:"timescale,attr"`
+	Initialization *dashURLWithByte `xml:"Initialization"`
+}
+
+type dashURLWithByte struct {
+	SourceURL string `xml:"sourceURL,attr"`
+	Range     string `xml:"range,attr"`
+}
+
+func isDASHManifest(content string) bool {
+	return strings.Contains(content[:min(len(content), 4096)], "<MPD")
+}
+
+// dashStream is a selectable representation of one period.
+type dashStream struct {
+	period  *dashPeriod
+	set     *dashAdaptationSet
+	rep     *dashRepresentation
+	baseURL string
+}
+
+// dashPeriodStreams is a period's representations in the shape HLS selection
+// works on: video representations as variants and audio and text adaptation
+// sets (their best representation) as renditions, keyed by Variant/Rendition URL.
+type dashPeriodStreams struct {
+	master  MasterPlaylist
+	streams map[string]dashStream
+}
+
+func parseMPDDocument(content string) (*mpd, error) {
+	var doc mpd
+	if err := xml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, fmt.Errorf("error parsing MPD: %v", err)
+	}
+	if doc.Type == "dynamic" {
+		return nil, fmt.Errorf("live (dynamic) DASH manifests are not supported")
+	}
+	if len(doc.Periods) == 0 {
+		return nil, fmt.Errorf("MPD has no periods")
+	}
+	return &doc, nil
+}
+
+// parseMPD turns an on-demand MPD into the same M3U8Info an HLS master
+// playlist produces, selecting representations with the same options. The
+// segments of successive periods are concatenated.
+func parseMPD(ctx context.Context, content, manifestURL string, opts Options, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
+	doc, err := parseMPDDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	base, err := resolveBaseURL(manifestURL, doc.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	info := &M3U8Info{EndList: true, Fragmented: true, VideoPlaylistURL: manifestURL}
+	var offset time.Duration
+	for i := range doc.Periods {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		period := &doc.Periods[i]
+		duration := periodDuration(doc, i, offset)
+		if start, err := parseISODuration(period.Start); err == nil && period.Start != "" {
+			offset = start
+		}
+		offset += duration
+		periodInfo, err := parseDASHPeriod(ctx, period, base, duration, opts, client)
+		if err != nil {
+			return nil, fmt.Errorf("period %d: %v", i+1, err)
+		}
+		mergePeriod(info, periodInfo)
+	}
+	// segment files are named by sequence, which restarts in every period
+	for _, segments := range [][]Segment{info.VideoSegments, info.AudioSegments} {
+		for i := range segments {
+			segments[i].Sequence = int64(i)
+		}
+	}
+	for _, tracks := range [][]MediaTrack{info.ExtraAudio, info.Subtitles} {
+		for _, t := range tracks {
+			for i := range t.Segments {
+				t.Segments[i].Sequence = int64(i)
+			}
+		}
+	}
+	return info, nil
+}
+
+// mergePeriod appends a period's segments; extra tracks are matched to the
+// earlier periods' by language and name. Every later period starts with a
+// discontinuity, and its first segment carries the period's init section
+// when that differs from the one in use.
+func mergePeriod(info, period *M3U8Info) {
+	first := len(info.VideoSegments) == 0
+	info.VideoSegments = appendPeriod(info.VideoSegments, info.VideoInit, period.VideoSegments, period.VideoInit)
+	info.AudioSegments = appendPeriod(info.AudioSegments, info.AudioInit, period.AudioSegments, period.AudioInit)
+	if first {
+		info.VideoInit, info.AudioInit = period.VideoInit, period.AudioInit
+		info.HasSeparateAudio = period.HasSeparateAudio
+		info.MainAudio, info.AudioInVariant = period.MainAudio, period.AudioInVariant
+		info.VariantURL = period.VariantURL
+	}
+	appendTracks := func(tracks []MediaTrack, more []MediaTrack) []MediaTrack {
+		for _, t := range more {
+			found := false
+			for i := range tracks {
+				if tracks[i].Rendition.Language == t.Rendition.Language && tracks[i].Rendition.Name == t.Rendition.Name {
+					tracks[i].Segments = appendPeriod(tracks[i].Segments, tracks[i].Init, t.Segments, t.Init)
+					found = true
+					break
+				}
+			}
+			if !found {
+				tracks = append(tracks, t)
+			}
+		}
+		return tracks
+	}
+	info.ExtraAudio = appendTracks(info.ExtraAudio, period.ExtraAudio)
+	info.Subtitles = appendTracks(info.Subtitles, period.Subtitles)
+}
+
+// appendPeriod appends a later period's segments to those of the earlier
+// periods, which started from init.
+func appendPeriod(segments []Segment, init *Segment, more []Segment, moreInit *Segment) []Segment {
+	if len(segments) == 0 || len(more) == 0 {
+		return append(segments, more...)
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i].Init != nil {
+			init = segments[i].Init
+			break
+		}
+	}
+	start := len(segments)
+	segments = append(segments, more...)
+	segments[start].Discontinuity = true
+	if !sameSegment(init, moreInit) {
+		segments[start].Init = moreInit
+	}
+	return segments
+}
+
+func sameSegment(a, b *Segment) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.URL == b.URL && a.Offset == b.Offset && a.Length == b.Length
+}
+
+func parseDASHPeriod(ctx context.Context, period *dashPeriod, base string, duration time.Duration, opts Options, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
+	ps, err := periodStreams(period, base)
+	if err != nil {
+		return nil, err
+	}
+	segmentsOf := func(key string) (MediaTrack, error) {
+		s := ps.streams[key]
+		init, segments, err := representationSegments(ctx, s, duration, client)
+		return MediaTrack{Segments: segments, Init: init}, err
+	}
+
+	audio, err := selectAudio(ps.master.Renditions, Variant{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	subtitles, err := selectSubtitles(ps.master.Renditions, Variant{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	info := &M3U8Info{}
+	if len(ps.master.Variants) == 0 {
+		// audio-only presentation: the first audio track stands in for video
+		if len(audio) == 0 {
+			return nil, fmt.Errorf("no video or audio representations found")
+		}
+		main, err := segmentsOf(audio[0].URL)
+		if err != nil {
+			return nil, err
+		}
+		info.VideoSegments, info.VideoInit = main.Segments, main.Init
+		audio = audio[1:]
+	} else {
+		variant, err := selectVariant(ps.master.Variants, opts)
+		if err != nil {
+			return nil, err
+		}
+		video, err := segmentsOf(variant.URL)
+		if err != nil {
+			return nil, err
+		}
+		info.VideoSegments, info.VideoInit = video.Segments, video.Init
+		info.VariantURL = variant.URL
+		if len(audio) > 0 && (len(opts.Audio) == 0 || matchesRendition(audio[0], opts.Audio[0])) {
+			main, err := segmentsOf(audio[0].URL)
+			if err != nil {
+				return nil, err
+			}
+			info.AudioSegments, info.AudioInit = main.Segments, main.Init
+			info.HasSeparateAudio = true
+			info.MainAudio = &audio[0]
+			audio = audio[1:]
+		}
+	}
+	for _, r := range audio {
+		extra, err := segmentsOf(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		extra.Rendition = r
+		info.ExtraAudio = append(info.ExtraAudio, extra)
+	}
+	for _, r := range subtitles {
+		sub, err := segmentsOf(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		sub.Rendition = r
+		info.Subtitles = append(info.Subtitles, sub)
+	}
+	return info, nil
+}
+
+// periodStreams lists a period's representations for selection. Text tracks
+// are only offered when they are plain WebVTT.
+func periodStreams(period *dashPeriod, base string) (*dashPeriodStreams, error) {
+	periodBase, err := resolveBaseURL(base, period.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	ps := &dashPeriodStreams{streams: map[string]dashStream{}}
+	for a := range period.AdaptationSets {
+		set := &period.AdaptationSets[a]
+		setBase, err := resolveBaseURL(periodBase, set.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		var best *dashRepresentation
+		var bestKey string
+		for r := range set.Representations {
+			rep := &set.Representations[r]
+			kind := contentKind(set, rep)
+			if kind == "" {
+				continue
+			}
+			if len(set.ContentProtection) > 0 || len(rep.ContentProtection) > 0 {
+				return nil, fmt.Errorf("stream is DRM-protected (ContentProtection) and cannot be decrypted")
+			}
+			repBase, err := resolveBaseURL(setBase, rep.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+			key := fmt.Sprintf("dash:%d/%d", a, r)
+			ps.streams[key] = dashStream{period: period, set: set, rep: rep, baseURL: repBase}
+			if kind == "video" {
+				frameRate, _ := parseFrameRate(cmp.Or(rep.FrameRate, set.FrameRate))
+				ps.master.Variants = append(ps.master.Variants, Variant{
+					URL:       key,
+					Bandwidth: rep.Bandwidth,
+					Width:     rep.Width,
+					Height:    rep.Height,
+					Codecs:    cmp.Or(rep.Codecs, set.Codecs),
+					FrameRate: frameRate,
+				})
+				continue
+			}
+			if best == nil || rep.Bandwidth > best.Bandwidth {
+				best, bestKey = rep, key
+			}
+		}
+		if best == nil {
+			continue
+		}
+		rendition := Rendition{
+			Type:     map[string]string{"audio": "AUDIO", "text": "SUBTITLES"}[contentKind(set, best)],
+			Name:     cmp.Or(set.Label, set.Lang, best.ID),
+			Language: set.Lang,
+			URL:      bestKey,
+		}
+		for _, role := range set.Roles {
+			rendition.Default = rendition.Default || role.Value == "main"
+		}
+		ps.master.Renditions = append(ps.master.Renditions, rendition)
+	}
+	return ps, nil
+}
+
+// contentKind is "video", "audio", "text" (WebVTT only) or "" for anything
+// that cannot be downloaded.
+func contentKind(set *dashAdaptationSet, rep *dashRepresentation) string {
+	mimeType := cmp.Or(rep.MimeType, set.MimeType)
+	kind := set.ContentType
+	if kind == "" {
+		kind, _, _ = strings.Cut(mimeType, "/")
+	}
+	switch kind {
+	case "video", "audio":
+		return kind
+	case "text":
+		if mimeType == "text/vtt" {
+			return "text"
+		}
+	}
+	return ""
+}
+
+// representationSegments resolves the init section and media segments of a
+// representation from its SegmentTemplate, SegmentList or SegmentBase, or
+// takes its BaseURL as a single segment.
+func representationSegments(ctx context.Context, s dashStream, duration time.Duration, client *utils.DanzoHTTPClient) (*Segment, []Segment, error) {
+	if t := mergeTemplates(s.period.SegmentTemplate, s.set.SegmentTemplate, s.rep.SegmentTemplate); t != nil {
+		return templateSegments(t, s, duration)
+	}
+	if l := firstNonNil(s.rep.SegmentList, s.set.SegmentList, s.period.SegmentList); l != nil {
+		return listSegments(l, s.baseURL)
+	}
+	if b := firstNonNil(s.rep.SegmentBase, s.set.SegmentBase, s.period.SegmentBase); b != nil {
+		return baseSegments(ctx, b, s.baseURL, duration, client)
+	}
+	return nil, []Segment{{URL: s.baseURL, Duration: duration.Seconds()}}, nil
+}
+
+func firstNonNil[T any](values ...*T) *T {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// mergeTemplates combines SegmentTemplates from least to most specific.
+func mergeTemplates(templates ...*dashSegmentTemplate) *dashSegmentTemplate {
+	var merged *dashSegmentTemplate
+	for _, t := range templates {
+		if t == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &dashSegmentTemplate{}
+		}
+		merged.Media = cmp.Or(t.Media, merged.Media)
+		merged.Initialization = cmp.Or(t.Initialization, merged.Initialization)
+		merged.StartNumber = cmpPtr(t.StartNumber, merged.StartNumber)
+		merged.Timescale = cmpPtr(t.Timescale, merged.Timescale)
+		merged.Duration = cmpPtr(t.Duration, merged.Duration)
+		merged.PresentationTimeOffset = cmpPtr(t.PresentationTimeOffset, merged.PresentationTimeOffset)
+		merged.Timeline = cmpPtr(t.Timeline, merged.Timeline)
+		merged.InitializationElement = cmpPtr(t.InitializationElement, merged.InitializationElement)
+	}
+	return merged
+}
+
+func cmpPtr[T any](a, b *T) *T {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+func valueOr(p *int64, fallback int64) int64 {
+	if p != nil {
+		return *p
+	}
+	return fallback
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time|)(%0(\d+)d)?\$`)
+
+// expandTemplate fills $RepresentationID$, $Number$, $Bandwidth$ and $Time$
+// (with optional %0Nd widths); "$$" is a literal dollar sign.
+func expandTemplate(template string, rep *dashRepresentation, number, t int64) string {
+	return templateIdentifier.ReplaceAllStringFunc(template, func(match string) string {
+		parts := templateIdentifier.FindStringSubmatch(match)
+		width, _ := strconv.Atoi(parts[3])
+		var value int64
+		switch parts[1] {
+		case "":
+			return "$"
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			value = number
+		case "Bandwidth":
+			value = int64(rep.Bandwidth)
+		case "Time":
+			value = t
+		}
+		return fmt.Sprintf("%0*d", width, value)
+	})
+}
+
+func templateSegments(t *dashSegmentTemplate, s dashStream, duration time.Duration) (*Segment, []Segment, error) {
+	base, err := url.Parse(s.baseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid BaseURL: %v", err)
+	}
+	resolve := func(ref string) (string, error) { return resolveURL(base, ref) }
+	var init *Segment
+	if t.Initialization != "" {
+		initURL, err := resolve(expandTemplate(t.Initialization, s.rep, 0, 0))
+		if err != nil {
+			return nil, nil, err
+		}
+		init = &Segment{URL: initURL}
+	} else if t.InitializationElement != nil {
+		if init, err = rangedSegment(base, t.InitializationElement.SourceURL, t.InitializationElement.Range); err != nil {
+			return nil, nil, err
+		}
+	}
+	if t.Media == "" {
+		return nil, nil, fmt.Errorf("SegmentTemplate without media")
+	}
+	timescale := valueOr(t.Timescale, 1)
+	number := valueOr(t.StartNumber, 1)
+	var segments []Segment
+	add := func(time, d int64) error {
+		segmentURL, err := resolve(expandTemplate(t.Media, s.rep, number, time))
+		if err != nil {
+			return err
+		}
+		segments = append(segments, Segment{URL: segmentURL, Sequence: number, Duration: float64(d) / float64(timescale)})
+		number++
+		return nil
+	}
+
+	if t.Timeline != nil {
+		var current int64
+		for i, entry := range t.Timeline.S {
+			if entry.T != nil {
+				current = *entry.T
+			}
+			if entry.D <= 0 {
+				return nil, nil, fmt.Errorf("invalid SegmentTimeline duration %d", entry.D)
+			}
+			repeat := entry.R
+			if repeat < 0 {
+				// repeat until the next S element's time or the period's end
+				end := valueOr(t.PresentationTimeOffset, 0) + int64(duration.Seconds()*float64(timescale))
+				if i+1 < len(t.Timeline.S) && t.Timeline.S[i+1].T != nil {
+					end = *t.Timeline.S[i+1].T
+				}
+				repeat = int64(math.Ceil(float64(end-current)/float64(entry.D))) - 1
+			}
+			for range repeat + 1 {
+				if err := add(current, entry.D); err != nil {
+					return nil, nil, err
+				}
+				current += entry.D
+			}
+		}
+		return init, segments, nil
+	}
+
+	if t.Duration == nil || *t.Duration <= 0 {
+		return nil, nil, fmt.Errorf("SegmentTemplate needs a duration or a SegmentTimeline")
+	}
+	if duration <= 0 {
+		return nil, nil, fmt.Errorf("cannot count segments without a period or presentation duration")
+	}
+	count := int64(math.Ceil(duration.Seconds() * float64(timescale) / float64(*t.Duration)))
+	for i := range count {
+		if err := add(valueOr(t.PresentationTimeOffset, 0)+i**t.Duration, *t.Duration); err != nil {
+			return nil, nil, err
+		}
+	}
+	return init, segments, nil
+}
+
+func listSegments(l *dashSegmentList, baseURL string) (*Segment, []Segment, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid BaseURL: %v", err)
+	}
+	var init *Segment
+	if l.Initialization != nil {
+		if init, err = rangedSegment(base, l.Initialization.SourceURL, l.Initialization.Range); err != nil {
+			return nil, nil, err
+		}
+	}
+	segmentDuration := 0.0
+	if l.Duration != nil {
+		segmentDuration = float64(*l.Duration) / float64(valueOr(l.Timescale, 1))
+	}
+	var segments []Segment
+	for i, s := range l.SegmentURLs {
+		seg, err := rangedSegment(base, s.Media, s.MediaRange)
+		if err != nil {
+			return nil, nil, err
+		}
+		seg.Sequence, seg.Duration = int64(i), segmentDuration
+		segments = append(segments, *seg)
+	}
+	return init, segments, nil
+}
+
+// rangedSegment resolves a sourceURL/media reference (the BaseURL when empty)
+// with an optional "first-last" byte range.
+func rangedSegment(base *url.URL, ref, byteRange string) (*Segment, error) {
+	seg := &Segment{URL: base.String()}
+	if ref != "" {
+		var err error
+		if seg.URL, err = resolveURL(base, ref); err != nil {
+			return nil, err
+		}
+	}
+	if byteRange != "" {
+		first, last, err := parseByteRange(byteRange)
+		if err != nil {
+			return nil, err
+		}
+		seg.Offset, seg.Length = first, last-first+1
+	}
+	return seg, nil
+}
+
+func parseByteRange(r string) (int64, int64, error) {
+	firstStr, lastStr, ok := strings.Cut(r, "-")
+	first, err1 := strconv.ParseInt(firstStr, 10, 64)
+	last, err2 := strconv.ParseInt(lastStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid byte range %q", r)
+	}
+	return first, last, nil
+}
+
+// baseSegments splits a single-file representation into its subsegments by
+// reading the sidx box at indexRange. Without one the file is one segment.
+func baseSegments(ctx context.Context, b *dashSegmentBase, baseURL string, duration time.Duration, client *utils.DanzoHTTPClient) (*Segment, []Segment, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid BaseURL: %v", err)
+	}
+	var init *Segment
+	if b.Initialization != nil {
+		if init, err = rangedSegment(base, b.Initialization.SourceURL, b.Initialization.Range); err != nil {
+			return nil, nil, err
+		}
+	}
+	if b.IndexRange == "" {
+		return init, []Segment{{URL: baseURL, Duration: duration.Seconds()}}, nil
+	}
+	first, last, err := parseByteRange(b.IndexRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := openSegment(ctx, Segment{URL: baseURL, Offset: first, Length: last - first + 1}, client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching segment index: %v", err)
+	}
+	defer body.Close()
+	sidx, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading segment index: %v", err)
+	}
+	refs, err := parseSidx(sidx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if init == nil && first > 0 {
+		// everything before the index is the init section (ftyp and moov)
+		init = &Segment{URL: baseURL, Offset: 0, Length: first}
+	}
+	offset := last + 1 + refs.firstOffset
+	var segments []Segment
+	for i, ref := range refs.entries {
+		segments = append(segments, Segment{
+			URL:      baseURL,
+			Sequence: int64(i),
+			Duration: float64(ref.duration) / float64(refs.timescale),
+			Offset:   offset,
+			Length:   ref.size,
+		})
+		offset += ref.size
+	}
+	return init, segments, nil
+}
+
+type sidxBox struct {
+	timescale   uint32
+	firstOffset int64
+	entries     []struct{ size, duration int64 }
+}
+
+// parseSidx reads an ISO BMFF segment index box (ISO/IEC 14496-12 8.16.3).
+func parseSidx(data []byte) (*sidxBox, error) {
+	for len(data) >= 8 {
+		size := int64(binary.BigEndian.Uint32(data))
+		if size < 8 || size > int64(len(data)) {
+			break
+		}
+		if string(data[4:8]) != "sidx" {
+			data = data[size:]
+			continue
+		}
+		box := data[8:size]
+		if len(box) < 12 {
+			break
+		}
+		version := box[0]
+		sidx := &sidxBox{timescale: binary.BigEndian.Uint32(box[8:12])}
+		box = box[12:]
+		if version == 0 {
+			if len(box) < 8 {
+				break
+			}
+			sidx.firstOffset = int64(binary.BigEndian.Uint32(box[4:8]))
+			box = box[8:]
+		} else {
+			if len(box) < 16 {
+				break
+			}
+			sidx.firstOffset = int64(binary.BigEndian.Uint64(box[8:16]))
+			box = box[16:]
+		}
+		if len(box) < 4 {
+			break
+		}
+		count := int(binary.BigEndian.Uint16(box[2:4]))
+		box = box[4:]
+		if len(box) < count*12 || sidx.timescale == 0 {
+			break
+		}
+		for i := range count {
+			entry := box[i*12:]
+			if entry[0]&0x80 != 0 {
+				return nil, fmt.Errorf("hierarchical segment indexes are not supported")
+			}
+			sidx.entries = append(sidx.entries, struct{ size, duration int64 }{
+				size:     int64(binary.BigEndian.Uint32(entry) & 0x7fffffff),
+				duration: int64(binary.BigEndian.Uint32(entry[4:])),
+			})
+		}
+		return sidx, nil
+	}
+	return nil, fmt.Errorf("invalid or missing sidx box in segment index")
+}
+
+func resolveBaseURL(parent string, baseURLs []string) (string, error) {
+	if len(baseURLs) == 0 || strings.TrimSpace(baseURLs[0]) == "" {
+		return parent, nil
+	}
+	base, err := url.Parse(parent)
+	if err != nil {
+		return "", fmt.Errorf("invalid BaseURL: %v", err)
+	}
+	return resolveURL(base, strings.TrimSpace(baseURLs[0]))
+}
+
+// periodDuration is the Period's duration, or the time until the next
+// period's start or the end of the presentation.
+func periodDuration(doc *mpd, i int, offset time.Duration) time.Duration {
+	period := doc.Periods[i]
+	if d, err := parseISODuration(period.Duration); err == nil && period.Duration != "" {
+		return d
+	}
+	start := offset
+	if s, err := parseISODuration(period.Start); err == nil && period.Start != "" {
+		start = s
+	}
+	if i+1 < len(doc.Periods) && doc.Periods[i+1].Start != "" {
+		if next, err := parseISODuration(doc.Periods[i+1].Start); err == nil {
+			return next - start
+		}
+	}
+	if total, err := parseISODuration(doc.MediaPresentationDuration); err == nil && doc.MediaPresentationDuration != "" {
+		return total - start
+	}
+	return 0
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISODuration reads xs:duration values like "PT1H2M3.5S" (days, hours,
+// minutes and seconds; years and months do not occur in MPDs).
+func parseISODuration(s string) (time.Duration, error) {
+	m := isoDuration.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var total float64
+	for i, unit := range []float64{86400, 3600, 60, 1} {
+		if m[i+1] != "" {
+			v, _ := strconv.ParseFloat(m[i+1], 64)
+			total += v * unit
+		}
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// parseFrameRate reads "30" or "30000/1001".
+func parseFrameRate(s string) (float64, error) {
+	num, den, ok := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || !ok {
+		return n, err
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0, fmt.Errorf("invalid frame rate %q", s)
+	}
+	return math.Round(n/d*1000) / 1000, nil
+}
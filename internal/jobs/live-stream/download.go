@@ -2,11 +2,16 @@ package m3u8
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/tanq16/danzo/utils"
 	"golang.org/x/sync/errgroup"
@@ -30,8 +35,15 @@ func detectFMP4Format(manifestURL string, segmentURLs []string) bool {
 	return false
 }
 
-func downloadSegmentsParallel(ctx context.Context, segmentURLs []string, outputDir string, numWorkers int, client *utils.DanzoHTTPClient, progressFunc func(int64, int64), totalSize int64, isFMP4 bool) ([]string, error) {
-	downloadedFiles := make([]string, len(segmentURLs))
+// downloadSegmentsParallel downloads segments into outputDir. Up to
+// maxMissing segments may still fail after their retries; their files are ""
+// and they are returned as gaps instead of failing the download. When every
+// segment failed, the gaps come with the error for callers that download a
+// track in batches.
+func downloadSegmentsParallel(ctx context.Context, segments []Segment, outputDir string, numWorkers int, keys *keyStore, client *utils.DanzoHTTPClient, progressFunc func(int64, int64), totalSize int64, isFMP4 bool, maxMissing int) ([]string, []segmentGap, error) {
+	downloadedFiles := make([]string, len(segments))
+	failures := make([]error, len(segments))
+	var missing atomic.Int32
 	ext := ".ts"
 	if isFMP4 {
 		ext = ".m4s"
@@ -42,11 +54,23 @@ func downloadSegmentsParallel(ctx context.Context, segmentURLs []string, outputD
 		numWorkers = 1
 	}
 	g.SetLimit(numWorkers)
-	for i, segmentURL := range segmentURLs {
+	for i, seg := range segments {
 		g.Go(func() error {
-			outputPath := filepath.Join(outputDir, fmt.Sprintf("segment_%04d%s", i, ext))
-			size, err := downloadSegment(ctx, segmentURL, outputPath, client)
+			outputPath := filepath.Join(outputDir, fmt.Sprintf("segment_%04d%s", seg.Sequence, ext))
+			if info, err := os.Stat(outputPath); err == nil {
+				// finished by an earlier run of the job
+				downloadedFiles[i] = outputPath
+				if progressFunc != nil {
+					progressFunc(info.Size(), totalSize)
+				}
+				return nil
+			}
+			size, err := saveSegment(ctx, seg, outputPath, keys, client)
 			if err != nil {
+				if ctx.Err() == nil && int(missing.Add(1)) <= maxMissing {
+					failures[i] = err
+					return nil
+				}
 				return fmt.Errorf("error downloading segment %d: %v", i, err)
 			}
 			downloadedFiles[i] = outputPath
@@ -56,17 +80,161 @@ func downloadSegmentsParallel(ctx context.Context, segmentURLs []string, outputD
 			return nil
 		})
 	}
-	return downloadedFiles, g.Wait()
+	if err := g.Wait(); err != nil {
+		return downloadedFiles, nil, err
+	}
+	if n := len(segments); n > 0 && int(missing.Load()) == n {
+		return downloadedFiles, findGaps(segments, failures), fmt.Errorf("all %d segments failed: %v", n, failures[0])
+	}
+	return downloadedFiles, findGaps(segments, failures), nil
+}
+
+// segmentGap is a run of consecutive segments that failed to download.
+type segmentGap struct {
+	first, last int64   // media sequence numbers
+	start       float64 // seconds into the track
+	duration    float64
+	err         error // why the first one failed
+}
+
+func (g segmentGap) String() string {
+	at := time.Duration(g.start * float64(time.Second)).Round(time.Second)
+	length := time.Duration(g.duration * float64(time.Second)).Round(time.Second)
+	if g.first == g.last {
+		return fmt.Sprintf("segment %d at %s (%s): %v", g.first, at, length, g.err)
+	}
+	return fmt.Sprintf("segments %d-%d at %s (%s): %v", g.first, g.last, at, length, g.err)
+}
+
+func findGaps(segments []Segment, failures []error) []segmentGap {
+	var gaps []segmentGap
+	var elapsed float64
+	for i, seg := range segments {
+		if failures[i] != nil {
+			if n := len(gaps); n > 0 && i > 0 && failures[i-1] != nil {
+				gaps[n-1].last = seg.Sequence
+				gaps[n-1].duration += seg.Duration
+			} else {
+				gaps = append(gaps, segmentGap{first: seg.Sequence, last: seg.Sequence, start: elapsed, duration: seg.Duration, err: failures[i]})
+			}
+		}
+		elapsed += seg.Duration
+	}
+	return gaps
+}
+
+// withoutGaps drops the segments that failed to download. The segment after
+// each gap is marked as a discontinuity since its timestamps jump.
+func withoutGaps(segments []Segment, files []string) ([]Segment, []string) {
+	var keptSegments []Segment
+	var keptFiles []string
+	gap := false
+	var init *Segment // switched to by a dropped segment
+	for i, file := range files {
+		if file == "" {
+			gap = true
+			if segments[i].Init != nil {
+				init = segments[i].Init
+			}
+			continue
+		}
+		seg := segments[i]
+		if gap && len(keptSegments) > 0 {
+			seg.Discontinuity = true
+		}
+		if seg.Init == nil {
+			seg.Init = init
+		}
+		gap, init = false, nil
+		keptSegments = append(keptSegments, seg)
+		keptFiles = append(keptFiles, file)
+	}
+	return keptSegments, keptFiles
+}
+
+const segmentAttempts = 5
+
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+// saveSegment downloads a segment into a .part file that is renamed once
+// complete, so a segment file on disk is always whole. Failed attempts are
+// retried with exponential backoff, except when the segment is gone.
+func saveSegment(ctx context.Context, seg Segment, outputPath string, keys *keyStore, client *utils.DanzoHTTPClient) (int64, error) {
+	partPath := outputPath + ".part"
+	defer os.Remove(partPath)
+	var lastErr error
+	attempts := 0
+	for attempts < segmentAttempts {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(retryDelay(attempts)):
+			}
+		}
+		attempts++
+		size, err := fetchSegment(ctx, seg, partPath, keys, client)
+		if err == nil {
+			return size, os.Rename(partPath, outputPath)
+		}
+		lastErr = err
+		var status *statusError
+		if ctx.Err() != nil || (errors.As(err, &status) && !status.retryable()) {
+			break
+		}
+	}
+	if attempts == 1 {
+		return 0, lastErr
+	}
+	return 0, fmt.Errorf("failed after %d attempts: %w", attempts, lastErr)
+}
+
+// retryDelay doubles with every attempt up to retryMaxDelay, with up to 25%
+// jitter so parallel workers don't retry in lockstep.
+func retryDelay(attempt int) time.Duration {
+	delay := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return delay - time.Duration(rand.Int64N(int64(delay/4)))
+}
+
+// FindFFmpeg resolves the ffmpeg binary: path when one is given, which must
+// exist, otherwise "ffmpeg" on PATH. An empty result means ffmpeg is not
+// available and segments are joined natively instead.
+func FindFFmpeg(path string) (string, error) {
+	if path != "" {
+		resolved, err := exec.LookPath(path)
+		if err != nil {
+			return "", fmt.Errorf("ffmpeg not found at %s: %v", path, err)
+		}
+		return resolved, nil
+	}
+	resolved, _ := exec.LookPath("ffmpeg")
+	return resolved, nil
 }
 
-func mergeSegments(ctx context.Context, segmentFiles []string, outputPath string, isFMP4 bool, initSegment string, tempDir string, client *utils.DanzoHTTPClient) error {
+// mergeSegments joins downloaded segments into outputPath using the ffmpeg
+// binary, or natively when ffmpeg is "".
+func mergeSegments(ctx context.Context, segments []Segment, segmentFiles []string, outputPath string, isFMP4 bool, initSegment *Segment, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient, ffmpeg string) error {
+	for _, seg := range segments {
+		if seg.Key != nil && seg.Key.Method == "SAMPLE-AES" {
+			if ffmpeg == "" {
+				return fmt.Errorf("SAMPLE-AES streams are decrypted by ffmpeg, which was not found (see --ffmpeg)")
+			}
+			return mergeSampleAESSegments(ctx, segments, segmentFiles, outputPath, initSegment, tempDir, keys, client, ffmpeg)
+		}
+	}
 	if isFMP4 {
-		return mergeFMP4Segments(ctx, segmentFiles, outputPath, initSegment, tempDir, client)
+		return mergeFMP4Segments(ctx, segments, segmentFiles, outputPath, initSegment, tempDir, keys, client, ffmpeg)
 	}
-	return mergeTSSegments(segmentFiles, outputPath)
+	if ffmpeg == "" {
+		return concatTS(segments, segmentFiles, outputPath)
+	}
+	return mergeTSSegments(segmentFiles, outputPath, ffmpeg)
 }
 
-func mergeTSSegments(segmentFiles []string, outputPath string) error {
+func mergeTSSegments(segmentFiles []string, outputPath, ffmpeg string) error {
 	tempListFile := filepath.Join(filepath.Dir(outputPath), ".segment_list.txt")
 	f, err := os.Create(tempListFile)
 	if err != nil {
@@ -82,7 +250,7 @@ func mergeTSSegments(segmentFiles []string, outputPath string) error {
 	}
 	f.Close()
 	cmd := exec.Command(
-		"ffmpeg",
+		ffmpeg,
 		"-f", "concat",
 		"-safe", "0",
 		"-i", tempListFile,
@@ -97,48 +265,196 @@ func mergeTSSegments(segmentFiles []string, outputPath string) error {
 	return nil
 }
 
-func mergeFMP4Segments(ctx context.Context, segmentFiles []string, outputPath string, initSegment string, tempDir string, client *utils.DanzoHTTPClient) error {
-	tempConcatFile := filepath.Join(filepath.Dir(outputPath), ".concat_temp.m4s")
-	defer os.Remove(tempConcatFile)
-	outFile, err := os.Create(tempConcatFile)
+// mergeFMP4Segments joins the fragments behind their init section. Where a
+// DASH period switches to another init section, each run of fragments is
+// written as its own file and ffmpeg concatenates them.
+func mergeFMP4Segments(ctx context.Context, segments []Segment, segmentFiles []string, outputPath string, initSegment *Segment, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient, ffmpeg string) error {
+	type run struct {
+		init  *Segment
+		files []string
+	}
+	runs := []run{{init: initSegment}}
+	for i, file := range segmentFiles {
+		last := &runs[len(runs)-1]
+		if init := segments[i].Init; init != nil {
+			if len(last.files) == 0 {
+				last.init = init
+			} else {
+				runs = append(runs, run{init: init})
+				last = &runs[len(runs)-1]
+			}
+		}
+		last.files = append(last.files, file)
+	}
+
+	if len(runs) == 1 {
+		tempConcatFile := filepath.Join(filepath.Dir(outputPath), ".concat_temp.m4s")
+		defer os.Remove(tempConcatFile)
+		if err := writeFMP4(ctx, tempConcatFile, runs[0].init, filepath.Join(tempDir, "init.mp4"), runs[0].files, keys, client); err != nil {
+			return err
+		}
+		if ffmpeg == "" {
+			// init section plus fragments already is a playable fragmented MP4;
+			// ffmpeg would only move the index to the front
+			return os.Rename(tempConcatFile, outputPath)
+		}
+		cmd := exec.Command(
+			ffmpeg,
+			"-i", tempConcatFile,
+			"-c", "copy",
+			"-movflags", "+faststart",
+			"-y",
+			outputPath,
+		)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ffmpeg error: %v\nOutput: %s", err, string(output))
+		}
+		return nil
+	}
+
+	if ffmpeg == "" {
+		return fmt.Errorf("the stream's periods use different init sections and are joined by ffmpeg, which was not found (see --ffmpeg)")
+	}
+	tempListFile := filepath.Join(filepath.Dir(outputPath), ".segment_list.txt")
+	f, err := os.Create(tempListFile)
+	if err != nil {
+		return fmt.Errorf("error creating segment list file: %v", err)
+	}
+	defer os.Remove(tempListFile)
+	for i, r := range runs {
+		partPath := filepath.Join(tempDir, fmt.Sprintf("part_%d.m4s", i))
+		if err := writeFMP4(ctx, partPath, r.init, filepath.Join(tempDir, fmt.Sprintf("init_%d.mp4", i)), r.files, keys, client); err != nil {
+			f.Close()
+			return err
+		}
+		absPath, err := filepath.Abs(partPath)
+		if err != nil {
+			absPath = partPath
+		}
+		fmt.Fprintf(f, "file '%s'\n", absPath)
+	}
+	f.Close()
+	cmd := exec.Command(
+		ffmpeg,
+		"-f", "concat",
+		"-safe", "0",
+		"-i", tempListFile,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-y",
+		outputPath,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %v\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+// writeFMP4 writes the init section, downloaded to initPath, followed by the
+// fragments to path.
+func writeFMP4(ctx context.Context, path string, initSegment *Segment, initPath string, segmentFiles []string, keys *keyStore, client *utils.DanzoHTTPClient) error {
+	outFile, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("error creating temp concat file: %v", err)
 	}
-	if initSegment != "" {
-		initPath := filepath.Join(tempDir, "init.mp4")
-		_, err := downloadSegment(ctx, initSegment, initPath, client)
+	defer outFile.Close()
+	if initSegment != nil {
+		_, err := fetchSegment(ctx, *initSegment, initPath, keys, client)
 		if err != nil {
-			outFile.Close()
 			return fmt.Errorf("error downloading init segment: %v", err)
 		}
 		initData, err := os.ReadFile(initPath)
 		if err != nil {
-			outFile.Close()
 			return fmt.Errorf("error reading init segment: %v", err)
 		}
 		if _, err := outFile.Write(initData); err != nil {
-			outFile.Close()
 			return fmt.Errorf("error writing init segment: %v", err)
 		}
 	}
 	for i, segmentFile := range segmentFiles {
 		data, err := os.ReadFile(segmentFile)
 		if err != nil {
-			outFile.Close()
 			return fmt.Errorf("error reading segment %d: %v", i, err)
 		}
 		if _, err := outFile.Write(data); err != nil {
-			outFile.Close()
 			return fmt.Errorf("error writing segment %d: %v", i, err)
 		}
 	}
-	outFile.Close()
+	return outFile.Close()
+}
+
+// mergeSampleAESSegments has ffmpeg decrypt SAMPLE-AES streams, which
+// encrypt individual audio and video samples rather than whole segments. The
+// downloaded segments are listed in a local playlist with the keys saved next
+// to them, so ffmpeg reads everything from disk.
+func mergeSampleAESSegments(ctx context.Context, segments []Segment, segmentFiles []string, outputPath string, initSegment *Segment, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient, ffmpeg string) error {
+	// ffmpeg resolves playlist entries against the playlist's own directory
+	if absDir, err := filepath.Abs(tempDir); err == nil {
+		tempDir = absDir
+	}
+	var playlist strings.Builder
+	targetDuration := 1.0
+	for _, seg := range segments {
+		targetDuration = max(targetDuration, seg.Duration)
+	}
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n", int(math.Ceil(targetDuration)), segments[0].Sequence)
+	if initSegment != nil {
+		initPath := filepath.Join(tempDir, "init.mp4")
+		if _, err := downloadSegment(ctx, *initSegment, initPath, client); err != nil {
+			return fmt.Errorf("error downloading init segment: %v", err)
+		}
+		fmt.Fprintf(&playlist, "#EXT-X-MAP:URI=\"%s\"\n", filepath.ToSlash(initPath))
+	}
+	keyFiles := map[string]string{}
+	var lastKey *SegmentKey
+	for i, seg := range segments {
+		if seg.Key != lastKey {
+			lastKey = seg.Key
+			if seg.Key == nil {
+				playlist.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+			} else {
+				keyFile, ok := keyFiles[seg.Key.URI]
+				if !ok {
+					key, err := keys.get(ctx, seg.Key.URI)
+					if err != nil {
+						return err
+					}
+					keyFile = filepath.Join(tempDir, fmt.Sprintf("key_%d.bin", len(keyFiles)))
+					if err := os.WriteFile(keyFile, key, 0600); err != nil {
+						return fmt.Errorf("error saving key: %v", err)
+					}
+					keyFiles[seg.Key.URI] = keyFile
+				}
+				fmt.Fprintf(&playlist, "#EXT-X-KEY:METHOD=%s,URI=\"%s\"", seg.Key.Method, filepath.ToSlash(keyFile))
+				if seg.Key.IV != nil {
+					fmt.Fprintf(&playlist, ",IV=0x%x", seg.Key.IV)
+				}
+				playlist.WriteString("\n")
+			}
+		}
+		absPath, err := filepath.Abs(segmentFiles[i])
+		if err != nil {
+			absPath = segmentFiles[i]
+		}
+		if i > 0 && seg.Discontinuity {
+			playlist.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", seg.Duration, filepath.ToSlash(absPath))
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
 
+	playlistPath := filepath.Join(tempDir, "local.m3u8")
+	if err := os.WriteFile(playlistPath, []byte(playlist.String()), 0600); err != nil {
+		return fmt.Errorf("error writing local playlist: %v", err)
+	}
 	cmd := exec.Command(
-		"ffmpeg",
-		"-i", tempConcatFile,
+		ffmpeg,
+		"-allowed_extensions", "ALL",
+		"-protocol_whitelist", "file,crypto",
+		"-i", playlistPath,
 		"-c", "copy",
-		"-movflags", "+faststart",
 		"-y",
 		outputPath,
 	)
@@ -149,9 +465,9 @@ func mergeFMP4Segments(ctx context.Context, segmentFiles []string, outputPath st
 	return nil
 }
 
-func mergeVideoAndAudio(videoPath, audioPath, outputPath string) error {
+func mergeVideoAndAudio(videoPath, audioPath, outputPath, ffmpeg string) error {
 	cmd := exec.Command(
-		"ffmpeg",
+		ffmpeg,
 		"-i", videoPath,
 		"-i", audioPath,
 		"-c", "copy",
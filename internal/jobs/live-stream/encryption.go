@@ -0,0 +1,152 @@
+package m3u8
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/tanq16/danzo/utils"
+)
+
+// SegmentKey is the #EXT-X-KEY in effect for a segment.
+type SegmentKey struct {
+	Method string // "AES-128" or "SAMPLE-AES"
+	URI    string
+	IV     []byte // nil when derived from the media sequence number
+}
+
+// ivFor returns the key's IV, or the media sequence number as a big-endian
+// 128-bit value when the playlist gives none.
+func (k *SegmentKey) ivFor(sequence int64) []byte {
+	if k.IV != nil {
+		return k.IV
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+// parseKeyTag reads the attributes of an #EXT-X-KEY tag. A nil key means
+// METHOD=NONE. KEYFORMAT is returned so DRM keys (anything but "identity")
+// can be told apart.
+func parseKeyTag(baseURL *url.URL, attrs map[string]string) (*SegmentKey, string, error) {
+	keyFormat := attrs["KEYFORMAT"]
+	if keyFormat == "" {
+		keyFormat = "identity"
+	}
+	method := strings.ToUpper(attrs["METHOD"])
+	switch method {
+	case "NONE":
+		return nil, keyFormat, nil
+	case "AES-128", "SAMPLE-AES", "SAMPLE-AES-CTR":
+	default:
+		return nil, keyFormat, fmt.Errorf("unsupported encryption method %q", attrs["METHOD"])
+	}
+	if keyFormat != "identity" {
+		return nil, keyFormat, nil
+	}
+	if method == "SAMPLE-AES-CTR" {
+		return nil, keyFormat, fmt.Errorf("unsupported encryption method %q", attrs["METHOD"])
+	}
+	if attrs["URI"] == "" {
+		return nil, keyFormat, fmt.Errorf("%s key without URI", method)
+	}
+	keyURL, err := resolveURL(baseURL, attrs["URI"])
+	if err != nil {
+		return nil, keyFormat, fmt.Errorf("error resolving key URL: %v", err)
+	}
+	key := &SegmentKey{Method: method, URI: keyURL}
+	if iv := attrs["IV"]; iv != "" {
+		raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+		if err != nil || len(raw) != aes.BlockSize {
+			return nil, keyFormat, fmt.Errorf("invalid key IV %q", iv)
+		}
+		key.IV = raw
+	}
+	return key, keyFormat, nil
+}
+
+// keyStore fetches each key URI once through the job's client; rotating keys
+// are usually re-used by several segments.
+type keyStore struct {
+	client *utils.DanzoHTTPClient
+	mu     sync.Mutex
+	keys   map[string][]byte
+}
+
+func newKeyStore(client *utils.DanzoHTTPClient) *keyStore {
+	return &keyStore{client: client, keys: map[string][]byte{}}
+}
+
+func (s *keyStore) get(ctx context.Context, uri string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[uri]; ok {
+		return key, nil
+	}
+	key, err := fetchKey(ctx, uri, s.client)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("key %s is %d bytes, expected %d", uri, len(key), aes.BlockSize)
+	}
+	s.keys[uri] = key
+	return key, nil
+}
+
+func fetchKey(ctx context.Context, uri string, client *utils.DanzoHTTPClient) ([]byte, error) {
+	if data, ok := strings.CutPrefix(uri, "data:"); ok {
+		meta, payload, found := strings.Cut(data, ",")
+		if !found {
+			return nil, fmt.Errorf("invalid data URI for key")
+		}
+		if strings.HasSuffix(meta, ";base64") {
+			return base64.StdEncoding.DecodeString(payload)
+		}
+		unescaped, err := url.PathUnescape(payload)
+		return []byte(unescaped), err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating key request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching key: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key server returned status code %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1024))
+}
+
+// decryptAES128 undoes AES-128-CBC segment encryption, including the PKCS#7
+// padding.
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment size %d is not a multiple of the block size", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("invalid padding after decryption (wrong key or IV?)")
+	}
+	return out[:len(out)-pad], nil
+}
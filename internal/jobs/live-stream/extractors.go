@@ -3,19 +3,22 @@ package m3u8
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
 	"net/http"
-	"net/http/cookiejar"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/tanq16/danzo/utils"
 )
 
 type RumbleJSResponse struct {
-	U struct {
+	Title string `json:"title"`
+	U     struct {
 		HLS struct {
 			URL string `json:"url"`
 		} `json:"hls"`
@@ -28,50 +31,176 @@ type RumbleJSResponse struct {
 }
 
 type DailymotionMetadata struct {
+	Title     string `json:"title"`
 	Qualities map[string][]struct {
 		Type string `json:"type"`
 		URL  string `json:"url"`
 	} `json:"qualities"`
 }
 
-func runExtractor(ctx context.Context, urlPtr *string, extractor string, httpConfig utils.HTTPClientConfig) error {
-	switch strings.ToLower(extractor) {
-	case "rumble":
-		return extractRumbleURL(ctx, urlPtr, httpConfig)
-	case "dailymotion":
-		return extractDailymotionURL(ctx, urlPtr, httpConfig)
-	default:
-		return nil
+// Extractor finds the stream behind a video page of a site that doesn't link
+// its manifest directly.
+type Extractor interface {
+	// Match reports whether pageURL belongs to the extractor's site, for
+	// picking one when --extract is not given.
+	Match(pageURL string) bool
+	Extract(ctx context.Context, pageURL string, client *utils.DanzoHTTPClient) (*Extraction, error)
+}
+
+// Extraction is what an extractor found on a page.
+type Extraction struct {
+	Manifests []string          // HLS or DASH manifest URLs, preferred first
+	Headers   map[string]string // sent with every manifest, key and segment request
+	Title     string            // names the output when none is given
+}
+
+type namedExtractor struct {
+	name string
+	Extractor
+}
+
+var extractors []namedExtractor
+
+// RegisterExtractor makes an extractor available as --extract name and for
+// matching page URLs. Extractors are matched in registration order.
+func RegisterExtractor(name string, e Extractor) {
+	extractors = append(extractors, namedExtractor{name: name, Extractor: e})
+}
+
+func init() {
+	RegisterExtractor("rumble", rumbleExtractor{})
+	RegisterExtractor("dailymotion", dailymotionExtractor{})
+}
+
+// ExtractorNames lists the registered extractors for --list-extractors.
+func ExtractorNames() []string {
+	names := make([]string, len(extractors))
+	for i, e := range extractors {
+		names[i] = e.name
+	}
+	return names
+}
+
+// MatchExtractor returns the name of the extractor for pageURL, or "" when
+// none matches.
+func MatchExtractor(pageURL string) string {
+	for _, e := range extractors {
+		if e.Match(pageURL) {
+			return e.name
+		}
+	}
+	return ""
+}
+
+// findExtractor returns the extractor called name, or the first one matching
+// pageURL when name is empty. nil means pageURL is a manifest.
+func findExtractor(name, pageURL string) (Extractor, error) {
+	for _, e := range extractors {
+		if (name == "" && e.Match(pageURL)) || strings.EqualFold(e.name, name) {
+			return e.Extractor, nil
+		}
 	}
+	if name != "" {
+		return nil, fmt.Errorf("unknown extractor %q (available: %s)", name, strings.Join(ExtractorNames(), ", "))
+	}
+	return nil, nil
 }
 
-func extractRumbleURL(ctx context.Context, urlPtr *string, httpConfig utils.HTTPClientConfig) error {
-	videoID, err := getRumbleVideoID(ctx, *urlPtr, httpConfig)
+// extractStream runs the extractor for pageURL, if any.
+func extractStream(ctx context.Context, pageURL, name string, httpConfig utils.HTTPClientConfig) (*Extraction, error) {
+	extractor, err := findExtractor(name, pageURL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if extractor == nil {
+		return &Extraction{Manifests: []string{pageURL}}, nil
 	}
-	m3u8URL, err := getRumbleM3U8FromVideoID(ctx, videoID, httpConfig)
+	found, err := extractor.Extract(ctx, pageURL, utils.NewDanzoHTTPClient(httpConfig))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("extractor failed: %v", err)
+	}
+	if len(found.Manifests) == 0 {
+		return nil, fmt.Errorf("extractor found no stream on %s", pageURL)
+	}
+	return found, nil
+}
+
+// fetchFirstManifest fetches the first of the manifests that loads.
+func fetchFirstManifest(ctx context.Context, manifests []string, client *utils.DanzoHTTPClient) (string, string, error) {
+	var errs []error
+	for _, manifestURL := range manifests {
+		content, err := getM3U8Contents(ctx, manifestURL, client)
+		if err == nil {
+			return manifestURL, content, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", "", fmt.Errorf("error fetching manifest: %v", errors.Join(errs...))
+}
+
+// withHeaders adds an extractor's headers to the configured ones, which win
+// when both set the same header.
+func withHeaders(httpConfig utils.HTTPClientConfig, headers map[string]string) utils.HTTPClientConfig {
+	if len(headers) == 0 {
+		return httpConfig
 	}
-	*urlPtr = m3u8URL
-	return nil
+	merged := maps.Clone(headers)
+	maps.Copy(merged, httpConfig.Headers)
+	httpConfig.Headers = merged
+	return httpConfig
+}
+
+// matchesHost reports whether pageURL is on one of the domains or their
+// subdomains.
+func matchesHost(pageURL string, domains ...string) bool {
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+type rumbleExtractor struct{}
+
+func (rumbleExtractor) Match(pageURL string) bool {
+	return matchesHost(pageURL, "rumble.com")
 }
 
-func getRumbleVideoID(ctx context.Context, pageURL string, clientConfig utils.HTTPClientConfig) (string, error) {
-	jar, _ := cookiejar.New(nil)
-	newClientConfig := clientConfig
-	newClientConfig.Jar = jar
-	newClientConfig.Headers = make(map[string]string)
-	maps.Copy(newClientConfig.Headers, clientConfig.Headers)
-	newClientConfig.Headers["Connection"] = "keep-alive"
-	newClientConfig.Headers["Upgrade-Insecure-Requests"] = "1"
-	client := utils.NewDanzoHTTPClient(newClientConfig)
+func (rumbleExtractor) Extract(ctx context.Context, pageURL string, client *utils.DanzoHTTPClient) (*Extraction, error) {
+	videoID, err := getRumbleVideoID(ctx, pageURL, client)
+	if err != nil {
+		return nil, err
+	}
+	data, err := getRumbleVideo(ctx, videoID, client)
+	if err != nil {
+		return nil, err
+	}
+	found := &Extraction{Title: data.Title, Headers: map[string]string{"Referer": "https://rumble.com/"}}
+	if data.U.HLS.URL != "" {
+		found.Manifests = append(found.Manifests, data.U.HLS.URL)
+	}
+	if auto, ok := data.UA.HLS["auto"]; ok && auto.URL != "" && auto.URL != data.U.HLS.URL {
+		found.Manifests = append(found.Manifests, auto.URL)
+	}
+	if len(found.Manifests) == 0 {
+		return nil, fmt.Errorf("could not find m3u8 url in rumble json response")
+	}
+	return found, nil
+}
+
+func getRumbleVideoID(ctx context.Context, pageURL string, client *utils.DanzoHTTPClient) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request for rumble page: %w", err)
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch rumble page: %w", err)
@@ -89,46 +218,68 @@ func getRumbleVideoID(ctx context.Context, pageURL string, clientConfig utils.HT
 	return "", fmt.Errorf("could not find rumble video ID in page source")
 }
 
-func getRumbleM3U8FromVideoID(ctx context.Context, videoID string, clientConfig utils.HTTPClientConfig) (string, error) {
+func getRumbleVideo(ctx context.Context, videoID string, client *utils.DanzoHTTPClient) (*RumbleJSResponse, error) {
 	jsonURL := fmt.Sprintf("https://rumble.com/embedJS/u3/?request=video&ver=2&v=%s", videoID)
-	newClientConfig := clientConfig
-	newClientConfig.Headers = make(map[string]string)
-	maps.Copy(newClientConfig.Headers, clientConfig.Headers)
-	newClientConfig.Headers["Referer"] = "https://rumble.com/"
-	client := utils.NewDanzoHTTPClient(newClientConfig)
 	req, err := http.NewRequestWithContext(ctx, "GET", jsonURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request for rumble json: %w", err)
+		return nil, fmt.Errorf("failed to create request for rumble json: %w", err)
 	}
+	req.Header.Set("Referer", "https://rumble.com/")
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch rumble json: %w", err)
+		return nil, fmt.Errorf("failed to fetch rumble json: %w", err)
 	}
 	defer resp.Body.Close()
 	var data RumbleJSResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "", fmt.Errorf("failed to decode rumble json: %w", err)
+		return nil, fmt.Errorf("failed to decode rumble json: %w", err)
 	}
-	if data.U.HLS.URL != "" {
-		return data.U.HLS.URL, nil
-	}
-	if auto, ok := data.UA.HLS["auto"]; ok && auto.URL != "" {
-		return auto.URL, nil
-	}
-	return "", fmt.Errorf("could not find m3u8 url in rumble json response")
+	return &data, nil
 }
 
-func extractDailymotionURL(ctx context.Context, urlPtr *string, httpConfig utils.HTTPClientConfig) error {
-	videoID, err := getDailymotionVideoID(*urlPtr)
+type dailymotionExtractor struct{}
+
+func (dailymotionExtractor) Match(pageURL string) bool {
+	return matchesHost(pageURL, "dailymotion.com", "dai.ly")
+}
+
+func (dailymotionExtractor) Extract(ctx context.Context, pageURL string, client *utils.DanzoHTTPClient) (*Extraction, error) {
+	videoID, err := getDailymotionVideoID(pageURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	m3u8URL, err := getDailymotionM3U8FromVideoID(ctx, videoID, httpConfig)
+	metadata, err := getDailymotionMetadata(ctx, videoID, client)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	found := &Extraction{
+		Title: metadata.Title,
+		Headers: map[string]string{
+			"Referer": "https://www.dailymotion.com/",
+			"Origin":  "https://www.dailymotion.com",
+		},
 	}
-	*urlPtr = m3u8URL
-	return nil
+	addHLS := func(mediaList []struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}) {
+		for _, media := range mediaList {
+			if media.Type == "application/x-mpegURL" && media.URL != "" && !slices.Contains(found.Manifests, media.URL) {
+				found.Manifests = append(found.Manifests, media.URL)
+			}
+		}
+	}
+	qualityPriority := []string{"auto", "1080", "720", "480", "380", "240"}
+	for _, quality := range qualityPriority {
+		addHLS(metadata.Qualities[quality])
+	}
+	for _, quality := range slices.Sorted(maps.Keys(metadata.Qualities)) {
+		addHLS(metadata.Qualities[quality])
+	}
+	if len(found.Manifests) == 0 {
+		return nil, fmt.Errorf("could not find m3u8 URL in dailymotion metadata response")
+	}
+	return found, nil
 }
 
 func getDailymotionVideoID(pageURL string) (string, error) {
@@ -147,43 +298,22 @@ func getDailymotionVideoID(pageURL string) (string, error) {
 	return "", fmt.Errorf("could not extract Dailymotion video ID from URL: %s", pageURL)
 }
 
-func getDailymotionM3U8FromVideoID(ctx context.Context, videoID string, clientConfig utils.HTTPClientConfig) (string, error) {
+func getDailymotionMetadata(ctx context.Context, videoID string, client *utils.DanzoHTTPClient) (*DailymotionMetadata, error) {
 	metadataURL := fmt.Sprintf("https://www.dailymotion.com/player/metadata/video/%s", videoID)
-	newClientConfig := clientConfig
-	newClientConfig.Headers = make(map[string]string)
-	maps.Copy(newClientConfig.Headers, clientConfig.Headers)
-	newClientConfig.Headers["Referer"] = "https://www.dailymotion.com/"
-	newClientConfig.Headers["Origin"] = "https://www.dailymotion.com"
-	client := utils.NewDanzoHTTPClient(newClientConfig)
 	req, err := http.NewRequestWithContext(ctx, "GET", metadataURL+"?app=com.dailymotion.neon", nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request for dailymotion metadata: %w", err)
+		return nil, fmt.Errorf("failed to create request for dailymotion metadata: %w", err)
 	}
+	req.Header.Set("Referer", "https://www.dailymotion.com/")
+	req.Header.Set("Origin", "https://www.dailymotion.com")
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch dailymotion metadata: %w", err)
+		return nil, fmt.Errorf("failed to fetch dailymotion metadata: %w", err)
 	}
 	defer resp.Body.Close()
 	var metadata DailymotionMetadata
 	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
-		return "", fmt.Errorf("failed to decode dailymotion metadata: %w", err)
-	}
-	qualityPriority := []string{"auto", "1080", "720", "480", "380", "240"}
-	for _, quality := range qualityPriority {
-		if mediaList, ok := metadata.Qualities[quality]; ok {
-			for _, media := range mediaList {
-				if media.Type == "application/x-mpegURL" && media.URL != "" {
-					return media.URL, nil
-				}
-			}
-		}
-	}
-	for _, mediaList := range metadata.Qualities {
-		for _, media := range mediaList {
-			if media.Type == "application/x-mpegURL" && media.URL != "" {
-				return media.URL, nil
-			}
-		}
+		return nil, fmt.Errorf("failed to decode dailymotion metadata: %w", err)
 	}
-	return "", fmt.Errorf("could not find m3u8 URL in dailymotion metadata response")
+	return &metadata, nil
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 
@@ -16,11 +17,80 @@ import (
 )
 
 type M3U8Info struct {
-	VideoSegmentURLs []string
-	AudioSegmentURLs []string
-	VideoInitSegment string
-	AudioInitSegment string
+	VideoSegments    []Segment
+	AudioSegments    []Segment
+	VideoInit        *Segment
+	AudioInit        *Segment
 	HasSeparateAudio bool
+	// media playlists the segments came from, re-polled when recording live
+	VideoPlaylistURL string
+	AudioPlaylistURL string
+	TargetDuration   float64
+	EndList          bool // false for a live playlist that is still growing
+	// renditions picked with --audio and --subs beyond the main audio
+	MainAudio      *Rendition // nil when audio is muxed into the video
+	AudioInVariant bool
+	ExtraAudio     []MediaTrack
+	Subtitles      []MediaTrack
+	Fragmented     bool   // segments are known to be fMP4/WebM fragments (DASH)
+	VariantURL     string // the variant picked from a master playlist or MPD
+}
+
+// MediaTrack is an extra audio or subtitle rendition with its media playlist.
+type MediaTrack struct {
+	Rendition Rendition
+	Segments  []Segment
+	Init      *Segment
+}
+
+// Segment is one media segment (or an #EXT-X-MAP init section) of a playlist.
+type Segment struct {
+	URL      string
+	Sequence int64       // media sequence number
+	Duration float64     // from #EXTINF, in seconds
+	Key      *SegmentKey // nil when not encrypted
+	Offset   int64       // byte range within URL when Length > 0
+	Length   int64
+	// follows an #EXT-X-DISCONTINUITY: timestamps and encoding may change
+	Discontinuity bool
+	// init section this and the following fragments use, set where a DASH
+	// period switches to a different one
+	Init *Segment
+}
+
+func segmentURLs(segments []Segment) []string {
+	urls := make([]string, len(segments))
+	for i, seg := range segments {
+		urls[i] = seg.URL
+	}
+	return urls
+}
+
+// parseAttributes reads the attribute list of a playlist tag, e.g.
+// `METHOD=AES-128,URI="key.bin",IV=0x1f`, with quotes removed.
+func parseAttributes(list string) map[string]string {
+	attrs := map[string]string{}
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.ToUpper(strings.TrimSpace(name))] = value
+		list = rest
+	}
+	return attrs
 }
 
 func getM3U8Contents(ctx context.Context, manifestURL string, client *utils.DanzoHTTPClient) (string, error) {
@@ -43,24 +113,40 @@ func getM3U8Contents(ctx context.Context, manifestURL string, client *utils.Danz
 	return string(content), nil
 }
 
-type audioTrack struct {
-	url     string
-	quality int
+// parseManifest reads an HLS playlist or a DASH MPD.
+func parseManifest(ctx context.Context, content, manifestURL string, opts Options, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
+	if isDASHManifest(content) {
+		return parseMPD(ctx, content, manifestURL, opts, client)
+	}
+	return parseM3U8Content(ctx, content, manifestURL, opts, client)
 }
 
-func parseM3U8Content(ctx context.Context, content, manifestURL string, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
+func parseM3U8Content(ctx context.Context, content, manifestURL string, opts Options, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
 	baseURL, err := url.Parse(manifestURL)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing manifest URL: %v", err)
 	}
+	if isMasterPlaylist(content) {
+		master, err := parseMasterPlaylist(ctx, content, manifestURL)
+		if err != nil {
+			return nil, err
+		}
+		return resolveMasterPlaylist(ctx, master, opts, client)
+	}
 	scanner := bufio.NewScanner(strings.NewReader(content))
-	var segmentURLs []string
-	var masterPlaylistURLs []string
-	var masterPlaylistBandwidths []int
-	var audioTracks []audioTrack
-	var isMasterPlaylist bool
-	var initSegment string
-	var currentBandwidth int
+	var segments []Segment
+	var initSegment *Segment
+	var mediaSequence int64
+	var duration, targetDuration float64
+	var endList bool
+	// a byte range without an offset continues where the previous one ended
+	var rangeLength, rangeOffset, nextOffset int64
+	var discontinuity bool
+	// keys apply until the next #EXT-X-KEY; several in a row are alternatives
+	// in different KEYFORMATs, of which only "identity" can be used
+	var currentKey *SegmentKey
+	var drmKeyFormat string
+	keyGroupOpen := false
 
 	for scanner.Scan() {
 		select {
@@ -72,157 +158,195 @@ func parseM3U8Content(ctx context.Context, content, manifestURL string, client *
 		if line == "" {
 			continue
 		}
-		if strings.HasPrefix(line, "#EXT-X-MAP:") {
-			if idx := strings.Index(line, `URI="`); idx != -1 {
-				uriStart := idx + 5
-				if uriEnd := strings.Index(line[uriStart:], `"`); uriEnd != -1 {
-					uri := line[uriStart : uriStart+uriEnd]
-					initSegment, err = resolveURL(baseURL, uri)
-					if err != nil {
-						return nil, fmt.Errorf("error resolving init segment URL: %v", err)
+		if value, ok := strings.CutPrefix(line, "#EXT-X-MAP:"); ok {
+			attrs := parseAttributes(value)
+			if attrs["URI"] != "" {
+				initURL, err := resolveURL(baseURL, attrs["URI"])
+				if err != nil {
+					return nil, fmt.Errorf("error resolving init segment URL: %v", err)
+				}
+				initSegment = &Segment{URL: initURL, Sequence: mediaSequence + int64(len(segments)), Key: currentKey}
+				if r := attrs["BYTERANGE"]; r != "" {
+					// an init section's range starts at 0 unless given
+					if initSegment.Length, initSegment.Offset, err = parseByteRangeTag(r, 0); err != nil {
+						return nil, err
 					}
 				}
 			}
 			continue
 		}
-		if strings.HasPrefix(line, "#EXT-X-MEDIA:") && strings.Contains(line, "TYPE=AUDIO") {
-			isMasterPlaylist = true
-			var audioURL string
-			if idx := strings.Index(line, `URI="`); idx != -1 {
-				uriStart := idx + 5
-				if uriEnd := strings.Index(line[uriStart:], `"`); uriEnd != -1 {
-					uri := line[uriStart : uriStart+uriEnd]
-					audioURL, err = resolveURL(baseURL, uri)
-					if err != nil {
-						return nil, fmt.Errorf("error resolving audio URL: %v", err)
-					}
-				}
-			}
-			quality := 0
-			groupID := ""
-			if idx := strings.Index(line, `GROUP-ID="`); idx != -1 {
-				groupStart := idx + 10
-				if groupEnd := strings.Index(line[groupStart:], `"`); groupEnd != -1 {
-					groupID = strings.ToLower(line[groupStart : groupStart+groupEnd])
-					if strings.Contains(groupID, "high") {
-						quality = 3
-					} else if strings.Contains(groupID, "medium") {
-						quality = 2
-					} else if strings.Contains(groupID, "low") {
-						quality = 1
-					}
-				}
-			}
-			if quality == 0 {
-				if idx := strings.Index(line, `NAME="`); idx != -1 {
-					nameStart := idx + 6
-					if nameEnd := strings.Index(line[nameStart:], `"`); nameEnd != -1 {
-						name := strings.ToLower(line[nameStart : nameStart+nameEnd])
-						if strings.Contains(name, "high") {
-							quality = 3
-						} else if strings.Contains(name, "medium") {
-							quality = 2
-						} else if strings.Contains(name, "low") {
-							quality = 1
-						}
-					}
-				}
-			}
-			if audioURL != "" {
-				audioTracks = append(audioTracks, audioTrack{url: audioURL, quality: quality})
+		if value, ok := strings.CutPrefix(line, "#EXT-X-BYTERANGE:"); ok {
+			if rangeLength, rangeOffset, err = parseByteRangeTag(value, nextOffset); err != nil {
+				return nil, err
 			}
 			continue
 		}
-		if strings.HasPrefix(line, "#") && !strings.Contains(line, "#EXT-X-STREAM-INF") {
+		if value, ok := strings.CutPrefix(line, "#EXT-X-TARGETDURATION:"); ok {
+			targetDuration, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
 			continue
 		}
-		if strings.Contains(line, "#EXT-X-STREAM-INF") {
-			isMasterPlaylist = true
-			currentBandwidth = 0
-			if idx := strings.Index(line, "BANDWIDTH="); idx != -1 {
-				bandwidthStart := idx + 10
-				bandwidthEnd := bandwidthStart
-				for bandwidthEnd < len(line) && line[bandwidthEnd] >= '0' && line[bandwidthEnd] <= '9' {
-					bandwidthEnd++
-				}
-				if bandwidthEnd > bandwidthStart {
-					fmt.Sscanf(line[bandwidthStart:bandwidthEnd], "%d", &currentBandwidth)
-				}
-			}
+		if line == "#EXT-X-DISCONTINUITY" {
+			discontinuity = true
+			continue
+		}
+		if line == "#EXT-X-ENDLIST" || line == "#EXT-X-PLAYLIST-TYPE:VOD" {
+			endList = true
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"); ok {
+			fmt.Sscanf(value, "%d", &mediaSequence)
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			durationStr, _, _ := strings.Cut(value, ",")
+			duration, _ = strconv.ParseFloat(strings.TrimSpace(durationStr), 64)
 			continue
 		}
-		if !strings.HasPrefix(line, "#") {
-			segmentURL, err := resolveURL(baseURL, line)
+		if value, ok := strings.CutPrefix(line, "#EXT-X-KEY:"); ok {
+			if !keyGroupOpen {
+				currentKey, drmKeyFormat, keyGroupOpen = nil, "", true
+			}
+			key, keyFormat, err := parseKeyTag(baseURL, parseAttributes(value))
 			if err != nil {
-				return nil, fmt.Errorf("error resolving URL: %v", err)
+				return nil, err
 			}
-			if isMasterPlaylist {
-				masterPlaylistURLs = append(masterPlaylistURLs, segmentURL)
-				masterPlaylistBandwidths = append(masterPlaylistBandwidths, currentBandwidth)
-				currentBandwidth = 0
+			if keyFormat == "identity" {
+				currentKey = key
 			} else {
-				segmentURLs = append(segmentURLs, segmentURL)
+				drmKeyFormat = keyFormat
 			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
 		}
+		segmentURL, err := resolveURL(baseURL, line)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving URL: %v", err)
+		}
+		if currentKey == nil && drmKeyFormat != "" {
+			return nil, fmt.Errorf("stream is DRM-protected (KEYFORMAT=%q) and cannot be decrypted", drmKeyFormat)
+		}
+		segments = append(segments, Segment{
+			URL:           segmentURL,
+			Sequence:      mediaSequence + int64(len(segments)),
+			Duration:      duration,
+			Key:           currentKey,
+			Offset:        rangeOffset,
+			Length:        rangeLength,
+			Discontinuity: discontinuity,
+		})
+		nextOffset = rangeOffset + rangeLength
+		duration, rangeLength, rangeOffset, discontinuity = 0, 0, 0, false
+		keyGroupOpen = false
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error scanning m3u8 content: %v", err)
 	}
 
-	if isMasterPlaylist && len(masterPlaylistURLs) > 0 {
-		bestVariantIdx := 0
-		maxBandwidth := 0
-		for i, bandwidth := range masterPlaylistBandwidths {
-			if bandwidth > maxBandwidth {
-				maxBandwidth = bandwidth
-				bestVariantIdx = i
-			}
+	return &M3U8Info{
+		VideoSegments:    segments,
+		VideoInit:        initSegment,
+		HasSeparateAudio: false,
+		VideoPlaylistURL: manifestURL,
+		TargetDuration:   targetDuration,
+		EndList:          endList,
+	}, nil
+}
+
+// parseByteRangeTag reads an #EXT-X-BYTERANGE or EXT-X-MAP BYTERANGE value,
+// "<length>[@<offset>]", using implicitOffset when no offset is given.
+func parseByteRangeTag(value string, implicitOffset int64) (int64, int64, error) {
+	lengthStr, offsetStr, hasOffset := strings.Cut(strings.TrimSpace(value), "@")
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, fmt.Errorf("invalid byte range %q", value)
+	}
+	offset := implicitOffset
+	if hasOffset {
+		if offset, err = strconv.ParseInt(offsetStr, 10, 64); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid byte range %q", value)
 		}
+	}
+	return length, offset, nil
+}
 
-		videoContent, err := getM3U8Contents(ctx, masterPlaylistURLs[bestVariantIdx], client)
+// resolveMasterPlaylist fetches the media playlists of the selected variant
+// and, when audio is delivered separately, of the selected audio rendition.
+func resolveMasterPlaylist(ctx context.Context, master *MasterPlaylist, opts Options, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
+	variant, err := selectVariant(master.Variants, opts)
+	if err != nil {
+		return nil, err
+	}
+	videoContent, err := getM3U8Contents(ctx, variant.URL, client)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching video sub-playlist: %v", err)
+	}
+	videoInfo, err := parseM3U8Content(ctx, videoContent, variant.URL, opts, client)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing video sub-playlist: %v", err)
+	}
+
+	audio, err := selectAudio(master.Renditions, variant, opts)
+	if err != nil {
+		return nil, err
+	}
+	subtitles, err := selectSubtitles(master.Renditions, variant, opts)
+	if err != nil {
+		return nil, err
+	}
+	// the first requested language is the main audio unless the variant
+	// carries it; the others become extra tracks
+	info := videoInfo
+	info.AudioInVariant = len(audio) == 0 || (len(opts.Audio) > 0 && !matchesRendition(audio[0], opts.Audio[0]))
+	if !info.AudioInVariant {
+		main, err := fetchMediaTrack(ctx, audio[0], opts, client)
 		if err != nil {
-			return nil, fmt.Errorf("error fetching video sub-playlist: %v", err)
+			return nil, err
+		}
+		info = &M3U8Info{
+			VideoSegments:    videoInfo.VideoSegments,
+			AudioSegments:    main.Segments,
+			VideoInit:        videoInfo.VideoInit,
+			AudioInit:        main.Init,
+			HasSeparateAudio: true,
+			VideoPlaylistURL: videoInfo.VideoPlaylistURL,
+			AudioPlaylistURL: audio[0].URL,
+			TargetDuration:   videoInfo.TargetDuration,
+			EndList:          videoInfo.EndList,
+			MainAudio:        &audio[0],
 		}
-		videoInfo, err := parseM3U8Content(ctx, videoContent, masterPlaylistURLs[bestVariantIdx], client)
+		audio = audio[1:]
+	}
+	for _, r := range audio {
+		extra, err := fetchMediaTrack(ctx, r, opts, client)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing video sub-playlist: %v", err)
+			return nil, err
 		}
-
-		if len(audioTracks) > 0 {
-			bestAudioIdx := 0
-			maxQuality := 0
-			for i, track := range audioTracks {
-				if track.quality > maxQuality {
-					maxQuality = track.quality
-					bestAudioIdx = i
-				}
-			}
-			audioContent, err := getM3U8Contents(ctx, audioTracks[bestAudioIdx].url, client)
-			if err != nil {
-				return nil, fmt.Errorf("error fetching audio sub-playlist: %v", err)
-			}
-			audioInfo, err := parseM3U8Content(ctx, audioContent, audioTracks[bestAudioIdx].url, client)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing audio sub-playlist: %v", err)
-			}
-
-			return &M3U8Info{
-				VideoSegmentURLs: videoInfo.VideoSegmentURLs,
-				AudioSegmentURLs: audioInfo.VideoSegmentURLs,
-				VideoInitSegment: videoInfo.VideoInitSegment,
-				AudioInitSegment: audioInfo.VideoInitSegment,
-				HasSeparateAudio: true,
-			}, nil
+		info.ExtraAudio = append(info.ExtraAudio, extra)
+	}
+	for _, r := range subtitles {
+		sub, err := fetchMediaTrack(ctx, r, opts, client)
+		if err != nil {
+			return nil, err
 		}
-
-		return videoInfo, nil
+		info.Subtitles = append(info.Subtitles, sub)
 	}
+	info.VariantURL = variant.URL
+	return info, nil
+}
 
-	return &M3U8Info{
-		VideoSegmentURLs: segmentURLs,
-		VideoInitSegment: initSegment,
-		HasSeparateAudio: false,
-	}, nil
+func fetchMediaTrack(ctx context.Context, r Rendition, opts Options, client *utils.DanzoHTTPClient) (MediaTrack, error) {
+	kind := strings.ToLower(r.Type)
+	content, err := getM3U8Contents(ctx, r.URL, client)
+	if err != nil {
+		return MediaTrack{}, fmt.Errorf("error fetching %s sub-playlist: %v", kind, err)
+	}
+	info, err := parseM3U8Content(ctx, content, r.URL, opts, client)
+	if err != nil {
+		return MediaTrack{}, fmt.Errorf("error parsing %s sub-playlist: %v", kind, err)
+	}
+	return MediaTrack{Rendition: r, Segments: info.VideoSegments, Init: info.VideoInit}, nil
 }
 
 func resolveURL(baseURL *url.URL, urlStr string) (string, error) {
@@ -237,19 +361,22 @@ func resolveURL(baseURL *url.URL, urlStr string) (string, error) {
 	return absURL.String(), nil
 }
 
-func calculateTotalSize(ctx context.Context, segmentURLs []string, numWorkers int, client *utils.DanzoHTTPClient) (int64, []int64, error) {
-	segmentSizes := make([]int64, len(segmentURLs))
+func calculateTotalSize(ctx context.Context, segments []Segment, numWorkers int, client *utils.DanzoHTTPClient) (int64, []int64, error) {
+	segmentSizes := make([]int64, len(segments))
 	var totalSize atomic.Int64
 	g, ctx := errgroup.WithContext(ctx)
 	if numWorkers < 1 {
 		numWorkers = 1
 	}
 	g.SetLimit(numWorkers)
-	for i, url := range segmentURLs {
+	for i, seg := range segments {
 		g.Go(func() error {
-			size, err := getSize(ctx, url, client)
-			if err != nil {
-				return err
+			size := seg.Length
+			if size == 0 {
+				var err error
+				if size, err = getSize(ctx, seg.URL, client); err != nil {
+					return err
+				}
 			}
 			segmentSizes[i] = size
 			totalSize.Add(size)
@@ -284,27 +411,98 @@ func getSize(ctx context.Context, url string, client *utils.DanzoHTTPClient) (in
 	return size, nil
 }
 
-func downloadSegment(ctx context.Context, segmentURL, outputPath string, client *utils.DanzoHTTPClient) (int64, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", segmentURL, nil)
+// openSegment requests a segment, or just its byte range when it has one.
+// Servers ignoring the Range header are handled by skipping to the range.
+func openSegment(ctx context.Context, seg Segment, client *utils.DanzoHTTPClient) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", seg.URL, nil)
 	if err != nil {
-		return 0, fmt.Errorf("error creating request: %v", err)
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if seg.Length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.Offset, seg.Offset+seg.Length-1))
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("error downloading segment: %v", err)
+		return nil, fmt.Errorf("error downloading segment: %v", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("server returned status code %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && seg.Length > 0:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK && seg.Length > 0:
+		if _, err := io.CopyN(io.Discard, resp.Body, seg.Offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("error skipping to segment range: %v", err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, seg.Length), resp.Body}, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, nil
 	}
+	resp.Body.Close()
+	return nil, &statusError{code: resp.StatusCode}
+}
+
+// statusError is a segment request answered with an unexpected status.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned status code %d", e.code)
+}
+
+// retryable reports whether asking again may help; a missing segment stays
+// missing.
+func (e *statusError) retryable() bool {
+	return e.code != http.StatusNotFound && e.code != http.StatusGone
+}
+
+func downloadSegment(ctx context.Context, seg Segment, outputPath string, client *utils.DanzoHTTPClient) (int64, error) {
+	body, err := openSegment(ctx, seg, client)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
 	outFile, err := os.Create(outputPath)
 	if err != nil {
 		return 0, fmt.Errorf("error creating output file: %v", err)
 	}
 	defer outFile.Close()
-	written, err := io.Copy(outFile, resp.Body)
+	written, err := io.Copy(outFile, body)
 	if err != nil {
 		return 0, fmt.Errorf("error writing segment: %v", err)
 	}
 	return written, nil
 }
+
+// fetchSegment downloads a segment to outputPath, decrypting AES-128 segments
+// on the way. SAMPLE-AES segments are stored as they are; only the samples
+// inside are encrypted, which ffmpeg undoes while merging.
+func fetchSegment(ctx context.Context, seg Segment, outputPath string, keys *keyStore, client *utils.DanzoHTTPClient) (int64, error) {
+	if seg.Key == nil || seg.Key.Method != "AES-128" {
+		return downloadSegment(ctx, seg, outputPath, client)
+	}
+	key, err := keys.get(ctx, seg.Key.URI)
+	if err != nil {
+		return 0, err
+	}
+	body, err := openSegment(ctx, seg, client)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	encrypted, err := io.ReadAll(body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading segment: %v", err)
+	}
+	decrypted, err := decryptAES128(encrypted, key, seg.Key.ivFor(seg.Sequence))
+	if err != nil {
+		return 0, fmt.Errorf("error decrypting segment: %v", err)
+	}
+	if err := os.WriteFile(outputPath, decrypted, 0644); err != nil {
+		return 0, fmt.Errorf("error writing segment: %v", err)
+	}
+	return int64(len(encrypted)), nil
+}
@@ -2,11 +2,17 @@ package m3u8
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -17,23 +23,77 @@ import (
 type LiveStreamJob struct {
 	id          string
 	URL         string
-	OutputPath  string
+	ManifestURL string // URL after the extractor ran, reused on resume
+	// headers the extractor asked for, such as a Referer, sent with every
+	// request for the stream
+	ExtractorHeaders map[string]string
+	OutputPath       string
+	// segment directory, named after the first output path and kept so a
+	// resumed job finds its segments after the output was renamed
+	TempDir     string
 	Connections int
 	Extractor   string
+	Options     Options
 	HTTPConfig  utils.HTTPClientConfig
+	ffmpeg      string   // resolved from Options.FFmpeg, "" to merge natively
+	gaps        []string // segments left out of the output, for the final report
+	continuing  bool     // TempDir was left by an earlier run, whose output is replaced
+}
+
+// Options choose what to download from a stream.
+type Options struct {
+	Duration time.Duration // media time to keep, 0 for all of it
+	Quality  string        // best (default), worst, a height like 720p or a bandwidth
+	Codec    string        // preferred video codec: avc, hevc, av1, vp9 or a CODECS prefix
+	Audio    []string      // audio renditions by LANGUAGE or NAME, main one first
+	// subtitle renditions by LANGUAGE or NAME ("all" for every one), saved
+	// next to the output as SubtitleFormat ("vtt" or "srt") and muxed into it
+	Subtitles      []string
+	SubtitleFormat string
+	FFmpeg         string // ffmpeg binary, looked up on PATH when empty
+	MaxMissing     int    // segments per track that may fail, leaving gaps
+	// variant picked by an earlier run, preferred on resume so the segments
+	// already on disk still match
+	Variant string
 }
 
 type liveStreamJobState struct {
-	URL         string            `json:"url"`
-	OutputPath  string            `json:"outputPath"`
-	Connections int               `json:"connections"`
-	Extractor   string            `json:"extractor,omitempty"`
-	ProxyURL    string            `json:"proxyURL,omitempty"`
-	UserAgent   string            `json:"userAgent,omitempty"`
-	Headers     map[string]string `json:"headers,omitempty"`
+	URL              string            `json:"url"`
+	ManifestURL      string            `json:"manifestURL,omitempty"`
+	ExtractorHeaders map[string]string `json:"extractorHeaders,omitempty"`
+	OutputPath       string            `json:"outputPath"`
+	TempDir          string            `json:"tempDir,omitempty"`
+	Connections      int               `json:"connections"`
+	Extractor        string            `json:"extractor,omitempty"`
+	Duration         time.Duration     `json:"duration,omitempty"`
+	Quality          string            `json:"quality,omitempty"`
+	Codec            string            `json:"codec,omitempty"`
+	Audio            []string          `json:"audio,omitempty"`
+	Subtitles        []string          `json:"subtitles,omitempty"`
+	SubtitleFormat   string            `json:"subtitleFormat,omitempty"`
+	FFmpeg           string            `json:"ffmpeg,omitempty"`
+	MaxMissing       int               `json:"maxMissing,omitempty"`
+	Variant          string            `json:"variant,omitempty"`
+	ProxyURL         string            `json:"proxyURL,omitempty"`
+	NoProxy          string            `json:"noProxy,omitempty"`
+	UserAgent        string            `json:"userAgent,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	CookiesFile      string            `json:"cookiesFile,omitempty"`
+	NetrcFile        string            `json:"netrcFile,omitempty"`
+	CredentialsFile  string            `json:"credentialsFile,omitempty"`
+	CACertFile       string            `json:"caCertFile,omitempty"`
+	CertFile         string            `json:"certFile,omitempty"`
+	KeyFile          string            `json:"keyFile,omitempty"`
+	Insecure         bool              `json:"insecure,omitempty"`
+	MinTLSVersion    string            `json:"minTLSVersion,omitempty"`
+	Resolve          []string          `json:"resolve,omitempty"`
+	IPVersion        int               `json:"ipVersion,omitempty"`
+	Interface        string            `json:"interface,omitempty"`
+	LocalAddress     string            `json:"localAddress,omitempty"`
+	HTTPVersion      string            `json:"httpVersion,omitempty"`
 }
 
-func New(urlStr, outputPath string, connections int, extractor string, httpConfig utils.HTTPClientConfig) *LiveStreamJob {
+func New(urlStr, outputPath string, connections int, extractor string, opts Options, httpConfig utils.HTTPClientConfig) *LiveStreamJob {
 	id := outputPath
 	if id == "" {
 		id = urlStr
@@ -44,6 +104,7 @@ func New(urlStr, outputPath string, connections int, extractor string, httpConfi
 		OutputPath:  outputPath,
 		Connections: connections,
 		Extractor:   extractor,
+		Options:     opts,
 		HTTPConfig:  httpConfig,
 	}
 }
@@ -65,34 +126,63 @@ func (j *LiveStreamJob) Run(ctx context.Context, progress chan<- highway.Progres
 		}
 	}
 
-	if err := runExtractor(ctx, &j.URL, j.Extractor, j.HTTPConfig); err != nil {
-		return fmt.Errorf("extractor failed: %v", err)
+	ffmpeg, err := FindFFmpeg(j.Options.FFmpeg)
+	if err != nil {
+		return err
+	}
+	j.ffmpeg, j.gaps = ffmpeg, nil
+
+	manifests := []string{j.ManifestURL}
+	if j.ManifestURL == "" {
+		found, err := extractStream(ctx, j.URL, j.Extractor, j.HTTPConfig)
+		if err != nil {
+			return err
+		}
+		manifests, j.ExtractorHeaders = found.Manifests, found.Headers
+		if j.OutputPath == "" && titleFileName(found.Title) != "" {
+			j.OutputPath = titleFileName(found.Title) + ".mp4"
+		}
 	}
 
 	if j.OutputPath == "" {
 		j.OutputPath = fmt.Sprintf("stream_%s.mp4", time.Now().Format("2006-01-02_15-04"))
 	}
-	if existingFile, err := os.Stat(j.OutputPath); err == nil && existingFile != nil {
+	// named before the output is renamed so running a download again picks
+	// up its segments even when its first output was saved
+	if j.TempDir == "" {
+		j.TempDir = filepath.Join(filepath.Dir(j.OutputPath), ".danzo-temp", tempDirName(j.URL, j.OutputPath))
+	}
+	tempDir := j.TempDir
+	// segments left behind mean an earlier run saved its output with gaps or
+	// was interrupted, and this run replaces that output
+	_, err = os.Stat(tempDir)
+	j.continuing = err == nil
+	if existingFile, err := os.Stat(j.OutputPath); err == nil && existingFile != nil && !j.continuing {
 		j.OutputPath = utils.RenewOutputPath(j.OutputPath)
 	}
-	tempDir := filepath.Join(filepath.Dir(j.OutputPath), ".danzo-temp", "m3u8_"+time.Now().Format("20060102150405"))
 
-	client := utils.NewDanzoHTTPClient(j.HTTPConfig)
-	manifestContent, err := getM3U8Contents(ctx, j.URL, client)
+	client := utils.NewDanzoHTTPClient(withHeaders(j.HTTPConfig, j.ExtractorHeaders))
+	manifestURL, manifestContent, err := fetchFirstManifest(ctx, manifests, client)
 	if err != nil {
-		return fmt.Errorf("error fetching manifest: %v", err)
+		return err
 	}
-	m3u8Info, err := parseM3U8Content(ctx, manifestContent, j.URL, client)
+	j.ManifestURL = manifestURL
+	m3u8Info, err := parseManifest(ctx, manifestContent, j.ManifestURL, j.Options, client)
 	if err != nil {
 		return fmt.Errorf("error processing manifest: %v", err)
 	}
+	j.Options.Variant = m3u8Info.VariantURL
 
-	if len(m3u8Info.VideoSegmentURLs) == 0 {
+	if m3u8Info.EndList && len(m3u8Info.VideoSegments) == 0 {
 		return fmt.Errorf("no video segments found in manifest")
 	}
+	if j.Options.Duration > 0 {
+		m3u8Info.VideoSegments = trimToDuration(m3u8Info.VideoSegments, j.Options.Duration)
+		m3u8Info.AudioSegments = trimToDuration(m3u8Info.AudioSegments, j.Options.Duration)
+	}
 
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return fmt.Errorf("error creating temp directory: %v", err)
+	if err := claimTempDir(tempDir, selectionKey(m3u8Info)); err != nil {
+		return err
 	}
 	var downloadErr error
 	defer func() {
@@ -101,12 +191,26 @@ func (j *LiveStreamJob) Run(ctx context.Context, progress chan<- highway.Progres
 		}
 	}()
 
-	if m3u8Info.HasSeparateAudio {
-		downloadErr = j.downloadAndMergeSeparateStreams(ctx, progress, m3u8Info, tempDir, client)
+	keys := newKeyStore(client)
+	if !m3u8Info.EndList {
+		downloadErr = j.record(ctx, progress, m3u8Info, tempDir, keys, client)
+	} else if m3u8Info.HasSeparateAudio {
+		downloadErr = j.downloadAndMergeSeparateStreams(ctx, progress, m3u8Info, tempDir, keys, client)
 	} else {
-		downloadErr = j.downloadAndMergeSingleStream(ctx, progress, m3u8Info, tempDir, client)
+		downloadErr = j.downloadAndMergeSingleStream(ctx, progress, m3u8Info, tempDir, keys, client)
 	}
 
+	if downloadErr == nil && m3u8Info.EndList {
+		downloadErr = j.addTracks(ctx, progress, m3u8Info, tempDir, keys, client)
+	}
+	if downloadErr == nil && len(j.gaps) > 0 && !m3u8Info.EndList {
+		// a live playlist has moved past the missing segments
+		downloadErr = fmt.Errorf("saved %s with missing segments: %s", j.OutputPath, strings.Join(j.gaps, "; "))
+	} else if downloadErr == nil && len(j.gaps) > 0 {
+		// the segments stay in the temp dir, so running the same download
+		// again only fetches the missing ones
+		downloadErr = fmt.Errorf("saved %s with missing segments (run the download again to retry them): %s", j.OutputPath, strings.Join(j.gaps, "; "))
+	}
 	if downloadErr != nil {
 		return downloadErr
 	}
@@ -115,14 +219,15 @@ func (j *LiveStreamJob) Run(ctx context.Context, progress chan<- highway.Progres
 	return nil
 }
 
-func (j *LiveStreamJob) downloadAndMergeSingleStream(ctx context.Context, progress chan<- highway.Progress, m3u8Info *M3U8Info, tempDir string, client *utils.DanzoHTTPClient) error {
-	segmentURLs := m3u8Info.VideoSegmentURLs
-	totalSize, _, err := calculateTotalSize(ctx, segmentURLs, j.Connections, client)
+func (j *LiveStreamJob) downloadAndMergeSingleStream(ctx context.Context, progress chan<- highway.Progress, m3u8Info *M3U8Info, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient) error {
+	segments := m3u8Info.VideoSegments
+	urls := segmentURLs(segments)
+	totalSize, _, err := calculateTotalSize(ctx, segments, j.Connections, client)
 	if err != nil {
-		totalSize = int64(len(segmentURLs)) * 1024 * 1024
+		totalSize = int64(len(urls)) * 1024 * 1024
 	}
 
-	isFMP4 := detectFMP4Format(j.URL, segmentURLs)
+	isFMP4 := m3u8Info.Fragmented || detectFMP4Format(j.ManifestURL, urls)
 
 	var totalDownloaded int64
 	wrappedProgressFunc := func(incrementalSize, _ int64) {
@@ -134,17 +239,20 @@ func (j *LiveStreamJob) downloadAndMergeSingleStream(ctx context.Context, progre
 		}
 	}
 
-	segmentFiles, err := downloadSegmentsParallel(ctx, segmentURLs, tempDir, j.Connections, client, wrappedProgressFunc, totalSize, isFMP4)
+	segmentFiles, gaps, err := downloadSegmentsParallel(ctx, segments, tempDir, j.Connections, keys, client, wrappedProgressFunc, totalSize, isFMP4, j.Options.MaxMissing)
 	if err != nil {
 		return fmt.Errorf("error downloading segments: %v", err)
 	}
-	if err := mergeSegments(ctx, segmentFiles, j.OutputPath, isFMP4, m3u8Info.VideoInitSegment, tempDir, client); err != nil {
+	j.noteGaps("", gaps)
+	segments, segmentFiles = withoutGaps(segments, segmentFiles)
+	j.useNativeExtension(isFMP4)
+	if err := mergeSegments(ctx, segments, segmentFiles, j.OutputPath, isFMP4, m3u8Info.VideoInit, tempDir, keys, client, j.ffmpeg); err != nil {
 		return fmt.Errorf("error merging segments: %v", err)
 	}
 	return nil
 }
 
-func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, progress chan<- highway.Progress, m3u8Info *M3U8Info, tempDir string, client *utils.DanzoHTTPClient) error {
+func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, progress chan<- highway.Progress, m3u8Info *M3U8Info, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient) error {
 	videoDir := filepath.Join(tempDir, "video")
 	audioDir := filepath.Join(tempDir, "audio")
 	if err := os.MkdirAll(videoDir, 0755); err != nil {
@@ -154,21 +262,21 @@ func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, pro
 		return fmt.Errorf("error creating audio directory: %v", err)
 	}
 
-	videoSegmentURLs := m3u8Info.VideoSegmentURLs
-	audioSegmentURLs := m3u8Info.AudioSegmentURLs
+	videoSegmentURLs := segmentURLs(m3u8Info.VideoSegments)
+	audioSegmentURLs := segmentURLs(m3u8Info.AudioSegments)
 
-	totalVideoSize, _, err := calculateTotalSize(ctx, videoSegmentURLs, j.Connections, client)
+	totalVideoSize, _, err := calculateTotalSize(ctx, m3u8Info.VideoSegments, j.Connections, client)
 	if err != nil {
 		totalVideoSize = int64(len(videoSegmentURLs)) * 1024 * 1024
 	}
-	totalAudioSize, _, err := calculateTotalSize(ctx, audioSegmentURLs, j.Connections, client)
+	totalAudioSize, _, err := calculateTotalSize(ctx, m3u8Info.AudioSegments, j.Connections, client)
 	if err != nil {
 		totalAudioSize = int64(len(audioSegmentURLs)) * 512 * 1024
 	}
 	totalSize := totalVideoSize + totalAudioSize
 
-	isVideoFMP4 := detectFMP4Format(j.URL, videoSegmentURLs)
-	isAudioFMP4 := detectFMP4Format(j.URL, audioSegmentURLs)
+	isVideoFMP4 := m3u8Info.Fragmented || detectFMP4Format(j.ManifestURL, videoSegmentURLs)
+	isAudioFMP4 := m3u8Info.Fragmented || detectFMP4Format(j.ManifestURL, audioSegmentURLs)
 
 	var totalDownloaded int64
 	wrappedProgressFunc := func(incrementalDownloaded, _ int64) {
@@ -180,9 +288,37 @@ func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, pro
 		}
 	}
 
-	videoFiles, videoErr := downloadSegmentsParallel(ctx, videoSegmentURLs, videoDir, j.Connections, client, wrappedProgressFunc, totalVideoSize, isVideoFMP4)
-	audioFiles, audioErr := downloadSegmentsParallel(ctx, audioSegmentURLs, audioDir, j.Connections, client, wrappedProgressFunc, totalAudioSize, isAudioFMP4)
+	videoFiles, videoGaps, videoErr := downloadSegmentsParallel(ctx, m3u8Info.VideoSegments, videoDir, j.Connections, keys, client, wrappedProgressFunc, totalVideoSize, isVideoFMP4, j.Options.MaxMissing)
+	audioFiles, audioGaps, audioErr := downloadSegmentsParallel(ctx, m3u8Info.AudioSegments, audioDir, j.Connections, keys, client, wrappedProgressFunc, totalAudioSize, isAudioFMP4, j.Options.MaxMissing)
+	if videoErr == nil {
+		j.noteGaps("video", videoGaps)
+	}
+	if audioErr == nil {
+		j.noteGaps("audio", audioGaps)
+	}
+	videoSegments, videoFiles := withoutGaps(m3u8Info.VideoSegments, videoFiles)
+	audioSegments, audioFiles := withoutGaps(m3u8Info.AudioSegments, audioFiles)
+
+	video := track{segments: videoSegments, files: videoFiles, init: m3u8Info.VideoInit, isFMP4: isVideoFMP4, dir: videoDir, err: videoErr}
+	audio := track{segments: audioSegments, files: audioFiles, init: m3u8Info.AudioInit, isFMP4: isAudioFMP4, dir: audioDir, err: audioErr}
+	return j.mergeSeparateStreams(ctx, video, audio, tempDir, keys, client)
+}
+
+// track is a downloaded video or audio rendition waiting to be merged.
+type track struct {
+	segments []Segment
+	files    []string
+	init     *Segment
+	isFMP4   bool
+	dir      string
+	err      error
+	gaps     []segmentGap // set by recordPlaylist, whose files keep "" for them
+}
 
+// mergeSeparateStreams merges each track and muxes them together, keeping
+// whichever track survived when the other failed.
+func (j *LiveStreamJob) mergeSeparateStreams(ctx context.Context, video, audio track, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient) error {
+	videoErr, audioErr := video.err, audio.err
 	if videoErr != nil && audioErr != nil {
 		return fmt.Errorf("both video and audio downloads failed - video: %v, audio: %v", videoErr, audioErr)
 	}
@@ -193,14 +329,15 @@ func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, pro
 	var finalErr error
 
 	if videoErr == nil {
-		if err := mergeSegments(ctx, videoFiles, tempVideoPath, isVideoFMP4, m3u8Info.VideoInitSegment, videoDir, client); err != nil {
+		if err := mergeSegments(ctx, video.segments, video.files, tempVideoPath, video.isFMP4, video.init, video.dir, keys, client, j.ffmpeg); err != nil {
 			return fmt.Errorf("error merging video segments: %v", err)
 		}
 	}
 
 	if audioErr == nil {
-		if err := mergeSegments(ctx, audioFiles, tempAudioPath, isAudioFMP4, m3u8Info.AudioInitSegment, audioDir, client); err != nil {
+		if err := mergeSegments(ctx, audio.segments, audio.files, tempAudioPath, audio.isFMP4, audio.init, audio.dir, keys, client, j.ffmpeg); err != nil {
 			if videoErr == nil {
+				j.useNativeExtension(video.isFMP4)
 				if err := os.Rename(tempVideoPath, j.OutputPath); err != nil {
 					return fmt.Errorf("error saving video-only output: %v", err)
 				}
@@ -210,16 +347,28 @@ func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, pro
 		}
 	}
 
-	if videoErr == nil && audioErr == nil {
-		if err := mergeVideoAndAudio(tempVideoPath, tempAudioPath, j.OutputPath); err != nil {
+	if videoErr == nil && audioErr == nil && j.ffmpeg == "" {
+		// without ffmpeg the audio can't be muxed in and is kept next to the video
+		j.useNativeExtension(video.isFMP4)
+		if err := os.Rename(tempVideoPath, j.OutputPath); err != nil {
+			return fmt.Errorf("error saving video output: %v", err)
+		}
+		audioPath := strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + ".audio" + nativeExtension(audio.isFMP4, ".m4a")
+		if err := os.Rename(tempAudioPath, audioPath); err != nil {
+			return fmt.Errorf("error saving audio output: %v", err)
+		}
+	} else if videoErr == nil && audioErr == nil {
+		if err := mergeVideoAndAudio(tempVideoPath, tempAudioPath, j.OutputPath, j.ffmpeg); err != nil {
 			return fmt.Errorf("error merging video and audio: %v", err)
 		}
 	} else if videoErr == nil && audioErr != nil {
+		j.useNativeExtension(video.isFMP4)
 		if err := os.Rename(tempVideoPath, j.OutputPath); err != nil {
 			return fmt.Errorf("error saving video-only output: %v", err)
 		}
 		finalErr = fmt.Errorf("audio download failed: %v", audioErr)
 	} else if audioErr == nil && videoErr != nil {
+		j.useNativeExtension(audio.isFMP4)
 		if err := os.Rename(tempAudioPath, j.OutputPath); err != nil {
 			return fmt.Errorf("error saving audio-only output: %v", err)
 		}
@@ -229,15 +378,237 @@ func (j *LiveStreamJob) downloadAndMergeSeparateStreams(ctx context.Context, pro
 	return finalErr
 }
 
+// noteGaps adds a track's missing segments to the final report.
+func (j *LiveStreamJob) noteGaps(track string, gaps []segmentGap) {
+	for _, gap := range gaps {
+		j.gaps = append(j.gaps, strings.TrimSpace(track+" "+gap.String()))
+	}
+}
+
+var unsafeTitleChars = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]+`)
+
+// titleFileName turns an extracted video title into an output file name
+// without extension, or "" when nothing usable is left.
+func titleFileName(title string) string {
+	name := strings.Trim(unsafeTitleChars.ReplaceAllString(title, "_"), " ._")
+	if runes := []rune(name); len(runes) > 120 {
+		name = strings.TrimRight(string(runes[:120]), " ._")
+	}
+	return name
+}
+
+// tempDirName names the job's temp directory after its URL and output, so a
+// resumed job finds the segments it downloaded before.
+func tempDirName(url, outputPath string) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + outputPath))
+	return "m3u8_" + hex.EncodeToString(sum[:8])
+}
+
+// selectionKey identifies the variant and renditions a run picked. Segment
+// files are named by sequence only, so they are reused only while this stays
+// the same.
+func selectionKey(info *M3U8Info) string {
+	lines := []string{"variant " + info.VariantURL, "video " + info.VideoPlaylistURL, "audio " + info.AudioPlaylistURL}
+	if info.MainAudio != nil {
+		lines = append(lines, "main "+renditionKey(*info.MainAudio))
+	}
+	for _, t := range info.ExtraAudio {
+		lines = append(lines, "extra "+renditionKey(t.Rendition))
+	}
+	for _, t := range info.Subtitles {
+		lines = append(lines, "subtitles "+renditionKey(t.Rendition))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func renditionKey(r Rendition) string {
+	return strings.Join([]string{r.Type, r.GroupID, r.Language, r.Name, r.URL}, " ")
+}
+
+// selectionFile records a temp dir's selectionKey.
+const selectionFile = "selection"
+
+// claimTempDir creates the temp dir for a selection, first emptying it when an
+// earlier run left segments of another variant or rendition there.
+func claimTempDir(tempDir, selection string) error {
+	if recorded, err := os.ReadFile(filepath.Join(tempDir, selectionFile)); err != nil || string(recorded) != selection {
+		if err := os.RemoveAll(tempDir); err != nil {
+			return fmt.Errorf("error clearing temp directory: %v", err)
+		}
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("error creating temp directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, selectionFile), []byte(selection), 0644); err != nil {
+		return fmt.Errorf("error writing temp directory selection: %v", err)
+	}
+	return nil
+}
+
+// useNativeExtension fixes the output extension when merging without
+// ffmpeg: segments are joined as they are, so MPEG-TS stays a .ts file and
+// fMP4 fragments make an .mp4.
+func (j *LiveStreamJob) useNativeExtension(isFMP4 bool) {
+	if j.ffmpeg != "" {
+		return
+	}
+	ext := filepath.Ext(j.OutputPath)
+	want := nativeExtension(isFMP4, ".mp4")
+	if strings.EqualFold(ext, want) || (isFMP4 && slices.Contains([]string{".m4a", ".m4v"}, strings.ToLower(ext))) {
+		return
+	}
+	path := strings.TrimSuffix(j.OutputPath, ext) + want
+	if _, err := os.Stat(path); err == nil && !j.continuing {
+		path = utils.RenewOutputPath(path)
+	}
+	j.OutputPath = path
+}
+
+// nativeExtension is the file extension of natively joined segments, with
+// fmp4Ext used for fragmented MP4.
+func nativeExtension(isFMP4 bool, fmp4Ext string) string {
+	if isFMP4 {
+		return fmp4Ext
+	}
+	return ".ts"
+}
+
+// record follows a live stream until it ends, the --duration limit is reached
+// or ctx is cancelled (Ctrl-C), then merges what was recorded. Merging is not
+// tied to ctx so an interrupted recording still produces a playable file.
+func (j *LiveStreamJob) record(ctx context.Context, progress chan<- highway.Progress, m3u8Info *M3U8Info, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient) error {
+	var downloaded, recorded atomic.Int64 // bytes, milliseconds of video
+	report := func() {
+		seconds := time.Duration(recorded.Load()) * time.Millisecond
+		size := utils.FormatBytes(uint64(downloaded.Load()))
+		if j.Options.Duration > 0 {
+			progress <- highway.Progress{
+				JobID: j.ID(), Type: highway.ProgressTypeProgress,
+				Message: "Recording", Current: int64(seconds.Seconds()), Total: int64(j.Options.Duration.Seconds()),
+				Extra: seconds.Round(time.Second).String() + "/" + j.Options.Duration.String() + " (" + size + ")",
+			}
+			return
+		}
+		progress <- highway.Progress{
+			JobID: j.ID(), Type: highway.ProgressTypeProgress,
+			Message: fmt.Sprintf("Recording %s (%s)", seconds.Round(time.Second), size),
+		}
+	}
+	opts := recordOptions{connections: j.Connections, limit: j.Options.Duration, maxMissing: j.Options.MaxMissing, keys: keys, client: client}
+	opts.onProgress = func(size int64, seconds float64) {
+		downloaded.Add(size)
+		recorded.Add(int64(seconds * 1000))
+		report()
+	}
+	report()
+
+	work := context.WithoutCancel(ctx)
+	if !m3u8Info.HasSeparateAudio {
+		video := recordPlaylist(ctx, m3u8Info.VideoPlaylistURL, tempDir, opts)
+		j.noteGaps("", video.gaps)
+		video.segments, video.files = withoutGaps(video.segments, video.files)
+		if len(video.files) == 0 {
+			return recordingError(video.err)
+		}
+		j.useNativeExtension(video.isFMP4)
+		if err := mergeSegments(work, video.segments, video.files, j.OutputPath, video.isFMP4, video.init, tempDir, keys, client, j.ffmpeg); err != nil {
+			return fmt.Errorf("error merging segments: %v", err)
+		}
+		return partialRecordingError(video.err)
+	}
+
+	videoDir := filepath.Join(tempDir, "video")
+	audioDir := filepath.Join(tempDir, "audio")
+	if err := os.MkdirAll(videoDir, 0755); err != nil {
+		return fmt.Errorf("error creating video directory: %v", err)
+	}
+	if err := os.MkdirAll(audioDir, 0755); err != nil {
+		return fmt.Errorf("error creating audio directory: %v", err)
+	}
+	audioOpts := opts
+	audioOpts.onProgress = func(size int64, _ float64) {
+		downloaded.Add(size)
+		report()
+	}
+	var audio track
+	audioDone := make(chan struct{})
+	go func() {
+		defer close(audioDone)
+		audio = recordPlaylist(ctx, m3u8Info.AudioPlaylistURL, audioDir, audioOpts)
+	}()
+	video := recordPlaylist(ctx, m3u8Info.VideoPlaylistURL, videoDir, opts)
+	<-audioDone
+	j.noteGaps("video", video.gaps)
+	j.noteGaps("audio", audio.gaps)
+
+	var partial []error
+	for _, t := range []*track{&video, &audio} {
+		t.segments, t.files = withoutGaps(t.segments, t.files)
+		if len(t.files) == 0 {
+			t.err = recordingError(t.err)
+		} else if t.err != nil {
+			partial = append(partial, t.err)
+			t.err = nil
+		}
+	}
+	if err := j.mergeSeparateStreams(work, video, audio, tempDir, keys, client); err != nil {
+		return err
+	}
+	return partialRecordingError(errors.Join(partial...))
+}
+
+// recordingError explains why a recording produced no segments at all.
+func recordingError(err error) error {
+	if err == nil {
+		return fmt.Errorf("recording stopped before any segment was downloaded")
+	}
+	return fmt.Errorf("error recording stream: %v", err)
+}
+
+// partialRecordingError reports a recording that ended early on an error; the
+// segments recorded until then have been merged.
+func partialRecordingError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("recording ended early, saved what was recorded: %v", err)
+}
+
 func (j *LiveStreamJob) Marshal() ([]byte, error) {
 	return json.Marshal(liveStreamJobState{
-		URL:         j.URL,
-		OutputPath:  j.OutputPath,
-		Connections: j.Connections,
-		Extractor:   j.Extractor,
-		ProxyURL:    j.HTTPConfig.ProxyURL,
-		UserAgent:   j.HTTPConfig.UserAgent,
-		Headers:     j.HTTPConfig.Headers,
+		URL:              j.URL,
+		ManifestURL:      j.ManifestURL,
+		ExtractorHeaders: utils.RedactHeaders(j.ExtractorHeaders),
+		OutputPath:       j.OutputPath,
+		TempDir:          j.TempDir,
+		Connections:      j.Connections,
+		Extractor:        j.Extractor,
+		Duration:         j.Options.Duration,
+		Quality:          j.Options.Quality,
+		Codec:            j.Options.Codec,
+		Audio:            j.Options.Audio,
+		Subtitles:        j.Options.Subtitles,
+		SubtitleFormat:   j.Options.SubtitleFormat,
+		FFmpeg:           j.Options.FFmpeg,
+		MaxMissing:       j.Options.MaxMissing,
+		Variant:          j.Options.Variant,
+		ProxyURL:         j.HTTPConfig.ProxyURL,
+		NoProxy:          j.HTTPConfig.NoProxy,
+		UserAgent:        j.HTTPConfig.UserAgent,
+		Headers:          utils.RedactHeaders(j.HTTPConfig.Headers),
+		CookiesFile:      j.HTTPConfig.CookiesFile,
+		NetrcFile:        j.HTTPConfig.NetrcFile,
+		CredentialsFile:  j.HTTPConfig.CredentialsFile,
+		CACertFile:       j.HTTPConfig.CACertFile,
+		CertFile:         j.HTTPConfig.CertFile,
+		KeyFile:          j.HTTPConfig.KeyFile,
+		Insecure:         j.HTTPConfig.Insecure,
+		MinTLSVersion:    j.HTTPConfig.MinTLSVersion,
+		Resolve:          j.HTTPConfig.Resolve,
+		IPVersion:        j.HTTPConfig.IPVersion,
+		Interface:        j.HTTPConfig.Interface,
+		LocalAddress:     j.HTTPConfig.LocalAddress,
+		HTTPVersion:      j.HTTPConfig.HTTPVersion,
 	})
 }
 
@@ -246,9 +617,40 @@ func Unmarshal(data []byte) (highway.Job, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
-	return New(state.URL, state.OutputPath, state.Connections, state.Extractor, utils.HTTPClientConfig{
-		ProxyURL:  state.ProxyURL,
-		UserAgent: state.UserAgent,
-		Headers:   state.Headers,
-	}), nil
+	job := New(state.URL, state.OutputPath, state.Connections, state.Extractor, Options{
+		Duration:       state.Duration,
+		Quality:        state.Quality,
+		Codec:          state.Codec,
+		Audio:          state.Audio,
+		Subtitles:      state.Subtitles,
+		SubtitleFormat: state.SubtitleFormat,
+		FFmpeg:         state.FFmpeg,
+		MaxMissing:     state.MaxMissing,
+		Variant:        state.Variant,
+	}, utils.HTTPClientConfig{
+		ProxyURL:        state.ProxyURL,
+		NoProxy:         state.NoProxy,
+		UserAgent:       state.UserAgent,
+		Headers:         state.Headers,
+		CookiesFile:     state.CookiesFile,
+		NetrcFile:       state.NetrcFile,
+		CredentialsFile: state.CredentialsFile,
+		CACertFile:      state.CACertFile,
+		CertFile:        state.CertFile,
+		KeyFile:         state.KeyFile,
+		Insecure:        state.Insecure,
+		MinTLSVersion:   state.MinTLSVersion,
+		Resolve:         state.Resolve,
+		IPVersion:       state.IPVersion,
+		Interface:       state.Interface,
+		LocalAddress:    state.LocalAddress,
+		HTTPVersion:     state.HTTPVersion,
+	})
+	job.ManifestURL = state.ManifestURL
+	job.TempDir = state.TempDir
+	job.ExtractorHeaders = state.ExtractorHeaders
+	if err := utils.RestoreCertPassword(&job.HTTPConfig); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
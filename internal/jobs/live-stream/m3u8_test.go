@@ -1,13 +1,24 @@
 package m3u8
 
 import (
+	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/tanq16/danzo/internal/highway"
 	"github.com/tanq16/danzo/utils"
 )
 
@@ -20,7 +31,7 @@ segment-1.ts
 ../shared/segment-2.ts
 `
 
-	info, err := parseM3U8Content(context.Background(), content, "https://cdn.example.com/video/playlist.m3u8", utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}))
+	info, err := parseM3U8Content(context.Background(), content, "https://cdn.example.com/video/playlist.m3u8", Options{}, utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}))
 	if err != nil {
 		t.Fatalf("parse m3u8: %v", err)
 	}
@@ -29,11 +40,11 @@ segment-1.ts
 		"https://cdn.example.com/video/segment-1.ts",
 		"https://cdn.example.com/shared/segment-2.ts",
 	}
-	if !reflect.DeepEqual(info.VideoSegmentURLs, wantSegments) {
-		t.Fatalf("expected segments %#v, got %#v", wantSegments, info.VideoSegmentURLs)
+	if !reflect.DeepEqual(segmentURLs(info.VideoSegments), wantSegments) {
+		t.Fatalf("expected segments %#v, got %#v", wantSegments, segmentURLs(info.VideoSegments))
 	}
-	if info.VideoInitSegment != "https://cdn.example.com/video/init.mp4" {
-		t.Fatalf("expected init segment URL, got %q", info.VideoInitSegment)
+	if info.VideoInit == nil || info.VideoInit.URL != "https://cdn.example.com/video/init.mp4" {
+		t.Fatalf("expected init segment URL, got %+v", info.VideoInit)
 	}
 	if info.HasSeparateAudio {
 		t.Fatalf("media playlist should not report separate audio")
@@ -74,7 +85,7 @@ audio-high-1.m4s
 	if err != nil {
 		t.Fatalf("get master: %v", err)
 	}
-	info, err := parseM3U8Content(context.Background(), master, server.URL+"/master.m3u8", utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}))
+	info, err := parseM3U8Content(context.Background(), master, server.URL+"/master.m3u8", Options{}, utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}))
 	if err != nil {
 		t.Fatalf("parse master: %v", err)
 	}
@@ -82,25 +93,859 @@ audio-high-1.m4s
 	if !info.HasSeparateAudio {
 		t.Fatalf("expected separate audio from master playlist")
 	}
-	if want := []string{server.URL + "/video/video-high-1.m4s"}; !reflect.DeepEqual(info.VideoSegmentURLs, want) {
-		t.Fatalf("expected high bandwidth video segments %#v, got %#v", want, info.VideoSegmentURLs)
+	if want := []string{server.URL + "/video/video-high-1.m4s"}; !reflect.DeepEqual(segmentURLs(info.VideoSegments), want) {
+		t.Fatalf("expected high bandwidth video segments %#v, got %#v", want, segmentURLs(info.VideoSegments))
 	}
-	if want := []string{server.URL + "/audio/audio-high-1.m4s"}; !reflect.DeepEqual(info.AudioSegmentURLs, want) {
-		t.Fatalf("expected high quality audio segments %#v, got %#v", want, info.AudioSegmentURLs)
+	if want := []string{server.URL + "/audio/audio-high-1.m4s"}; !reflect.DeepEqual(segmentURLs(info.AudioSegments), want) {
+		t.Fatalf("expected high quality audio segments %#v, got %#v", want, segmentURLs(info.AudioSegments))
 	}
-	if info.VideoInitSegment != server.URL+"/video/init-video.mp4" {
-		t.Fatalf("expected video init segment, got %q", info.VideoInitSegment)
+	if info.VideoInit == nil || info.VideoInit.URL != server.URL+"/video/init-video.mp4" {
+		t.Fatalf("expected video init segment, got %+v", info.VideoInit)
 	}
-	if info.AudioInitSegment != server.URL+"/audio/init-audio.mp4" {
-		t.Fatalf("expected audio init segment, got %q", info.AudioInitSegment)
+	if info.AudioInit == nil || info.AudioInit.URL != server.URL+"/audio/init-audio.mp4" {
+		t.Fatalf("expected audio init segment, got %+v", info.AudioInit)
 	}
 }
 
+func TestSelectVariantAndAudioHonorQualityCodecAndLanguage(t *testing.T) {
+	master, err := parseMasterPlaylist(context.Background(), `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en-US",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="ec3",NAME="English 5.1",LANGUAGE="en",URI="audio/en-51.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2",AUDIO="aac"
+360.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2",FRAME-RATE=29.97,AUDIO="aac"
+720.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1800000,RESOLUTION=1280x720,CODECS="hvc1.1.6.L93.B0,ec-3",AUDIO="ec3"
+720-hevc.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2",AUDIO="aac"
+1080.m3u8
+`, "https://cdn.example.com/master.m3u8")
+	if err != nil {
+		t.Fatalf("parse master: %v", err)
+	}
+	if v := master.Variants[1]; v.Height != 720 || v.FrameRate != 29.97 || v.Codecs != "avc1.4d401f,mp4a.40.2" || v.URL != "https://cdn.example.com/720.m3u8" {
+		t.Fatalf("unexpected variant %+v", v)
+	}
+
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "1080.m3u8"},
+		{Options{Quality: "worst"}, "360.m3u8"},
+		{Options{Quality: "720p"}, "720.m3u8"},
+		{Options{Quality: "900p"}, "720.m3u8"},
+		{Options{Quality: "240p"}, "360.m3u8"},
+		{Options{Quality: "2000k"}, "720-hevc.m3u8"},
+		{Options{Quality: "720p", Codec: "hevc"}, "720-hevc.m3u8"},
+		{Options{Codec: "av1"}, "1080.m3u8"},
+	}
+	for _, tt := range tests {
+		got, err := selectVariant(master.Variants, tt.opts)
+		if err != nil || !strings.HasSuffix(got.URL, "/"+tt.want) {
+			t.Errorf("%+v: expected %s, got %s (%v)", tt.opts, tt.want, got.URL, err)
+		}
+	}
+	if err := ValidateQuality("fast"); err == nil {
+		t.Errorf("expected an invalid quality error")
+	}
+
+	hd := master.Variants[3]
+	if audio, err := selectAudio(master.Renditions, hd, Options{}); err != nil || len(audio) != 1 || audio[0].Name != "English" {
+		t.Errorf("expected the default rendition of the variant's group, got %+v (%v)", audio, err)
+	}
+	if audio, err := selectAudio(master.Renditions, hd, Options{Audio: []string{"de", "en"}}); err != nil || len(audio) != 2 || audio[0].Name != "Deutsch" || audio[1].Name != "English" {
+		t.Errorf("expected the German then the English rendition, got %+v (%v)", audio, err)
+	}
+	if audio, err := selectAudio(master.Renditions, master.Variants[2], Options{Audio: []string{"en"}}); err != nil || len(audio) != 1 || audio[0].Name != "English 5.1" {
+		t.Errorf("expected the rendition from the HEVC variant's group, got %+v (%v)", audio, err)
+	}
+	if _, err := selectAudio(master.Renditions, hd, Options{Audio: []string{"fr"}}); err == nil || !strings.Contains(err.Error(), "en-US, de") {
+		t.Errorf("expected an error listing the available languages, got %v", err)
+	}
+}
+
+func TestExtraAudioAndSubtitleRenditionsAreResolvedAndSaved(t *testing.T) {
+	files := map[string]string{
+		"/master.m3u8": `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="English",LANGUAGE="en",DEFAULT=YES,URI="en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="Français",LANGUAGE="fr",URI="fr.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",URI="subs-en.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Deutsch",LANGUAGE="de",URI="subs-de.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO="aud",SUBTITLES="subs"
+video.m3u8
+`,
+		"/video.m3u8":   "#EXTM3U\n#EXTINF:4,\nv1.ts\n#EXT-X-ENDLIST\n",
+		"/en.m3u8":      "#EXTM3U\n#EXTINF:4,\nen1.aac\n#EXT-X-ENDLIST\n",
+		"/fr.m3u8":      "#EXTM3U\n#EXTINF:4,\nfr1.aac\n#EXT-X-ENDLIST\n",
+		"/subs-en.m3u8": "#EXTM3U\n#EXTINF:4,\ns1.vtt\n#EXTINF:4,\ns2.vtt\n#EXT-X-ENDLIST\n",
+		// cue times are local to each segment's X-TIMESTAMP-MAP; the second
+		// segment repeats the cue spanning the boundary
+		"/s1.vtt": "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n1\n00:00:01.000 --> 00:00:02.500 align:start\n<v Bob>Hello</v>\n\n00:00:03.000 --> 00:00:05.000\n<i>across</i>\n",
+		"/s2.vtt": "WEBVTT\r\nX-TIMESTAMP-MAP=LOCAL:00:00:04.000,MPEGTS:1260000\r\n\r\n00:00:03.000 --> 00:00:05.000\r\n<i>across</i>\r\n\r\nNOTE a comment\r\n\r\n00:06.000 --> 00:07.000\r\nBye\r\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+	opts := Options{Audio: []string{"en", "fr"}, Subtitles: []string{"en"}}
+	info, err := parseM3U8Content(context.Background(), files["/master.m3u8"], server.URL+"/master.m3u8", opts, client)
+	if err != nil {
+		t.Fatalf("parse master: %v", err)
+	}
+	if info.MainAudio == nil || info.MainAudio.Language != "en" || segmentURLs(info.AudioSegments)[0] != server.URL+"/en1.aac" {
+		t.Fatalf("expected English as the main audio, got %+v", info.MainAudio)
+	}
+	if len(info.ExtraAudio) != 1 || info.ExtraAudio[0].Rendition.Language != "fr" || info.ExtraAudio[0].Segments[0].URL != server.URL+"/fr1.aac" {
+		t.Fatalf("expected French as an extra track, got %+v", info.ExtraAudio)
+	}
+	if len(info.Subtitles) != 1 || len(info.Subtitles[0].Segments) != 2 {
+		t.Fatalf("expected the English subtitles, got %+v", info.Subtitles)
+	}
+
+	dir := t.TempDir()
+	used := map[string]bool{}
+	path := sidecarPath(dir+"/video.mp4", info.Subtitles[0].Rendition, "srt", used)
+	if path != dir+"/video.en.srt" {
+		t.Fatalf("unexpected subtitle path %s", path)
+	}
+	if err := saveSubtitles(context.Background(), info.Subtitles[0], t.TempDir(), path, "srt", 2, newKeyStore(client), client); err != nil {
+		t.Fatalf("save subtitles: %v", err)
+	}
+	got, _ := os.ReadFile(path)
+	want := "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:00:03,000 --> 00:00:05,000\n<i>across</i>\n\n3\n00:00:06,000 --> 00:00:07,000\nBye\n\n"
+	if string(got) != want {
+		t.Fatalf("unexpected SRT output:\n%s", got)
+	}
+	if again := sidecarPath(dir+"/video.mp4", info.Subtitles[0].Rendition, "srt", used); again != dir+"/video.en.English.srt" {
+		t.Fatalf("expected a distinct name for a second English track, got %s", again)
+	}
+}
+
+func TestByteRangeSegmentsAreFetchedWithRangedRequests(t *testing.T) {
+	content := `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="movie.mp4",BYTERANGE="4@0"
+#EXTINF:4.0,
+#EXT-X-BYTERANGE:5@4
+movie.mp4
+#EXTINF:4.0,
+#EXT-X-BYTERANGE:6
+movie.mp4
+#EXTINF:2.0,
+#EXT-X-BYTERANGE:3@20
+movie.mp4
+#EXT-X-ENDLIST
+`
+	info, err := parseM3U8Content(context.Background(), content, "https://cdn.example.com/vod/index.m3u8", Options{}, nil)
+	if err != nil {
+		t.Fatalf("parse playlist: %v", err)
+	}
+	if init := info.VideoInit; init == nil || init.Offset != 0 || init.Length != 4 {
+		t.Fatalf("unexpected init section %+v", init)
+	}
+	var ranges [][2]int64
+	for _, seg := range info.VideoSegments {
+		ranges = append(ranges, [2]int64{seg.Offset, seg.Length})
+	}
+	if want := [][2]int64{{4, 5}, {9, 6}, {20, 3}}; !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("expected ranges %v, got %v", want, ranges)
+	}
+
+	movie := []byte("INITfirstsecondgapxxend")
+	for _, honorRange := range []bool{true, false} {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			if honorRange {
+				http.ServeContent(w, r, "movie.mp4", time.Time{}, bytes.NewReader(movie))
+				return
+			}
+			w.Write(movie)
+		}))
+		info, err := parseM3U8Content(context.Background(), content, server.URL+"/index.m3u8", Options{}, nil)
+		if err != nil {
+			t.Fatalf("parse playlist: %v", err)
+		}
+		client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+		dir := t.TempDir()
+		initPath := filepath.Join(dir, "init.mp4")
+		if _, err := downloadSegment(context.Background(), *info.VideoInit, initPath, client); err != nil {
+			t.Fatalf("download init (honorRange=%v): %v", honorRange, err)
+		}
+		files, _, err := downloadSegmentsParallel(context.Background(), info.VideoSegments, dir, 2, newKeyStore(client), client, nil, 0, true, 0)
+		server.Close()
+		if err != nil {
+			t.Fatalf("download ranges (honorRange=%v): %v", honorRange, err)
+		}
+		var got []string
+		files = append([]string{initPath}, files...)
+		for _, f := range files {
+			data, _ := os.ReadFile(f)
+			got = append(got, string(data))
+		}
+		if want := []string{"INIT", "first", "second", "end"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("honorRange=%v: expected %q, got %q", honorRange, want, got)
+		}
+		if requests.Load() != 4 {
+			t.Fatalf("expected one request per range, got %d", requests.Load())
+		}
+	}
+}
+
+func TestParseMPDResolvesTemplatesListsAndIndexedFilesAcrossPeriods(t *testing.T) {
+	// single.mp4: 10 bytes of init, a sidx box indexing two subsegments, then
+	// their 5 and 7 bytes of media
+	sidx := []byte{0, 0, 0, 56, 's', 'i', 'd', 'x', 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	sidx = append(sidx, 0, 0, 0, 5, 0, 0, 0x07, 0xd0, 0x90, 0, 0, 0)
+	sidx = append(sidx, 0, 0, 0, 7, 0, 0, 0x0b, 0xb8, 0x90, 0, 0, 0)
+	single := append(append([]byte("INITINIT.."), sidx...), []byte("firstsecond")...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dash/media/single.mp4" {
+			http.ServeContent(w, r, "single.mp4", time.Time{}, bytes.NewReader(single))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	manifest := `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT20S">
+  <BaseURL>media/</BaseURL>
+  <Period id="1" duration="PT10S">
+    <AdaptationSet contentType="video" mimeType="video/mp4">
+      <SegmentTemplate initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/seg-$Number%03d$.m4s" startNumber="5" timescale="1000" duration="4000"/>
+      <Representation id="v480" bandwidth="1000000" width="854" height="480" codecs="avc1.4d401e"/>
+      <Representation id="v1080" bandwidth="5000000" width="1920" height="1080" codecs="avc1.640028" frameRate="30000/1001"/>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" mimeType="audio/mp4" lang="en">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="main"/>
+      <Representation id="a1" bandwidth="128000">
+        <SegmentList timescale="1" duration="5">
+          <Initialization sourceURL="audio.mp4" range="0-99"/>
+          <SegmentURL media="audio.mp4" mediaRange="100-199"/>
+          <SegmentURL media="audio.mp4" mediaRange="200-299"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+  <Period id="2">
+    <AdaptationSet contentType="video" mimeType="video/mp4">
+      <SegmentTemplate initialization="p2/$RepresentationID$-init.mp4" media="p2/$RepresentationID$-$Time$.m4s" timescale="90">
+        <SegmentTimeline><S t="900" d="450" r="1"/><S d="90"/></SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="v1080" bandwidth="5000000" width="1920" height="1080"/>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" mimeType="audio/mp4" lang="en">
+      <Representation id="a2" bandwidth="128000">
+        <BaseURL>single.mp4</BaseURL>
+        <SegmentBase indexRange="10-65"/>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+	if !isDASHManifest(manifest) {
+		t.Fatalf("expected the MPD to be detected")
+	}
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+	info, err := parseManifest(context.Background(), manifest, server.URL+"/dash/manifest.mpd", Options{}, client)
+	if err != nil {
+		t.Fatalf("parse MPD: %v", err)
+	}
+	base := server.URL + "/dash/media/"
+	wantVideo := []string{
+		base + "v1080/seg-005.m4s", base + "v1080/seg-006.m4s", base + "v1080/seg-007.m4s",
+		base + "p2/v1080-900.m4s", base + "p2/v1080-1350.m4s", base + "p2/v1080-1800.m4s",
+	}
+	if !reflect.DeepEqual(segmentURLs(info.VideoSegments), wantVideo) {
+		t.Fatalf("expected video segments %#v, got %#v", wantVideo, segmentURLs(info.VideoSegments))
+	}
+	if info.VideoInit == nil || info.VideoInit.URL != base+"v1080/init.mp4" || !info.EndList || !info.Fragmented {
+		t.Fatalf("unexpected video init or flags: %+v", info)
+	}
+	if last := info.VideoSegments[5]; last.Sequence != 5 || last.Duration != 1 {
+		t.Fatalf("expected sequences renumbered across periods, got %+v", last)
+	}
+	if !info.HasSeparateAudio || info.MainAudio == nil || info.MainAudio.Language != "en" {
+		t.Fatalf("expected the English audio adaptation set, got %+v", info.MainAudio)
+	}
+	if init := info.AudioInit; init == nil || init.URL != base+"audio.mp4" || init.Offset != 0 || init.Length != 100 {
+		t.Fatalf("unexpected audio init %+v", init)
+	}
+	audio := info.AudioSegments
+	if len(audio) != 4 || audio[1].Offset != 200 || audio[1].Length != 100 || audio[1].Duration != 5 {
+		t.Fatalf("unexpected SegmentList segments %+v", audio)
+	}
+	if audio[2].URL != base+"single.mp4" || audio[2].Offset != 66 || audio[2].Length != 5 || audio[3].Offset != 71 || audio[3].Length != 7 || audio[3].Duration != 3 {
+		t.Fatalf("unexpected sidx segments %+v", audio[2:])
+	}
+	if v := info.VideoSegments; v[1].Discontinuity || v[1].Init != nil || !v[3].Discontinuity || v[3].Init == nil || v[3].Init.URL != base+"p2/v1080-init.mp4" {
+		t.Fatalf("expected the second period to start with a discontinuity and its own init, got %+v", v[3])
+	}
+	if init := audio[2].Init; !audio[2].Discontinuity || init == nil || init.URL != base+"single.mp4" || init.Offset != 0 || init.Length != 10 {
+		t.Fatalf("expected the second period's audio init, got %+v", init)
+	}
+	dir := t.TempDir()
+	err = mergeSegments(context.Background(), info.VideoSegments, make([]string, 6), filepath.Join(dir, "out.mp4"), true, info.VideoInit, dir, newKeyStore(client), client, "")
+	if err == nil || !strings.Contains(err.Error(), "ffmpeg") {
+		t.Fatalf("expected periods with different inits to need ffmpeg, got %v", err)
+	}
+	zero := strings.Replace(manifest, `<S d="90"/>`, `<S d="0" r="-1"/>`, 1)
+	if _, err := parseManifest(context.Background(), zero, server.URL+"/dash/manifest.mpd", Options{}, client); err == nil || !strings.Contains(err.Error(), "duration 0") {
+		t.Fatalf("expected a zero SegmentTimeline duration to be rejected, got %v", err)
+	}
+
+	files, _, err := downloadSegmentsParallel(context.Background(), audio[2:], t.TempDir(), 2, newKeyStore(client), client, nil, 0, true, 0)
+	if err != nil {
+		t.Fatalf("download ranges: %v", err)
+	}
+	for i, want := range []string{"first", "second"} {
+		if got, _ := os.ReadFile(files[i]); string(got) != want {
+			t.Errorf("range %d: expected %q, got %q", i, want, got)
+		}
+	}
+
+	small, err := parseManifest(context.Background(), manifest, server.URL+"/dash/manifest.mpd", Options{Quality: "480p"}, client)
+	if err != nil || small.VideoSegments[0].URL != base+"v480/seg-005.m4s" {
+		t.Fatalf("expected --quality to pick the 480p representation, got %v (%v)", small.VideoSegments[0].URL, err)
+	}
+}
+
+func TestEncryptedSegmentsAreDecryptedWithRotatingKeys(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16)
+	explicitIV := bytes.Repeat([]byte{0xab}, 16)
+	sequenceIV := func(seq byte) []byte { return append(make([]byte, 15), seq) }
+	encrypt := func(plain, key, iv []byte) []byte {
+		pad := aes.BlockSize - len(plain)%aes.BlockSize
+		padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		block, _ := aes.NewCipher(key)
+		out := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+		return out
+	}
+	files := map[string][]byte{
+		"/key1":   key1,
+		"/key2":   key2,
+		"/s7.ts":  encrypt([]byte("segment seven"), key1, sequenceIV(7)),
+		"/s8.ts":  encrypt([]byte("segment eight"), key1, sequenceIV(8)),
+		"/s9.ts":  encrypt([]byte("segment nine"), key2, explicitIV),
+		"/s10.ts": []byte("segment ten in the clear"),
+	}
+	var keyFetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/key") {
+			keyFetches.Add(1)
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	playlist := `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-KEY:METHOD=AES-128,URI="/key1"
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://drm",KEYFORMAT="com.apple.streamingkeydelivery"
+#EXTINF:4.0,
+s7.ts
+#EXTINF:4.0,
+s8.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/key2",IV=0xabababababababababababababababab
+#EXTINF:4.0,
+s9.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:2.5,
+s10.ts
+`
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+	info, err := parseM3U8Content(context.Background(), playlist, server.URL+"/live.m3u8", Options{}, client)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := info.VideoSegments[3]; got.Sequence != 10 || got.Duration != 2.5 || got.Key != nil {
+		t.Fatalf("unexpected last segment %+v", got)
+	}
+	segmentFiles, _, err := downloadSegmentsParallel(context.Background(), info.VideoSegments, t.TempDir(), 4, newKeyStore(client), client, nil, 0, false, 0)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	want := []string{"segment seven", "segment eight", "segment nine", "segment ten in the clear"}
+	for i, path := range segmentFiles {
+		if got, _ := os.ReadFile(path); string(got) != want[i] {
+			t.Errorf("segment %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+	if n := keyFetches.Load(); n != 2 {
+		t.Errorf("expected each key to be fetched once, got %d fetches", n)
+	}
+
+	drmOnly := "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"skd://drm\",KEYFORMAT=\"com.apple.streamingkeydelivery\"\n#EXTINF:4,\ns.ts\n"
+	if _, err := parseM3U8Content(context.Background(), drmOnly, server.URL+"/live.m3u8", Options{}, client); err == nil || !strings.Contains(err.Error(), "DRM") {
+		t.Fatalf("expected a DRM error, got %v", err)
+	}
+}
+
+func TestRecordPlaylistFollowsLivePlaylistUntilEndList(t *testing.T) {
+	// each reload slides the window forward by one segment; the fourth one ends
+	var reloads atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && (r.URL.Path == "/s3.ts" || r.URL.Path == "/s4.ts") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/live.m3u8" {
+			_, _ = w.Write([]byte("data" + strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/s"), ".ts")))
+			return
+		}
+		n := int(reloads.Add(1))
+		var b strings.Builder
+		fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-TARGETDURATION:0.01\n#EXT-X-MEDIA-SEQUENCE:%d\n", n)
+		for seq := n; seq < n+3; seq++ {
+			fmt.Fprintf(&b, "#EXTINF:2.0,\ns%d.ts\n", seq)
+		}
+		if n == 4 {
+			b.WriteString("#EXT-X-ENDLIST\n")
+		}
+		_, _ = w.Write([]byte(b.String()))
+	}))
+	defer server.Close()
+
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+	var recorded float64
+	opts := recordOptions{connections: 2, keys: newKeyStore(client), client: client, onProgress: func(size int64, seconds float64) {
+		if size == 0 {
+			recorded += seconds // batches are reported from the recording goroutine
+		}
+	}}
+	got := recordPlaylist(context.Background(), server.URL+"/live.m3u8", t.TempDir(), opts)
+	if got.err != nil {
+		t.Fatalf("record: %v", got.err)
+	}
+	if len(got.files) != 6 || got.segments[0].Sequence != 1 || got.segments[5].Sequence != 6 {
+		t.Fatalf("expected sequences 1-6 once each, got %+v", got.segments)
+	}
+	for i, path := range got.files {
+		if data, _ := os.ReadFile(path); string(data) != fmt.Sprintf("data%d", i+1) {
+			t.Errorf("segment %d: unexpected content %q", i+1, data)
+		}
+	}
+	if recorded != 12 {
+		t.Errorf("expected 12s recorded, got %v", recorded)
+	}
+
+	// s3 and s4 fail in different batches and are reported as one gap
+	reloads.Store(0)
+	failing.Store(true)
+	opts.maxMissing = 1
+	if got = recordPlaylist(context.Background(), server.URL+"/live.m3u8", t.TempDir(), opts); got.err == nil {
+		t.Fatalf("expected two missing segments to exceed --max-missing 1")
+	}
+	reloads.Store(0)
+	opts.maxMissing = 2
+	got = recordPlaylist(context.Background(), server.URL+"/live.m3u8", t.TempDir(), opts)
+	if got.err != nil || len(got.gaps) != 1 || got.gaps[0].String() != "segments 3-4 at 4s (4s): server returned status code 404" {
+		t.Fatalf("expected one gap for segments 3-4, got %v (%v)", got.gaps, got.err)
+	}
+	if kept, _ := withoutGaps(got.segments, got.files); len(kept) != 4 || !kept[2].Discontinuity {
+		t.Fatalf("expected the segment after the gap to be a discontinuity, got %+v", kept)
+	}
+	failing.Store(false)
+	opts.maxMissing = 0
+
+	reloads.Store(0)
+	opts.limit = 5 * time.Second
+	dir := t.TempDir()
+	got = recordPlaylist(context.Background(), server.URL+"/live.m3u8", dir, opts)
+	if len(got.segments) != 3 || reloads.Load() != 1 {
+		t.Fatalf("expected a 5s limit to stop after 3 segments and one reload, got %d segments and %d reloads", len(got.segments), reloads.Load())
+	}
+
+	// a resumed recording keeps what the interrupted one saved in dir
+	reloads.Store(0)
+	opts.limit = 0
+	got = recordPlaylist(context.Background(), server.URL+"/live.m3u8", dir, opts)
+	if got.err != nil || len(got.files) != 6 || got.segments[0].Sequence != 1 || got.segments[5].Sequence != 6 {
+		t.Fatalf("expected the earlier segments 1-3 followed by 4-6, got %+v (%v)", got.segments, got.err)
+	}
+	if got.segments[2].Discontinuity || !got.segments[3].Discontinuity {
+		t.Fatalf("expected a discontinuity where the recording resumed, got %+v", got.segments)
+	}
+	for i, path := range got.files {
+		if data, _ := os.ReadFile(path); string(data) != fmt.Sprintf("data%d", i+1) {
+			t.Errorf("resumed segment %d: unexpected content %q", i+1, data)
+		}
+	}
+	reloads.Store(0)
+	got = recordPlaylist(context.Background(), server.URL+"/live.m3u8", dir, opts)
+	if got.err == nil || !strings.Contains(got.err.Error(), "restarted") || len(got.files) != 6 {
+		t.Fatalf("expected a restarted stream to stop with the earlier segments kept, got %d files (%v)", len(got.files), got.err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got = recordPlaylist(ctx, server.URL+"/live.m3u8", t.TempDir(), recordOptions{client: client}); len(got.files) != 0 || got.err != nil {
+		t.Fatalf("expected a cancelled recording to stop quietly, got %+v", got)
+	}
+}
+
+func TestResumedJobReusesTempDirVariantAndFinishedSegments(t *testing.T) {
+	var requests sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := requests.LoadOrStore(r.URL.Path, new(atomic.Int32))
+		if n.(*atomic.Int32).Add(1) == 1 && r.URL.Path == "/seg0.ts" {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("data" + r.URL.Path))
+	}))
+	defer server.Close()
+	requestsFor := func(path string) int32 {
+		if n, ok := requests.Load(path); ok {
+			return n.(*atomic.Int32).Load()
+		}
+		return 0
+	}
+
+	if tempDirName("https://a/x.m3u8", "out.mp4") != tempDirName("https://a/x.m3u8", "out.mp4") ||
+		tempDirName("https://a/x.m3u8", "out.mp4") == tempDirName("https://a/x.m3u8", "other.mp4") {
+		t.Fatalf("expected the temp dir to depend only on URL and output")
+	}
+
+	// segment 1 finished before the interruption, segment 2 was cut short
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "segment_0001.ts"), []byte("kept"), 0644)
+	os.WriteFile(filepath.Join(dir, "segment_0002.ts.part"), []byte("da"), 0644)
+	var segments []Segment
+	for i := range 3 {
+		segments = append(segments, Segment{URL: fmt.Sprintf("%s/seg%d.ts", server.URL, i), Sequence: int64(i)})
+	}
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+	var reported atomic.Int64
+	files, _, err := downloadSegmentsParallel(context.Background(), segments, dir, 3, newKeyStore(client), client, func(n, _ int64) { reported.Add(n) }, 0, false, 0)
+	if err != nil {
+		t.Fatalf("download segments: %v", err)
+	}
+	var got []string
+	for _, f := range files {
+		data, _ := os.ReadFile(f)
+		got = append(got, string(data))
+	}
+	if want := []string{"data/seg0.ts", "kept", "data/seg2.ts"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if requestsFor("/seg0.ts") != 2 || requestsFor("/seg1.ts") != 0 || requestsFor("/seg2.ts") != 1 {
+		t.Fatalf("unexpected requests: seg0=%d seg1=%d seg2=%d", requestsFor("/seg0.ts"), requestsFor("/seg1.ts"), requestsFor("/seg2.ts"))
+	}
+	if reported.Load() != int64(len("data/seg0.ts")+len("kept")+len("data/seg2.ts")) {
+		t.Fatalf("expected finished segments to count towards progress, got %d bytes", reported.Load())
+	}
+
+	job := New("https://example.com/watch/1", "out.mp4", 4, "rumble", Options{Quality: "best"}, utils.HTTPClientConfig{})
+	job.ManifestURL = "https://cdn.example.com/master.m3u8"
+	job.Options.Variant = "https://cdn.example.com/720p.m3u8"
+	data, err := job.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	restored, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	resumed := restored.(*LiveStreamJob)
+	if resumed.URL != job.URL || resumed.ManifestURL != job.ManifestURL || resumed.Options.Variant != job.Options.Variant {
+		t.Fatalf("expected manifest and variant to survive a resume, got %+v", resumed)
+	}
+	variants := []Variant{
+		{URL: "https://cdn.example.com/1080p.m3u8", Bandwidth: 5000000},
+		{URL: "https://cdn.example.com/720p.m3u8", Bandwidth: 2500000},
+	}
+	if v, err := selectVariant(variants, resumed.Options); err != nil || v.URL != job.Options.Variant {
+		t.Fatalf("expected the earlier variant to be kept, got %+v (%v)", v, err)
+	}
+}
+
+func TestRerunAfterGapsReusesTempDirAndReplacesOutput(t *testing.T) {
+	t.Setenv("PATH", "")
+	var failing atomic.Bool
+	failing.Store(true)
+	var requests sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			n, _ := requests.LoadOrStore(r.URL.Path, new(atomic.Int32))
+			n.(*atomic.Int32).Add(1)
+		}
+		switch {
+		case r.URL.Path == "/media.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2,\nseg0.ts\n#EXTINF:2,\nseg1.ts\n#EXT-X-ENDLIST\n"))
+		case r.URL.Path == "/seg1.ts" && failing.Load():
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer server.Close()
+	run := func(job *LiveStreamJob) error {
+		progress := make(chan highway.Progress, 100)
+		go func() {
+			for range progress {
+			}
+		}()
+		defer close(progress)
+		return job.Run(context.Background(), progress)
+	}
+
+	dir := t.TempDir()
+	job := New(server.URL+"/media.m3u8", filepath.Join(dir, "out.mp4"), 2, "", Options{MaxMissing: 1}, utils.HTTPClientConfig{})
+	if err := run(job); err == nil || !strings.Contains(err.Error(), "missing segments") {
+		t.Fatalf("expected the missing segment to be reported, got %v", err)
+	}
+	// merged natively, the MPEG-TS segments were saved as out.ts
+	if job.OutputPath != filepath.Join(dir, "out.ts") {
+		t.Fatalf("unexpected output %s", job.OutputPath)
+	}
+	data, err := job.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	restored, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	resumed := restored.(*LiveStreamJob)
+	if resumed.TempDir == "" || resumed.TempDir != job.TempDir {
+		t.Fatalf("expected the resumed job to use temp dir %q, got %q", job.TempDir, resumed.TempDir)
+	}
+
+	failing.Store(false)
+	if err := run(resumed); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+	if resumed.OutputPath != job.OutputPath {
+		t.Fatalf("expected the output with gaps to be replaced, got %s", resumed.OutputPath)
+	}
+	if got, _ := os.ReadFile(resumed.OutputPath); string(got) != "/seg0.ts/seg1.ts" {
+		t.Fatalf("unexpected output %q", got)
+	}
+	if n, _ := requests.Load("/seg0.ts"); n.(*atomic.Int32).Load() != 1 {
+		t.Fatalf("expected the finished segment to be reused, got %d requests", n.(*atomic.Int32).Load())
+	}
+	if _, err := os.Stat(job.TempDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the temp dir to be removed, got %v", err)
+	}
+}
+
+func TestTempDirSegmentsAreDiscardedWhenTheSelectionChanges(t *testing.T) {
+	tempDir := filepath.Join(t.TempDir(), "m3u8_x")
+	segment := filepath.Join(tempDir, "segment_0001.ts")
+	hd := &M3U8Info{VariantURL: "https://cdn.example.com/1080p.m3u8", VideoPlaylistURL: "https://cdn.example.com/1080p.m3u8"}
+	sd := &M3U8Info{VariantURL: "https://cdn.example.com/480p.m3u8", VideoPlaylistURL: "https://cdn.example.com/480p.m3u8"}
+
+	if err := claimTempDir(tempDir, selectionKey(hd)); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	os.WriteFile(segment, []byte("1080p"), 0644)
+	if err := claimTempDir(tempDir, selectionKey(hd)); err != nil {
+		t.Fatalf("claim again: %v", err)
+	}
+	if _, err := os.Stat(segment); err != nil {
+		t.Fatalf("expected the segment to be kept for the same variant: %v", err)
+	}
+	if err := claimTempDir(tempDir, selectionKey(sd)); err != nil {
+		t.Fatalf("claim for another variant: %v", err)
+	}
+	if _, err := os.Stat(segment); !os.IsNotExist(err) {
+		t.Fatalf("expected the other variant's segment to be discarded, got %v", err)
+	}
+	withAudio := *sd
+	withAudio.MainAudio = &Rendition{Type: "AUDIO", Language: "de", URL: "https://cdn.example.com/de.m3u8"}
+	if selectionKey(&withAudio) == selectionKey(sd) {
+		t.Fatalf("expected --audio to change the selection")
+	}
+}
+
+func TestFailedSegmentsAreRetriedAndToleratedAsGaps(t *testing.T) {
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	var requests sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := requests.LoadOrStore(r.URL.Path, new(atomic.Int32))
+		attempt := n.(*atomic.Int32).Add(1)
+		switch {
+		case r.URL.Path == "/seg12.ts" || r.URL.Path == "/seg14.ts" || r.URL.Path == "/seg15.ts":
+			http.NotFound(w, r)
+		case r.URL.Path == "/seg13.ts" && attempt < 3:
+			http.Error(w, "overloaded", http.StatusInternalServerError)
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer server.Close()
+	requestsFor := func(path string) int32 {
+		n, _ := requests.Load(path)
+		return n.(*atomic.Int32).Load()
+	}
+	var segments []Segment
+	for seq := int64(10); seq < 17; seq++ {
+		segments = append(segments, Segment{URL: fmt.Sprintf("%s/seg%d.ts", server.URL, seq), Sequence: seq, Duration: 2})
+	}
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+
+	if _, _, err := downloadSegmentsParallel(context.Background(), segments, t.TempDir(), 2, newKeyStore(client), client, nil, 0, false, 2); err == nil {
+		t.Fatalf("expected three missing segments to exceed --max-missing 2")
+	}
+
+	requests.Clear()
+	files, gaps, err := downloadSegmentsParallel(context.Background(), segments, t.TempDir(), 2, newKeyStore(client), client, nil, 0, false, 3)
+	if err != nil {
+		t.Fatalf("download segments: %v", err)
+	}
+	if requestsFor("/seg12.ts") != 1 || requestsFor("/seg13.ts") != 3 {
+		t.Fatalf("expected 404s to fail at once and 500s to be retried, got %d and %d requests", requestsFor("/seg12.ts"), requestsFor("/seg13.ts"))
+	}
+	var report []string
+	for _, gap := range gaps {
+		report = append(report, gap.String())
+	}
+	want := []string{
+		"segment 12 at 4s (2s): server returned status code 404",
+		"segments 14-15 at 8s (4s): server returned status code 404",
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Fatalf("expected gaps %q, got %q", want, report)
+	}
+
+	kept, keptFiles := withoutGaps(segments, files)
+	var discontinuities []int64
+	for i, seg := range kept {
+		if seg.Discontinuity {
+			discontinuities = append(discontinuities, seg.Sequence)
+		}
+		if data, _ := os.ReadFile(keptFiles[i]); string(data) != fmt.Sprintf("/seg%d.ts", seg.Sequence) {
+			t.Fatalf("segment %d has the wrong file %s", seg.Sequence, keptFiles[i])
+		}
+	}
+	if len(kept) != 4 || !reflect.DeepEqual(discontinuities, []int64{13, 16}) {
+		t.Fatalf("expected the segments after each gap to be discontinuities, got %+v", kept)
+	}
+}
+
+func TestNativeTSMergeContinuesTimestampsAcrossDiscontinuities(t *testing.T) {
+	content := `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXTINF:2.0,
+a.ts
+#EXTINF:2.0,
+b.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.0,
+ad.ts
+#EXTINF:2.0,
+c.ts
+#EXT-X-ENDLIST
+`
+	info, err := parseM3U8Content(context.Background(), content, "https://cdn.example.com/index.m3u8", Options{}, nil)
+	if err != nil {
+		t.Fatalf("parse playlist: %v", err)
+	}
+	segments := info.VideoSegments
+	if len(segments) != 4 || segments[1].Discontinuity || !segments[2].Discontinuity || segments[3].Discontinuity {
+		t.Fatalf("expected only the third segment to follow a discontinuity, got %+v", segments)
+	}
+
+	// the spliced-in part restarts its clock and continuity counter
+	dir := t.TempDir()
+	var files []string
+	for i, packets := range [][]int64{{900000, 990000}, {1080000}, {5000}, {185000}} {
+		var data []byte
+		for n, ts := range packets {
+			data = append(data, tsTestPacket(0x100, byte(i*3+n+5), ts)...)
+		}
+		files = append(files, filepath.Join(dir, fmt.Sprintf("segment_%d.ts", i)))
+		if err := os.WriteFile(files[i], data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(dir, "out.ts")
+	if err := mergeSegments(context.Background(), segments, files, output, false, nil, dir, nil, nil, ""); err != nil {
+		t.Fatalf("native merge: %v", err)
+	}
+	merged, err := os.ReadFile(output)
+	if err != nil || len(merged) != 5*tsPacketSize {
+		t.Fatalf("expected 5 packets, got %d bytes (%v)", len(merged), err)
+	}
+	wantTimestamps := []int64{900000, 990000, 1080000, 1260000, 1440000}
+	for i, want := range wantTimestamps {
+		pkt := merged[i*tsPacketSize : (i+1)*tsPacketSize]
+		pes := pkt[12:]
+		if pcr, pts, dts := readPCRBase(pkt[6:12]), readTimestamp(pes[9:14]), readTimestamp(pes[14:19]); pcr != want || pts != want || dts != want {
+			t.Errorf("packet %d: expected timestamps %d, got PCR %d PTS %d DTS %d", i, want, pcr, pts, dts)
+		}
+		if cc := pkt[3] & 0xf; cc != byte(i+5) {
+			t.Errorf("packet %d: expected continuity counter %d, got %d", i, i+5, cc)
+		}
+	}
+}
+
+func TestNativeFMP4MergeJoinsInitAndFragments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ftyp+moov"))
+	}))
+	defer server.Close()
+	client := utils.NewDanzoHTTPClient(utils.HTTPClientConfig{})
+	dir := t.TempDir()
+	var files []string
+	for i, fragment := range []string{"moof1mdat1", "moof2mdat2"} {
+		files = append(files, filepath.Join(dir, fmt.Sprintf("segment_%d.m4s", i)))
+		if err := os.WriteFile(files[i], []byte(fragment), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(dir, "out.mp4")
+	init := &Segment{URL: server.URL + "/init.mp4"}
+	if err := mergeSegments(context.Background(), []Segment{{}, {}}, files, output, true, init, dir, newKeyStore(client), client, ""); err != nil {
+		t.Fatalf("native merge: %v", err)
+	}
+	if got, _ := os.ReadFile(output); string(got) != "ftyp+moovmoof1mdat1moof2mdat2" {
+		t.Fatalf("unexpected merged output %q", got)
+	}
+
+	sampleAES := []Segment{{Key: &SegmentKey{Method: "SAMPLE-AES"}}}
+	if err := mergeSegments(context.Background(), sampleAES, files[:1], output, true, nil, dir, nil, nil, ""); err == nil {
+		t.Fatalf("expected SAMPLE-AES to require ffmpeg")
+	}
+}
+
+// tsTestPacket builds an MPEG-TS packet starting a PES on pid, with its PCR,
+// PTS and DTS all set to ts.
+func tsTestPacket(pid uint16, cc byte, ts int64) []byte {
+	pkt := make([]byte, tsPacketSize)
+	pkt[0], pkt[1], pkt[2], pkt[3] = tsSyncByte, 0x40|byte(pid>>8), byte(pid), 0x30|cc&0xf
+	pkt[4], pkt[5] = 7, 0x10 // adaptation field holding a PCR
+	writePCRBase(pkt[6:12], ts)
+	pes := pkt[12:]
+	copy(pes, []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0xc0, 10, 0x30, 0, 0, 0, 0, 0x10})
+	writeTimestamp(pes[9:14], ts)
+	writeTimestamp(pes[14:19], ts)
+	return pkt
+}
+
 func TestParseM3U8ContentHonorsCanceledContext(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	_, err := parseM3U8Content(ctx, "#EXTM3U\nsegment.ts\n", "https://cdn.example.com/playlist.m3u8", utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}))
+	_, err := parseM3U8Content(ctx, "#EXTM3U\nsegment.ts\n", "https://cdn.example.com/playlist.m3u8", Options{}, utils.NewDanzoHTTPClient(utils.HTTPClientConfig{}))
 	if !errors.Is(err, context.Canceled) {
 		t.Fatalf("expected context canceled, got %v", err)
 	}
@@ -135,3 +980,92 @@ func TestGetDailymotionVideoIDRejectsUnknownURLs(t *testing.T) {
 		t.Fatalf("expected extraction error, got %q", got)
 	}
 }
+
+type testExtractor struct {
+	host      string
+	manifests []string
+}
+
+func (e testExtractor) Match(pageURL string) bool {
+	return matchesHost(pageURL, e.host)
+}
+
+func (e testExtractor) Extract(ctx context.Context, pageURL string, client *utils.DanzoHTTPClient) (*Extraction, error) {
+	return &Extraction{Manifests: e.manifests, Headers: map[string]string{"Referer": "https://video.test/"}, Title: "Episode 1: Pilot"}, nil
+}
+
+func TestRegisteredExtractorIsMatchedByURLAndItsHeadersReachSegments(t *testing.T) {
+	var unreferred atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") != "https://video.test/" {
+			unreferred.Add(1)
+			http.Error(w, "hotlinking", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nmedia.m3u8\n"))
+		case "/media.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\nseg0.ts\n#EXTINF:4,\nseg1.ts\n#EXT-X-ENDLIST\n"))
+		case "/seg0.ts", "/seg1.ts":
+			w.Write([]byte("data" + r.URL.Path))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	saved := extractors
+	t.Cleanup(func() { extractors = saved })
+	// the first manifest is gone, so the next one is used
+	RegisterExtractor("video-test", testExtractor{host: "video.test", manifests: []string{server.URL + "/gone.m3u8", server.URL + "/master.m3u8"}})
+
+	if got := MatchExtractor("https://www.video.test/watch/1"); got != "video-test" {
+		t.Fatalf("expected the page to match video-test, got %q", got)
+	}
+	if got := MatchExtractor("https://notvideo.test/watch/1"); got != "" {
+		t.Fatalf("expected no match for another host, got %q", got)
+	}
+	if _, err := findExtractor("nope", "https://video.test/watch/1"); err == nil || !strings.Contains(err.Error(), "video-test") {
+		t.Fatalf("expected an unknown extractor error listing the available ones, got %v", err)
+	}
+
+	master, err := ListVariants(context.Background(), "https://video.test/watch/1", "", utils.HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("list variants: %v", err)
+	}
+	if len(master.Variants) != 1 || master.Variants[0].URL != server.URL+"/media.m3u8" {
+		t.Fatalf("unexpected variants: %+v", master.Variants)
+	}
+
+	t.Setenv("PATH", "") // join natively, whether or not ffmpeg is installed
+	output := filepath.Join(t.TempDir(), "out.ts")
+	job := New("https://video.test/watch/1", output, 2, "", Options{}, utils.HTTPClientConfig{})
+	progress := make(chan highway.Progress)
+	go func() {
+		for range progress {
+		}
+	}()
+	err = job.Run(context.Background(), progress)
+	close(progress)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "data/seg0.tsdata/seg1.ts" {
+		t.Fatalf("unexpected output %q", data)
+	}
+	if unreferred.Load() != 0 {
+		t.Fatalf("expected every request to carry the extractor's Referer, %d did not", unreferred.Load())
+	}
+	if job.ManifestURL != server.URL+"/master.m3u8" || job.ExtractorHeaders["Referer"] != "https://video.test/" {
+		t.Fatalf("expected the manifest and headers to be kept for a resume, got %q %v", job.ManifestURL, job.ExtractorHeaders)
+	}
+
+	cfg := withHeaders(utils.HTTPClientConfig{Headers: map[string]string{"Referer": "https://mine.test/"}}, job.ExtractorHeaders)
+	if cfg.Headers["Referer"] != "https://mine.test/" {
+		t.Fatalf("expected a configured header to win over the extractor's, got %q", cfg.Headers["Referer"])
+	}
+	if got := titleFileName(` Episode 1: Pilot / "Part" <2>. `); got != "Episode 1_ Pilot _ _Part_ _2" {
+		t.Fatalf("unexpected file name %q", got)
+	}
+}
@@ -0,0 +1,168 @@
+package m3u8
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+const (
+	tsPacketSize  = 188
+	tsSyncByte    = 0x47
+	timestampWrap = 1 << 33 // PTS, DTS and the PCR base are 33-bit 90 kHz clocks
+)
+
+// concatTS joins MPEG-TS segments without ffmpeg. Segments are copied as is
+// unless the playlist has an #EXT-X-DISCONTINUITY; then timestamps after each
+// one are shifted to continue where the previous part ended and continuity
+// counters keep running, so players don't stall or jump at the splice.
+func concatTS(segments []Segment, segmentFiles []string, outputPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %v", err)
+	}
+	defer out.Close()
+
+	rewrite := slices.ContainsFunc(segments, func(s Segment) bool { return s.Discontinuity })
+	r := tsRewriter{counters: map[uint16]byte{}}
+	for i, file := range segmentFiles {
+		if !rewrite {
+			if err := appendFile(out, file); err != nil {
+				return fmt.Errorf("error writing segment %d: %v", i, err)
+			}
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading segment %d: %v", i, err)
+		}
+		if i > 0 && segments[i].Discontinuity {
+			r.pending = r.started
+		}
+		// anything that isn't plain MPEG-TS (e.g. raw AAC) is left untouched
+		if len(data)%tsPacketSize == 0 && len(data) > 0 && data[0] == tsSyncByte {
+			for pkt := range slices.Chunk(data, tsPacketSize) {
+				if pkt[0] == tsSyncByte {
+					r.packet(pkt)
+				}
+			}
+		}
+		r.duration += segments[i].Duration
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("error writing segment %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func appendFile(out io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(out, f)
+	return err
+}
+
+// tsRewriter shifts the timestamps of each part of a stream split by
+// discontinuities so the parts play back to back.
+type tsRewriter struct {
+	offset   int64   // 90 kHz ticks added to the current part's timestamps
+	start    int64   // shifted first timestamp of the current part
+	duration float64 // seconds of the current part written so far
+	started  bool    // a timestamp has been seen
+	pending  bool    // the next timestamp starts a new part
+	counters map[uint16]byte
+}
+
+// shift moves a timestamp of the current part. The first timestamp of a new
+// part is placed right after the previous part's playlist duration.
+func (r *tsRewriter) shift(ts int64) int64 {
+	switch {
+	case !r.started:
+		r.start, r.started = ts, true
+	case r.pending:
+		next := (r.start + int64(math.Round(r.duration*90000))) % timestampWrap
+		r.offset, r.start, r.duration, r.pending = next-ts, next, 0, false
+	}
+	return ((ts+r.offset)%timestampWrap + timestampWrap) % timestampWrap
+}
+
+func (r *tsRewriter) packet(pkt []byte) {
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	control := pkt[3] >> 4 & 0x3
+	if pid != 0x1fff && control&1 != 0 {
+		// continuity counters only advance on packets with payload
+		cc, seen := r.counters[pid]
+		if seen {
+			cc = (cc + 1) & 0xf
+			pkt[3] = pkt[3]&0xf0 | cc
+		} else {
+			cc = pkt[3] & 0xf
+		}
+		r.counters[pid] = cc
+	}
+
+	payload := 4
+	if control&2 != 0 {
+		length := int(pkt[4])
+		if length >= 7 && pkt[5]&0x10 != 0 {
+			pcr := pkt[6:12]
+			writePCRBase(pcr, r.shift(readPCRBase(pcr)))
+		}
+		payload = 5 + length
+	}
+	if control&1 == 0 || pkt[1]&0x40 == 0 || payload+19 > tsPacketSize {
+		return
+	}
+	pes := pkt[payload:]
+	if pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || !hasPESHeader(pes[3]) || pes[6]&0xc0 != 0x80 {
+		return
+	}
+	flags := pes[7] >> 6
+	if flags&2 != 0 {
+		writeTimestamp(pes[9:14], r.shift(readTimestamp(pes[9:14])))
+	}
+	if flags == 3 {
+		writeTimestamp(pes[14:19], r.shift(readTimestamp(pes[14:19])))
+	}
+}
+
+// hasPESHeader reports whether a PES stream ID carries the optional header
+// holding PTS and DTS.
+func hasPESHeader(streamID byte) bool {
+	switch streamID {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		return false
+	}
+	return true
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// writeTimestamp stores a 33-bit PTS or DTS, keeping its prefix and markers.
+func writeTimestamp(b []byte, ts int64) {
+	b[0] = b[0]&0xf1 | byte(ts>>29)&0x0e
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14)&0xfe | 1
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1)&0xfe | 1
+}
+
+func readPCRBase(b []byte) int64 {
+	return int64(b[0])<<25 | int64(b[1])<<17 | int64(b[2])<<9 | int64(b[3])<<1 | int64(b[4]>>7)
+}
+
+// writePCRBase stores the 90 kHz part of a PCR; the 27 MHz extension is kept.
+func writePCRBase(b []byte, base int64) {
+	b[0] = byte(base >> 25)
+	b[1] = byte(base >> 17)
+	b[2] = byte(base >> 9)
+	b[3] = byte(base >> 1)
+	b[4] = b[4]&0x7f | byte(base<<7)&0x80
+}
@@ -0,0 +1,215 @@
+package m3u8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tanq16/danzo/utils"
+)
+
+// defaultPollInterval is used for live playlists without #EXT-X-TARGETDURATION.
+const defaultPollInterval = 6 * time.Second
+
+// maxPollFailures is how many playlist refreshes in a row may fail before a
+// recording gives up.
+const maxPollFailures = 5
+
+type recordOptions struct {
+	connections int
+	limit       time.Duration // media time to record, 0 for no limit
+	maxMissing  int           // segments that may fail over the whole recording
+	keys        *keyStore
+	client      *utils.DanzoHTTPClient
+	// onProgress reports downloaded bytes, and recorded seconds after each batch
+	onProgress func(bytes int64, seconds float64)
+}
+
+// recordPlaylist follows a live media playlist, downloading segments it has not
+// seen yet (by media sequence number) into dir. The playlist is reloaded every
+// target duration, or every half target duration when a reload brought nothing
+// new. Recording ends at #EXT-X-ENDLIST, once the limit is reached, or when
+// stop is cancelled; segments already requested are always finished so the
+// track can be merged. Segments that failed within opts.maxMissing keep ""
+// files and are listed in the track's gaps. What an interrupted run recorded
+// into dir is kept, and the recording continues after it.
+func recordPlaylist(stop context.Context, playlistURL, dir string, opts recordOptions) track {
+	work := context.WithoutCancel(stop)
+	t := track{dir: dir}
+	nextSequence := int64(-1)
+	var recorded, elapsed float64 // seconds selected, and downloaded before this batch
+	failures, missing := 0, 0
+	t.segments, t.files = loadRecording(dir)
+	resumed := len(t.segments) > 0
+	if resumed {
+		earlier := make([]error, len(t.files))
+		for i, file := range t.files {
+			if file == "" {
+				earlier[i] = errRecordedGap
+				missing++
+			}
+			elapsed += t.segments[i].Duration
+		}
+		t.gaps = findGaps(t.segments, earlier)
+		recorded = elapsed
+		nextSequence = t.segments[len(t.segments)-1].Sequence + 1
+		opts.onProgress(0, elapsed)
+	}
+	first := true
+	for {
+		interval := defaultPollInterval
+		info, err := fetchMediaPlaylist(stop, playlistURL, opts.client)
+		if err != nil {
+			if stop.Err() != nil {
+				return t
+			}
+			if failures++; failures >= maxPollFailures {
+				t.err = fmt.Errorf("error reloading playlist: %v", err)
+				return t
+			}
+		} else {
+			failures = 0
+			if info.TargetDuration > 0 {
+				interval = time.Duration(info.TargetDuration * float64(time.Second))
+			}
+			if first {
+				first = false
+				t.init = info.VideoInit
+				t.isFMP4 = detectFMP4Format(playlistURL, segmentURLs(info.VideoSegments))
+				if n := len(info.VideoSegments); resumed && n > 0 && info.VideoSegments[n-1].Sequence < nextSequence-1 {
+					t.err = fmt.Errorf("the stream restarted its media sequence numbers, so recording did not continue (the earlier segments are kept in %s)", dir)
+					return t
+				}
+			}
+			var fresh []Segment
+			for _, seg := range info.VideoSegments {
+				if seg.Sequence < nextSequence {
+					continue
+				}
+				if opts.limit > 0 && recorded >= opts.limit.Seconds() {
+					break
+				}
+				fresh = append(fresh, seg)
+				recorded += seg.Duration
+			}
+			if len(fresh) > 0 {
+				if resumed {
+					// time passed between the runs
+					fresh[0].Discontinuity = true
+					resumed = false
+				}
+				progressFunc := func(size, _ int64) { opts.onProgress(size, 0) }
+				files, gaps, err := downloadSegmentsParallel(work, fresh, dir, opts.connections, opts.keys, opts.client, progressFunc, 0, t.isFMP4, opts.maxMissing-missing)
+				// a batch that failed entirely is still just a gap in the recording
+				if err != nil && len(gaps) == 0 {
+					t.err = err
+					return t
+				}
+				for _, gap := range gaps {
+					gap.start += elapsed
+					missing += int(gap.last - gap.first + 1)
+					if n := len(t.gaps); n > 0 && t.gaps[n-1].last+1 == gap.first {
+						t.gaps[n-1].last = gap.last
+						t.gaps[n-1].duration += gap.duration
+						continue
+					}
+					t.gaps = append(t.gaps, gap)
+				}
+				t.segments = append(t.segments, fresh...)
+				t.files = append(t.files, files...)
+				saveRecording(dir, fresh, files)
+				nextSequence = fresh[len(fresh)-1].Sequence + 1
+				var seconds float64
+				for _, seg := range fresh {
+					seconds += seg.Duration
+				}
+				elapsed += seconds
+				opts.onProgress(0, seconds)
+			} else {
+				interval /= 2
+			}
+			if info.EndList || (opts.limit > 0 && recorded >= opts.limit.Seconds()) {
+				return t
+			}
+		}
+		select {
+		case <-stop.Done():
+			return t
+		case <-time.After(interval):
+		}
+	}
+}
+
+// recordingIndex lists the segments recorded into a track's directory, one
+// "sequence duration discontinuity file" line each ("-" for a gap), so a
+// resumed recording can merge them.
+const recordingIndex = "recorded.txt"
+
+var errRecordedGap = errors.New("missing from the interrupted recording")
+
+// loadRecording reads the segments an earlier run recorded into dir.
+func loadRecording(dir string) ([]Segment, []string) {
+	data, err := os.ReadFile(filepath.Join(dir, recordingIndex))
+	if err != nil {
+		return nil, nil
+	}
+	var segments []Segment
+	var files []string
+	for line := range strings.Lines(string(data)) {
+		var seg Segment
+		var file string
+		if _, err := fmt.Sscanf(line, "%d %g %t %s", &seg.Sequence, &seg.Duration, &seg.Discontinuity, &file); err != nil {
+			continue
+		}
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); file == "-" || err != nil {
+			path = ""
+		}
+		segments = append(segments, seg)
+		files = append(files, path)
+	}
+	return segments, files
+}
+
+// saveRecording adds a batch of recorded segments to dir's index. A failed
+// write only costs a later resume the batch, so it is not reported.
+func saveRecording(dir string, segments []Segment, files []string) {
+	f, err := os.OpenFile(filepath.Join(dir, recordingIndex), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var b strings.Builder
+	for i, seg := range segments {
+		file := "-"
+		if files[i] != "" {
+			file = filepath.Base(files[i])
+		}
+		fmt.Fprintf(&b, "%d %g %t %s\n", seg.Sequence, seg.Duration, seg.Discontinuity, file)
+	}
+	f.WriteString(b.String())
+}
+
+func fetchMediaPlaylist(ctx context.Context, playlistURL string, client *utils.DanzoHTTPClient) (*M3U8Info, error) {
+	content, err := getM3U8Contents(ctx, playlistURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return parseM3U8Content(ctx, content, playlistURL, Options{}, client)
+}
+
+// trimToDuration keeps the leading segments that make up limit seconds of media.
+func trimToDuration(segments []Segment, limit time.Duration) []Segment {
+	var total float64
+	for i, seg := range segments {
+		if total >= limit.Seconds() {
+			return segments[:i]
+		}
+		total += seg.Duration
+	}
+	return segments
+}
@@ -0,0 +1,175 @@
+package m3u8
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/danzo/utils"
+)
+
+// cue is one WebVTT cue; settings are the positioning options after the
+// timings.
+type cue struct {
+	start, end time.Duration
+	settings   string
+	text       string
+}
+
+// saveSubtitles downloads the WebVTT segments of a subtitle rendition and
+// writes them as one file. Cues are shifted by each segment's
+// X-TIMESTAMP-MAP relative to the first segment, so they line up with the
+// merged video, and cues repeated across segment boundaries are kept once.
+func saveSubtitles(ctx context.Context, t MediaTrack, dir, outputPath, format string, connections int, keys *keyStore, client *utils.DanzoHTTPClient) error {
+	files, _, err := downloadSegmentsParallel(ctx, t.Segments, dir, connections, keys, client, nil, 0, false, 0)
+	if err != nil {
+		return fmt.Errorf("error downloading subtitle segments: %v", err)
+	}
+	var cues []cue
+	seen := map[cue]bool{}
+	var base time.Duration
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading subtitle segment: %v", err)
+		}
+		segmentCues, offset := parseWebVTT(string(data))
+		if i == 0 {
+			base = offset
+		}
+		for _, c := range segmentCues {
+			c.start = max(c.start+offset-base, 0)
+			c.end = max(c.end+offset-base, 0)
+			if !seen[c] {
+				seen[c] = true
+				cues = append(cues, c)
+			}
+		}
+	}
+	var out string
+	if format == "srt" {
+		out = formatSRT(cues)
+	} else {
+		out = formatWebVTT(cues)
+	}
+	if err := os.WriteFile(outputPath, []byte(out), 0644); err != nil {
+		return fmt.Errorf("error writing subtitles: %v", err)
+	}
+	return nil
+}
+
+// parseWebVTT returns the cues of a WebVTT document and the offset its
+// X-TIMESTAMP-MAP header places them at (MPEG-TS time minus local time).
+func parseWebVTT(data string) ([]cue, time.Duration) {
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\r", "\n")
+	var cues []cue
+	var offset time.Duration
+	for i, block := range strings.Split(data, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if i == 0 && strings.HasPrefix(block, "WEBVTT") {
+			for line := range strings.SplitSeq(block, "\n") {
+				if value, ok := strings.CutPrefix(line, "X-TIMESTAMP-MAP="); ok {
+					offset = timestampMapOffset(value)
+				}
+			}
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		timing := -1
+		for j, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = j
+				break
+			}
+		}
+		if timing < 0 || timing > 1 {
+			continue // NOTE, STYLE and REGION blocks
+		}
+		startStr, rest, _ := strings.Cut(lines[timing], "-->")
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		start, ok1 := parseVTTTimestamp(strings.TrimSpace(startStr))
+		end, ok2 := parseVTTTimestamp(fields[0])
+		if !ok1 || !ok2 {
+			continue
+		}
+		cues = append(cues, cue{
+			start:    start,
+			end:      end,
+			settings: strings.Join(fields[1:], " "),
+			text:     strings.Join(lines[timing+1:], "\n"),
+		})
+	}
+	return cues, offset
+}
+
+// timestampMapOffset reads "MPEGTS:900000,LOCAL:00:00:00.000".
+func timestampMapOffset(value string) time.Duration {
+	var mpegts int64
+	var local time.Duration
+	for part := range strings.SplitSeq(value, ",") {
+		name, v, _ := strings.Cut(strings.TrimSpace(part), ":")
+		switch strings.ToUpper(name) {
+		case "MPEGTS":
+			mpegts, _ = strconv.ParseInt(v, 10, 64)
+		case "LOCAL":
+			local, _ = parseVTTTimestamp(v)
+		}
+	}
+	return time.Duration(mpegts)*time.Second/90000 - local
+}
+
+// parseVTTTimestamp reads "hh:mm:ss.ttt" or "mm:ss.ttt".
+func parseVTTTimestamp(s string) (time.Duration, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+	if len(parts) != 3 {
+		return 0, false
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	return d + time.Duration(seconds*float64(time.Second)).Round(time.Millisecond), true
+}
+
+func formatTimestamp(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+func formatWebVTT(cues []cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		b.WriteString(formatTimestamp(c.start, ".") + " --> " + formatTimestamp(c.end, "."))
+		if c.settings != "" {
+			b.WriteString(" " + c.settings)
+		}
+		b.WriteString("\n" + c.text + "\n\n")
+	}
+	return b.String()
+}
+
+// vttOnlyTags are WebVTT markup SRT players don't understand: class, voice,
+// ruby and language spans, and karaoke timestamps. <i>, <b> and <u> are kept.
+var vttOnlyTags = regexp.MustCompile(`</?(?:c|v|lang|ruby|rt)(?:[.\s][^>]*)?>|<\d[\d:.]*>`)
+
+func formatSRT(cues []cue) string {
+	var b strings.Builder
+	for i, c := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(c.start, ","), formatTimestamp(c.end, ","), vttOnlyTags.ReplaceAllString(c.text, ""))
+	}
+	return b.String()
+}
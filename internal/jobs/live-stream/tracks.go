@@ -0,0 +1,208 @@
+package m3u8
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tanq16/danzo/internal/highway"
+	"github.com/tanq16/danzo/utils"
+)
+
+// muxInput is an extra track file and the rendition it came from.
+type muxInput struct {
+	path      string
+	rendition Rendition
+}
+
+// addTracks downloads the extra audio and subtitle renditions picked with
+// --audio and --subs, saves the subtitles next to the output and muxes
+// everything into it with language tags.
+func (j *LiveStreamJob) addTracks(ctx context.Context, progress chan<- highway.Progress, info *M3U8Info, tempDir string, keys *keyStore, client *utils.DanzoHTTPClient) error {
+	if len(info.ExtraAudio) == 0 && len(info.Subtitles) == 0 {
+		return nil
+	}
+	progress <- highway.Progress{JobID: j.ID(), Type: highway.ProgressTypeProgress, Message: "Adding audio and subtitle tracks"}
+
+	var audio, subtitles []muxInput
+	for i, t := range info.ExtraAudio {
+		dir := filepath.Join(tempDir, fmt.Sprintf("audio_%d", i+1))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating audio directory: %v", err)
+		}
+		isFMP4 := info.Fragmented || detectFMP4Format(t.Rendition.URL, segmentURLs(t.Segments))
+		files, gaps, err := downloadSegmentsParallel(ctx, t.Segments, dir, j.Connections, keys, client, nil, 0, isFMP4, j.Options.MaxMissing)
+		if err != nil {
+			return fmt.Errorf("error downloading %s audio: %v", trackLabel(t.Rendition), err)
+		}
+		j.noteGaps(trackLabel(t.Rendition)+" audio", gaps)
+		segments, files := withoutGaps(t.Segments, files)
+		path := filepath.Join(tempDir, fmt.Sprintf("audio_%d%s", i+1, nativeExtension(isFMP4, ".m4a")))
+		if err := mergeSegments(ctx, segments, files, path, isFMP4, t.Init, dir, keys, client, j.ffmpeg); err != nil {
+			return fmt.Errorf("error merging %s audio: %v", trackLabel(t.Rendition), err)
+		}
+		audio = append(audio, muxInput{path: path, rendition: t.Rendition})
+	}
+
+	format := j.Options.SubtitleFormat
+	if format != "srt" {
+		format = "vtt"
+	}
+	used := map[string]bool{}
+	for i, t := range info.Subtitles {
+		dir := filepath.Join(tempDir, fmt.Sprintf("subtitles_%d", i+1))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating subtitle directory: %v", err)
+		}
+		path := sidecarPath(j.OutputPath, t.Rendition, format, used)
+		if err := saveSubtitles(ctx, t, dir, path, format, j.Connections, keys, client); err != nil {
+			return fmt.Errorf("error saving %s subtitles: %v", trackLabel(t.Rendition), err)
+		}
+		subtitles = append(subtitles, muxInput{path: path, rendition: t.Rendition})
+	}
+
+	if j.ffmpeg == "" {
+		// nothing can be muxed without ffmpeg; audio is kept next to the
+		// output like the subtitles
+		labels := map[string]bool{}
+		for _, in := range audio {
+			path := sidecarPath(j.OutputPath, in.rendition, strings.TrimPrefix(filepath.Ext(in.path), "."), labels)
+			if err := os.Rename(in.path, path); err != nil {
+				return fmt.Errorf("error saving %s audio: %v", trackLabel(in.rendition), err)
+			}
+		}
+		return nil
+	}
+	if err := muxTracks(j.OutputPath, j.ffmpeg, info.MainAudio, info.AudioInVariant, audio, subtitles); err != nil {
+		return fmt.Errorf("error adding tracks: %v", err)
+	}
+	return nil
+}
+
+func trackLabel(r Rendition) string {
+	if r.Language != "" {
+		return r.Language
+	}
+	return fmt.Sprintf("%q", r.Name)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sidecarPath names a file kept next to the output, such as subtitles, after
+// the rendition's language, e.g. video.en.srt, adding the rendition name on
+// collisions.
+func sidecarPath(outputPath string, r Rendition, ext string, used map[string]bool) string {
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	label := unsafeFileChars.ReplaceAllString(r.Language, "_")
+	if label == "" || used[label] {
+		label = strings.Trim(strings.Join([]string{label, unsafeFileChars.ReplaceAllString(r.Name, "_")}, "."), ".")
+	}
+	for n := 2; label == "" || used[label]; n++ {
+		label = fmt.Sprintf("sub%d", n)
+	}
+	used[label] = true
+	return base + "." + label + "." + ext
+}
+
+// subtitleCodec is what subtitles are stored as in the output container, or
+// "" when it cannot hold text subtitles.
+func subtitleCodec(outputPath string) string {
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".mp4", ".m4v", ".mov":
+		return "mov_text"
+	case ".mkv", ".mka", ".webm":
+		return "copy"
+	}
+	return ""
+}
+
+// muxTracks adds audio and subtitle files to an already merged output. The
+// output's own streams come first; mainAudio, if known, tags its audio.
+func muxTracks(outputPath, ffmpeg string, mainAudio *Rendition, audioInVariant bool, audio, subtitles []muxInput) error {
+	subCodec := subtitleCodec(outputPath)
+	if subCodec == "" {
+		subtitles = nil // kept as the files next to the output
+	}
+	if len(audio) == 0 && len(subtitles) == 0 {
+		return nil
+	}
+	args := []string{"-i", outputPath}
+	for _, in := range append(append([]muxInput{}, audio...), subtitles...) {
+		args = append(args, "-i", in.path)
+	}
+	args = append(args, "-map", "0")
+	for i := range audio {
+		args = append(args, "-map", fmt.Sprintf("%d:a", i+1))
+	}
+	for i := range subtitles {
+		args = append(args, "-map", fmt.Sprintf("%d:s", len(audio)+i+1))
+	}
+	args = append(args, "-c", "copy")
+	if len(subtitles) > 0 {
+		args = append(args, "-c:s", subCodec)
+	}
+
+	first := 0 // output index of the first extra audio stream
+	if mainAudio != nil {
+		args = append(args, tagArgs("a:0", *mainAudio)...)
+		first = 1
+	} else if audioInVariant {
+		first = 1
+	}
+	for i, in := range audio {
+		args = append(args, tagArgs(fmt.Sprintf("a:%d", first+i), in.rendition)...)
+	}
+	for i, in := range subtitles {
+		args = append(args, tagArgs(fmt.Sprintf("s:%d", i), in.rendition)...)
+	}
+	if subCodec == "mov_text" {
+		args = append(args, "-movflags", "+faststart")
+	}
+
+	ext := filepath.Ext(outputPath)
+	tempOutput := strings.TrimSuffix(outputPath, ext) + ".muxing" + ext
+	args = append(args, "-y", tempOutput)
+	output, err := exec.Command(ffmpeg, args...).CombinedOutput()
+	if err != nil {
+		os.Remove(tempOutput)
+		return fmt.Errorf("ffmpeg error: %v\nOutput: %s", err, string(output))
+	}
+	return os.Rename(tempOutput, outputPath)
+}
+
+// tagArgs sets the language and title of an output stream.
+func tagArgs(stream string, r Rendition) []string {
+	var args []string
+	if r.Language != "" {
+		args = append(args, "-metadata:s:"+stream, "language="+iso639_2(r.Language))
+	}
+	if r.Name != "" {
+		args = append(args, "-metadata:s:"+stream, "title="+r.Name)
+	}
+	return args
+}
+
+// iso6391To2 maps common two-letter language codes to the three-letter ones
+// MP4 and Matroska store.
+var iso6391To2 = map[string]string{
+	"ar": "ara", "cs": "cze", "da": "dan", "de": "ger", "el": "gre",
+	"en": "eng", "es": "spa", "fi": "fin", "fr": "fre", "he": "heb",
+	"hi": "hin", "hu": "hun", "id": "ind", "it": "ita", "ja": "jpn",
+	"ko": "kor", "nl": "dut", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "rum", "ru": "rus", "sv": "swe", "th": "tha", "tr": "tur",
+	"uk": "ukr", "vi": "vie", "zh": "chi",
+}
+
+// iso639_2 turns a playlist LANGUAGE (RFC 5646, e.g. "en-US") into an
+// ISO 639-2 code where one is known.
+func iso639_2(language string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(language), "-")
+	if code, ok := iso6391To2[primary]; ok {
+		return code
+	}
+	return primary
+}
@@ -0,0 +1,415 @@
+package m3u8
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tanq16/danzo/utils"
+)
+
+// Variant is an #EXT-X-STREAM-INF entry of a master playlist.
+type Variant struct {
+	URL           string
+	Bandwidth     int
+	Width         int
+	Height        int
+	Codecs        string
+	FrameRate     float64
+	AudioGroup    string
+	SubtitleGroup string
+}
+
+// Rendition is an #EXT-X-MEDIA entry of a master playlist. URL is empty for
+// renditions carried inside the variant streams.
+type Rendition struct {
+	Type     string // AUDIO, SUBTITLES, ...
+	GroupID  string
+	Name     string
+	Language string
+	URL      string
+	Default  bool
+}
+
+type MasterPlaylist struct {
+	Variants   []Variant
+	Renditions []Rendition
+}
+
+func isMasterPlaylist(content string) bool {
+	return strings.Contains(content, "#EXT-X-STREAM-INF")
+}
+
+func parseMasterPlaylist(ctx context.Context, content, manifestURL string) (*MasterPlaylist, error) {
+	baseURL, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing manifest URL: %v", err)
+	}
+	master := &MasterPlaylist{}
+	var pending *Variant
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "#EXT-X-STREAM-INF:"); ok {
+			attrs := parseAttributes(value)
+			pending = &Variant{Codecs: attrs["CODECS"], AudioGroup: attrs["AUDIO"], SubtitleGroup: attrs["SUBTITLES"]}
+			pending.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+			if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				pending.Width, _ = strconv.Atoi(w)
+				pending.Height, _ = strconv.Atoi(h)
+			}
+			pending.FrameRate, _ = strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "#EXT-X-MEDIA:"); ok {
+			attrs := parseAttributes(value)
+			rendition := Rendition{
+				Type:     strings.ToUpper(attrs["TYPE"]),
+				GroupID:  attrs["GROUP-ID"],
+				Name:     attrs["NAME"],
+				Language: attrs["LANGUAGE"],
+				Default:  strings.EqualFold(attrs["DEFAULT"], "YES"),
+			}
+			if attrs["URI"] != "" {
+				if rendition.URL, err = resolveURL(baseURL, attrs["URI"]); err != nil {
+					return nil, fmt.Errorf("error resolving rendition URL: %v", err)
+				}
+			}
+			master.Renditions = append(master.Renditions, rendition)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || pending == nil {
+			continue
+		}
+		if pending.URL, err = resolveURL(baseURL, line); err != nil {
+			return nil, fmt.Errorf("error resolving URL: %v", err)
+		}
+		master.Variants = append(master.Variants, *pending)
+		pending = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning m3u8 content: %v", err)
+	}
+	return master, nil
+}
+
+// ValidateQuality reports whether q is a valid --quality value.
+func ValidateQuality(q string) error {
+	_, _, err := parseQuality(q)
+	return err
+}
+
+// parseQuality reads "best", "worst", a height like "720p" or a bandwidth in
+// bits/s with an optional k or M suffix. At most one of height and bandwidth
+// is set; both are 0 for best and worst.
+func parseQuality(q string) (height, bandwidth int, err error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	switch q {
+	case "", "best", "worst":
+		return 0, 0, nil
+	}
+	if digits, ok := strings.CutSuffix(q, "p"); ok {
+		if height, err = strconv.Atoi(digits); err == nil && height > 0 {
+			return height, 0, nil
+		}
+		return 0, 0, fmt.Errorf("invalid quality %q", q)
+	}
+	multiplier := 1.0
+	if digits, ok := strings.CutSuffix(q, "k"); ok {
+		q, multiplier = digits, 1e3
+	} else if digits, ok := strings.CutSuffix(q, "m"); ok {
+		q, multiplier = digits, 1e6
+	}
+	value, err := strconv.ParseFloat(q, 64)
+	if err != nil || value <= 0 {
+		return 0, 0, fmt.Errorf("invalid quality %q (want best, worst, a height like 720p or a bandwidth like 2500k)", q)
+	}
+	return 0, int(value * multiplier), nil
+}
+
+// codecAliases maps --codec names to the CODECS prefixes they stand for.
+var codecAliases = map[string][]string{
+	"avc":  {"avc1", "avc3"},
+	"h264": {"avc1", "avc3"},
+	"hevc": {"hvc1", "hev1"},
+	"h265": {"hvc1", "hev1"},
+	"av1":  {"av01"},
+	"vp9":  {"vp09", "vp9"},
+}
+
+func matchesCodec(codecs, want string) bool {
+	want = strings.ToLower(want)
+	prefixes, ok := codecAliases[want]
+	if !ok {
+		prefixes = []string{want}
+	}
+	for codec := range strings.SplitSeq(strings.ToLower(codecs), ",") {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.TrimSpace(codec), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectVariant applies --codec and --quality. The codec is a preference: it
+// narrows the choice only when some variant uses it. A height or bandwidth
+// picks the closest variant not above it, or the smallest one if all are.
+func selectVariant(variants []Variant, opts Options) (Variant, error) {
+	if len(variants) == 0 {
+		return Variant{}, fmt.Errorf("master playlist has no variants")
+	}
+	if i := slices.IndexFunc(variants, func(v Variant) bool { return v.URL == opts.Variant }); opts.Variant != "" && i >= 0 {
+		return variants[i], nil
+	}
+	candidates := variants
+	if opts.Codec != "" {
+		var preferred []Variant
+		for _, v := range variants {
+			if matchesCodec(v.Codecs, opts.Codec) {
+				preferred = append(preferred, v)
+			}
+		}
+		if len(preferred) > 0 {
+			candidates = preferred
+		}
+	}
+	height, bandwidth, err := parseQuality(opts.Quality)
+	if err != nil {
+		return Variant{}, err
+	}
+	byBandwidth := func(v Variant) int { return v.Bandwidth }
+	switch {
+	case height > 0:
+		var sized []Variant
+		for _, v := range candidates {
+			if v.Height > 0 {
+				sized = append(sized, v)
+			}
+		}
+		if len(sized) == 0 {
+			return Variant{}, fmt.Errorf("no variant advertises a RESOLUTION to match %dp against", height)
+		}
+		return closestVariant(sized, height, func(v Variant) int { return v.Height }), nil
+	case bandwidth > 0:
+		return closestVariant(candidates, bandwidth, byBandwidth), nil
+	case strings.EqualFold(strings.TrimSpace(opts.Quality), "worst"):
+		return closestVariant(candidates, 0, byBandwidth), nil
+	default:
+		return closestVariant(candidates, math.MaxInt, byBandwidth), nil
+	}
+}
+
+// closestVariant returns the variant with the largest key not above target, or
+// the smallest key when all are above. Ties go to the higher bandwidth.
+func closestVariant(variants []Variant, target int, key func(Variant) int) Variant {
+	var below, above *Variant
+	better := func(v, current *Variant, larger bool) bool {
+		if current == nil {
+			return true
+		}
+		if key(*v) != key(*current) {
+			return (key(*v) > key(*current)) == larger
+		}
+		return v.Bandwidth > current.Bandwidth
+	}
+	for i := range variants {
+		v := &variants[i]
+		if key(*v) <= target {
+			if better(v, below, true) {
+				below = v
+			}
+		} else if better(v, above, false) {
+			above = v
+		}
+	}
+	if below != nil {
+		return *below
+	}
+	return *above
+}
+
+// selectAudio picks the audio renditions to download next to the variant,
+// from its AUDIO group: one per --audio language or name, in that order, or
+// else the DEFAULT one, else the best sounding by name. Renditions muxed into
+// the variant itself are left out.
+func selectAudio(renditions []Rendition, variant Variant, opts Options) ([]Rendition, error) {
+	candidates := groupRenditions(renditions, "AUDIO", variant.AudioGroup)
+	if len(opts.Audio) == 0 {
+		best := bestRendition(candidates)
+		if best == nil || best.URL == "" {
+			return nil, nil
+		}
+		return []Rendition{*best}, nil
+	}
+	return matchRenditions(candidates, opts.Audio, "audio")
+}
+
+// selectSubtitles picks the subtitle renditions named by --subs from the
+// variant's SUBTITLES group; "all" selects every one.
+func selectSubtitles(renditions []Rendition, variant Variant, opts Options) ([]Rendition, error) {
+	candidates := groupRenditions(renditions, "SUBTITLES", variant.SubtitleGroup)
+	if len(opts.Subtitles) == 1 && strings.EqualFold(opts.Subtitles[0], "all") {
+		var all []Rendition
+		for _, r := range candidates {
+			if r.URL != "" {
+				all = append(all, r)
+			}
+		}
+		return all, nil
+	}
+	return matchRenditions(candidates, opts.Subtitles, "subtitle")
+}
+
+// groupRenditions returns the renditions of a type in a group, or in any
+// group when the variant names none.
+func groupRenditions(renditions []Rendition, renditionType, group string) []Rendition {
+	var matched []Rendition
+	for _, r := range renditions {
+		if r.Type == renditionType && (group == "" || r.GroupID == group) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// matchRenditions picks the best rendition for each wanted language or name.
+func matchRenditions(candidates []Rendition, wants []string, kind string) ([]Rendition, error) {
+	var selected []Rendition
+	for _, want := range wants {
+		var matched []Rendition
+		for _, r := range candidates {
+			if matchesRendition(r, want) {
+				matched = append(matched, r)
+			}
+		}
+		if len(matched) == 0 {
+			var available []string
+			for _, r := range candidates {
+				available = append(available, cmp.Or(r.Language, r.Name))
+			}
+			return nil, fmt.Errorf("no %s rendition matches %q (available: %s)", kind, want, cmp.Or(strings.Join(slices.Compact(available), ", "), "none"))
+		}
+		best := bestRendition(matched)
+		if best.URL != "" && !slices.ContainsFunc(selected, func(r Rendition) bool { return r.URL == best.URL }) {
+			selected = append(selected, *best)
+		}
+	}
+	return selected, nil
+}
+
+// bestRendition prefers the DEFAULT rendition, then the best sounding by name.
+func bestRendition(renditions []Rendition) *Rendition {
+	best, bestScore := -1, -1
+	for i, r := range renditions {
+		score := audioQuality(r)
+		if r.Default {
+			score += 4
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return &renditions[best]
+}
+
+// matchesRendition compares want to a rendition's NAME or LANGUAGE; a primary
+// language like "en" also matches "en-US".
+func matchesRendition(r Rendition, want string) bool {
+	lang, want := strings.ToLower(r.Language), strings.ToLower(want)
+	return lang == want || strings.HasPrefix(lang, want+"-") || strings.EqualFold(r.Name, want)
+}
+
+// audioQuality guesses quality from "high/medium/low" in GROUP-ID or NAME.
+func audioQuality(r Rendition) int {
+	for _, label := range []string{r.GroupID, r.Name} {
+		label = strings.ToLower(label)
+		switch {
+		case strings.Contains(label, "high"):
+			return 3
+		case strings.Contains(label, "medium"):
+			return 2
+		case strings.Contains(label, "low"):
+			return 1
+		}
+	}
+	return 0
+}
+
+// ListVariants fetches a master playlist (after running the extractor) for
+// --list-variants. For a DASH MPD the first period's representations are
+// listed.
+func ListVariants(ctx context.Context, urlStr, extractor string, httpConfig utils.HTTPClientConfig) (*MasterPlaylist, error) {
+	found, err := extractStream(ctx, urlStr, extractor, httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := utils.NewDanzoHTTPClient(withHeaders(httpConfig, found.Headers))
+	urlStr, content, err := fetchFirstManifest(ctx, found.Manifests, client)
+	if err != nil {
+		return nil, err
+	}
+	if isDASHManifest(content) {
+		doc, err := parseMPDDocument(content)
+		if err != nil {
+			return nil, err
+		}
+		base, err := resolveBaseURL(urlStr, doc.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		ps, err := periodStreams(&doc.Periods[0], base)
+		if err != nil {
+			return nil, err
+		}
+		return &ps.master, nil
+	}
+	if !isMasterPlaylist(content) {
+		return nil, fmt.Errorf("%s is a media playlist with a single rendition", urlStr)
+	}
+	return parseMasterPlaylist(ctx, content, urlStr)
+}
+
+func (v Variant) String() string {
+	resolution := "unknown"
+	if v.Width > 0 && v.Height > 0 {
+		resolution = fmt.Sprintf("%dx%d", v.Width, v.Height)
+	}
+	parts := []string{resolution, fmt.Sprintf("%d kbps", v.Bandwidth/1000)}
+	if v.FrameRate > 0 {
+		parts = append(parts, strconv.FormatFloat(v.FrameRate, 'f', -1, 64)+" fps")
+	}
+	if v.Codecs != "" {
+		parts = append(parts, v.Codecs)
+	}
+	if v.AudioGroup != "" {
+		parts = append(parts, "audio="+v.AudioGroup)
+	}
+	return strings.Join(parts, "  ")
+}
+
+func (r Rendition) String() string {
+	parts := []string{strings.ToLower(r.Type), cmp.Or(r.Language, "-"), fmt.Sprintf("%q", r.Name), "group=" + r.GroupID}
+	if r.Default {
+		parts = append(parts, "default")
+	}
+	if r.URL == "" {
+		parts = append(parts, "(in variant)")
+	}
+	return strings.Join(parts, "  ")
+}
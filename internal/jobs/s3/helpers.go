@@ -2,10 +2,14 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -18,14 +22,22 @@ type S3Client struct {
 }
 
 type s3Object struct {
-	Key  string
-	Size int64
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
 }
 
-func getS3Client(ctx context.Context, profile string) (*S3Client, error) {
+func getS3Client(ctx context.Context, profile string, httpConfig utils.HTTPClientConfig) (*S3Client, error) {
+	// route the SDK through Danzo's transport for proxy (incl. SOCKS) and TLS options
+	transport, err := utils.NewHTTPTransport(httpConfig)
+	if err != nil {
+		return nil, err
+	}
 	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithSharedConfigProfile(profile),
 		config.WithRetryMode("adaptive"),
+		config.WithHTTPClient(&http.Client{Transport: transport}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error loading AWS config: %v", err)
@@ -36,17 +48,21 @@ func getS3Client(ctx context.Context, profile string) (*S3Client, error) {
 	}, nil
 }
 
-func getS3ObjectInfo(ctx context.Context, bucket, key string, client *S3Client) (string, int64, error) {
+func getS3ObjectInfo(ctx context.Context, bucket, key string, client *S3Client) (string, s3Object, error) {
 	headObj, err := client.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
 	if err == nil {
-		size := int64(0)
+		obj := s3Object{
+			Key:          key,
+			ETag:         aws.ToString(headObj.ETag),
+			LastModified: aws.ToTime(headObj.LastModified),
+		}
 		if headObj.ContentLength != nil {
-			size = *headObj.ContentLength
+			obj.Size = *headObj.ContentLength
 		}
-		return "file", size, nil
+		return "file", obj, nil
 	}
 	result, err := client.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 		Bucket:  aws.String(bucket),
@@ -54,12 +70,12 @@ func getS3ObjectInfo(ctx context.Context, bucket, key string, client *S3Client)
 		MaxKeys: aws.Int32(1),
 	})
 	if err != nil {
-		return "", 0, fmt.Errorf("error accessing S3 object: %v", err)
+		return "", s3Object{}, fmt.Errorf("error accessing S3 object: %v", err)
 	}
 	if len(result.Contents) > 0 || len(result.CommonPrefixes) > 0 {
-		return "folder", -1, nil
+		return "folder", s3Object{Key: key, Size: -1}, nil
 	}
-	return "", 0, fmt.Errorf("S3 object not found")
+	return "", s3Object{}, fmt.Errorf("S3 object not found")
 }
 
 func listS3Objects(ctx context.Context, bucket, prefix string, client *S3Client) ([]s3Object, error) {
@@ -79,8 +95,10 @@ func listS3Objects(ctx context.Context, bucket, prefix string, client *S3Client)
 					continue
 				}
 				objects = append(objects, s3Object{
-					Key:  *obj.Key,
-					Size: *obj.Size,
+					Key:          *obj.Key,
+					Size:         *obj.Size,
+					ETag:         aws.ToString(obj.ETag),
+					LastModified: aws.ToTime(obj.LastModified),
 				})
 			}
 		}
@@ -88,7 +106,11 @@ func listS3Objects(ctx context.Context, bucket, prefix string, client *S3Client)
 	return objects, nil
 }
 
-func performS3Download(ctx context.Context, bucket, key, outputPath string, client *S3Client, progressCh chan<- int64) error {
+// performS3Download writes an object to outputPath (or stdout) and gives the
+// file the object's LastModified time, plus s3:// origin and checksum xattrs
+// when asked. Files are written under a temporary name and renamed into
+// place, so a re-synced file is never seen half-written.
+func performS3Download(ctx context.Context, bucket, key, outputPath string, httpConfig utils.HTTPClientConfig, client *S3Client, progressCh chan<- int64) error {
 	result, err := client.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
@@ -97,11 +119,18 @@ func performS3Download(ctx context.Context, bucket, key, outputPath string, clie
 		return fmt.Errorf("error getting object: %v", err)
 	}
 	defer result.Body.Close()
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("error creating file: %v", err)
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if outputPath != utils.StdoutPath {
+		// created like any other file so the umask decides its permissions
+		file, err = os.Create(filepath.Join(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".part"))
+		if err != nil {
+			return fmt.Errorf("error creating file: %v", err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		out = file
 	}
-	defer file.Close()
 
 	buffer := make([]byte, utils.DefaultBufferSize)
 	for {
@@ -112,7 +141,7 @@ func performS3Download(ctx context.Context, bucket, key, outputPath string, clie
 		}
 		n, err := result.Body.Read(buffer)
 		if n > 0 {
-			_, writeErr := file.Write(buffer[:n])
+			_, writeErr := out.Write(buffer[:n])
 			if writeErr != nil {
 				return fmt.Errorf("error writing file: %v", writeErr)
 			}
@@ -125,7 +154,24 @@ func performS3Download(ctx context.Context, bucket, key, outputPath string, clie
 			return fmt.Errorf("error reading object: %v", err)
 		}
 	}
-	return nil
+	if file == nil {
+		return nil
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	// missing xattrs are returned once the file is in place
+	metadataErr := utils.SetFileMetadata(file.Name(), aws.ToTime(result.LastModified), "s3://"+bucket+"/"+key, httpConfig.Xattrs)
+	if metadataErr != nil && !errors.Is(metadataErr, utils.ErrXattrsNotWritten) {
+		return fmt.Errorf("error preserving metadata of %s: %v", outputPath, metadataErr)
+	}
+	if httpConfig.Sync {
+		utils.SetSyncValidator(file.Name(), aws.ToString(result.ETag))
+	}
+	if err := os.Rename(file.Name(), outputPath); err != nil {
+		return fmt.Errorf("error renaming (finalizing) output file: %v", err)
+	}
+	return metadataErr
 }
 
 func directoryExists(path string) (bool, error) {
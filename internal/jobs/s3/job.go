@@ -3,6 +3,7 @@ package s3
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -19,18 +20,33 @@ type S3Job struct {
 	OutputPath  string
 	Connections int
 	Profile     string
+	HTTPConfig  utils.HTTPClientConfig
 }
 
 type s3JobState struct {
-	URL         string `json:"url"`
-	OutputPath  string `json:"outputPath"`
-	Connections int    `json:"connections"`
-	Profile     string `json:"profile"`
+	URL           string   `json:"url"`
+	OutputPath    string   `json:"outputPath"`
+	Connections   int      `json:"connections"`
+	Profile       string   `json:"profile"`
+	ProxyURL      string   `json:"proxyURL,omitempty"`
+	NoProxy       string   `json:"noProxy,omitempty"`
+	CACertFile    string   `json:"caCertFile,omitempty"`
+	CertFile      string   `json:"certFile,omitempty"`
+	KeyFile       string   `json:"keyFile,omitempty"`
+	Insecure      bool     `json:"insecure,omitempty"`
+	MinTLSVersion string   `json:"minTLSVersion,omitempty"`
+	Resolve       []string `json:"resolve,omitempty"`
+	IPVersion     int      `json:"ipVersion,omitempty"`
+	Interface     string   `json:"interface,omitempty"`
+	LocalAddress  string   `json:"localAddress,omitempty"`
+	HTTPVersion   string   `json:"httpVersion,omitempty"`
+	Xattrs        bool     `json:"xattrs,omitempty"`
+	Sync          bool     `json:"sync,omitempty"`
 }
 
-func New(url, outputPath string, connections int, profile string) *S3Job {
+func New(url, outputPath string, connections int, profile string, httpConfig utils.HTTPClientConfig) *S3Job {
 	id := outputPath
-	if id == "" {
+	if id == "" || id == utils.StdoutPath {
 		id = url
 	}
 	return &S3Job{
@@ -39,6 +55,7 @@ func New(url, outputPath string, connections int, profile string) *S3Job {
 		OutputPath:  outputPath,
 		Connections: connections,
 		Profile:     profile,
+		HTTPConfig:  httpConfig,
 	}
 }
 
@@ -54,12 +71,12 @@ func (j *S3Job) Run(ctx context.Context, progress chan<- highway.Progress) error
 		return err
 	}
 
-	s3Client, err := getS3Client(ctx, j.Profile)
+	s3Client, err := getS3Client(ctx, j.Profile, j.HTTPConfig)
 	if err != nil {
 		return fmt.Errorf("error creating S3 client: %v", err)
 	}
 
-	fileType, size, err := getS3ObjectInfo(ctx, bucket, key, s3Client)
+	fileType, object, err := getS3ObjectInfo(ctx, bucket, key, s3Client)
 	if err != nil {
 		return fmt.Errorf("error getting S3 object info: %v", err)
 	}
@@ -78,12 +95,20 @@ func (j *S3Job) Run(ctx context.Context, progress chan<- highway.Progress) error
 	}
 
 	if fileType == "folder" {
-		if exists, err := directoryExists(j.OutputPath); err == nil && exists {
+		if j.OutputPath == utils.StdoutPath {
+			return fmt.Errorf("cannot stream an S3 folder to stdout")
+		}
+		if exists, err := directoryExists(j.OutputPath); err == nil && exists && !j.HTTPConfig.Sync {
 			j.OutputPath = utils.RenewOutputPath(j.OutputPath)
 		}
-	} else {
+	} else if j.OutputPath != utils.StdoutPath {
 		if exists, err := fileExists(j.OutputPath); err == nil && exists {
-			j.OutputPath = utils.RenewOutputPath(j.OutputPath)
+			if !j.HTTPConfig.Sync {
+				j.OutputPath = utils.RenewOutputPath(j.OutputPath)
+			} else if utils.RemoteUnchanged(j.OutputPath, object.ETag, object.LastModified, object.Size) {
+				progress <- highway.Progress{JobID: j.ID(), Done: true, Message: "Unchanged"}
+				return nil
+			}
 		}
 	}
 
@@ -92,17 +117,31 @@ func (j *S3Job) Run(ctx context.Context, progress chan<- highway.Progress) error
 		Message: "Downloading",
 	}
 
+	message := ""
 	if fileType == "folder" {
-		err = j.downloadFolder(ctx, progress, bucket, key, s3Client)
+		var unchanged, total int
+		unchanged, total, err = j.downloadFolder(ctx, progress, bucket, key, s3Client)
+		if unchanged == total {
+			message = "Unchanged"
+		} else if unchanged > 0 {
+			message = fmt.Sprintf("%d of %d unchanged", unchanged, total)
+		}
 	} else {
-		err = j.downloadFile(ctx, progress, bucket, key, size, s3Client)
+		err = j.downloadFile(ctx, progress, bucket, key, object.Size, s3Client)
 	}
 
+	if errors.Is(err, utils.ErrXattrsNotWritten) {
+		if message == "" {
+			message = "Done"
+		}
+		message += ", " + err.Error()
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
 
-	progress <- highway.Progress{JobID: j.ID(), Done: true}
+	progress <- highway.Progress{JobID: j.ID(), Done: true, Message: message}
 	return nil
 }
 
@@ -121,26 +160,38 @@ func (j *S3Job) downloadFile(ctx context.Context, progress chan<- highway.Progre
 			}
 		}
 	}()
-	err := performS3Download(ctx, bucket, key, j.OutputPath, s3Client, progressCh)
+	err := performS3Download(ctx, bucket, key, j.OutputPath, j.HTTPConfig, s3Client, progressCh)
 	close(progressCh)
 	<-progressDone
 	return err
 }
 
-func (j *S3Job) downloadFolder(ctx context.Context, progress chan<- highway.Progress, bucket, prefix string, s3Client *S3Client) error {
-	objects, err := listS3Objects(ctx, bucket, prefix, s3Client)
+// downloadFolder downloads every object below prefix and returns how many of
+// them --sync found unchanged, out of how many.
+func (j *S3Job) downloadFolder(ctx context.Context, progress chan<- highway.Progress, bucket, prefix string, s3Client *S3Client) (int, int, error) {
+	listed, err := listS3Objects(ctx, bucket, prefix, s3Client)
 	if err != nil {
-		return fmt.Errorf("error listing objects: %v", err)
+		return 0, 0, fmt.Errorf("error listing objects: %v", err)
 	}
-	if len(objects) == 0 {
-		return fmt.Errorf("no objects found in s3://%s/%s", bucket, prefix)
+	if len(listed) == 0 {
+		return 0, 0, fmt.Errorf("no objects found in s3://%s/%s", bucket, prefix)
 	}
+	var objects []s3Object
 	var totalSize int64
-	for _, obj := range objects {
+	for _, obj := range listed {
+		if j.HTTPConfig.Sync && utils.RemoteUnchanged(j.objectPath(prefix, obj.Key), obj.ETag, obj.LastModified, obj.Size) {
+			continue
+		}
+		objects = append(objects, obj)
 		totalSize += obj.Size
 	}
+	unchanged := len(listed) - len(objects)
+	if len(objects) == 0 {
+		return unchanged, len(listed), nil
+	}
 
 	var totalDownloaded int64
+	var xattrErr atomic.Value // the first ErrXattrsNotWritten, reported once all are done
 	numWorkers := min(j.Connections, len(objects))
 	if numWorkers < 1 {
 		numWorkers = 1
@@ -150,9 +201,7 @@ func (j *S3Job) downloadFolder(ctx context.Context, progress chan<- highway.Prog
 
 	for _, obj := range objects {
 		g.Go(func() error {
-			relPath := strings.TrimPrefix(obj.Key, prefix)
-			relPath = strings.TrimPrefix(relPath, "/")
-			outputPath := filepath.Join(j.OutputPath, relPath)
+			outputPath := j.objectPath(prefix, obj.Key)
 			if err := createDirectory(filepath.Dir(outputPath)); err != nil {
 				return fmt.Errorf("error creating directory: %v", err)
 			}
@@ -170,24 +219,55 @@ func (j *S3Job) downloadFolder(ctx context.Context, progress chan<- highway.Prog
 				}
 			}()
 
-			err := performS3Download(ctx, bucket, obj.Key, outputPath, s3Client, progressCh)
+			err := performS3Download(ctx, bucket, obj.Key, outputPath, j.HTTPConfig, s3Client, progressCh)
 			close(progressCh)
 			<-progressDone
+			if errors.Is(err, utils.ErrXattrsNotWritten) {
+				xattrErr.CompareAndSwap(nil, err)
+				return nil
+			}
 			if err != nil {
 				return fmt.Errorf("error downloading %s: %v", obj.Key, err)
 			}
 			return nil
 		})
 	}
-	return g.Wait()
+	if err := g.Wait(); err != nil {
+		return unchanged, len(listed), err
+	}
+	if err, ok := xattrErr.Load().(error); ok {
+		return unchanged, len(listed), err
+	}
+	return unchanged, len(listed), nil
+}
+
+// objectPath is where an object below prefix is saved in a folder download.
+func (j *S3Job) objectPath(prefix, key string) string {
+	relPath := strings.TrimPrefix(key, prefix)
+	relPath = strings.TrimPrefix(relPath, "/")
+	return filepath.Join(j.OutputPath, relPath)
 }
 
 func (j *S3Job) Marshal() ([]byte, error) {
 	return json.Marshal(s3JobState{
-		URL:         j.URL,
-		OutputPath:  j.OutputPath,
-		Connections: j.Connections,
-		Profile:     j.Profile,
+		URL:           j.URL,
+		OutputPath:    j.OutputPath,
+		Connections:   j.Connections,
+		Profile:       j.Profile,
+		ProxyURL:      j.HTTPConfig.ProxyURL,
+		NoProxy:       j.HTTPConfig.NoProxy,
+		CACertFile:    j.HTTPConfig.CACertFile,
+		CertFile:      j.HTTPConfig.CertFile,
+		KeyFile:       j.HTTPConfig.KeyFile,
+		Insecure:      j.HTTPConfig.Insecure,
+		MinTLSVersion: j.HTTPConfig.MinTLSVersion,
+		Resolve:       j.HTTPConfig.Resolve,
+		IPVersion:     j.HTTPConfig.IPVersion,
+		Interface:     j.HTTPConfig.Interface,
+		LocalAddress:  j.HTTPConfig.LocalAddress,
+		HTTPVersion:   j.HTTPConfig.HTTPVersion,
+		Xattrs:        j.HTTPConfig.Xattrs,
+		Sync:          j.HTTPConfig.Sync,
 	})
 }
 
@@ -196,7 +276,26 @@ func Unmarshal(data []byte) (highway.Job, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
-	return New(state.URL, state.OutputPath, state.Connections, state.Profile), nil
+	job := New(state.URL, state.OutputPath, state.Connections, state.Profile, utils.HTTPClientConfig{
+		ProxyURL:      state.ProxyURL,
+		NoProxy:       state.NoProxy,
+		CACertFile:    state.CACertFile,
+		CertFile:      state.CertFile,
+		KeyFile:       state.KeyFile,
+		Insecure:      state.Insecure,
+		MinTLSVersion: state.MinTLSVersion,
+		Resolve:       state.Resolve,
+		IPVersion:     state.IPVersion,
+		Interface:     state.Interface,
+		LocalAddress:  state.LocalAddress,
+		HTTPVersion:   state.HTTPVersion,
+		Xattrs:        state.Xattrs,
+		Sync:          state.Sync,
+	})
+	if err := utils.RestoreCertPassword(&job.HTTPConfig); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
 
 func parseS3URL(url string) (string, string, error) {
@@ -3,9 +3,9 @@ package torrentjob
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
+	"net"
 	"path/filepath"
 	"strings"
 	"time"
@@ -24,12 +24,23 @@ type TorrentJob struct {
 }
 
 type torrentJobState struct {
-	URI         string            `json:"uri"`
-	OutputPath  string            `json:"outputPath"`
-	Connections int               `json:"connections"`
-	ProxyURL    string            `json:"proxyURL,omitempty"`
-	UserAgent   string            `json:"userAgent,omitempty"`
-	Headers     map[string]string `json:"headers,omitempty"`
+	URI           string            `json:"uri"`
+	OutputPath    string            `json:"outputPath"`
+	Connections   int               `json:"connections"`
+	ProxyURL      string            `json:"proxyURL,omitempty"`
+	NoProxy       string            `json:"noProxy,omitempty"`
+	UserAgent     string            `json:"userAgent,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	CACertFile    string            `json:"caCertFile,omitempty"`
+	CertFile      string            `json:"certFile,omitempty"`
+	KeyFile       string            `json:"keyFile,omitempty"`
+	Insecure      bool              `json:"insecure,omitempty"`
+	MinTLSVersion string            `json:"minTLSVersion,omitempty"`
+	Resolve       []string          `json:"resolve,omitempty"`
+	IPVersion     int               `json:"ipVersion,omitempty"`
+	Interface     string            `json:"interface,omitempty"`
+	LocalAddress  string            `json:"localAddress,omitempty"`
+	HTTPVersion   string            `json:"httpVersion,omitempty"`
 }
 
 func New(uri, outputPath string, connections int, httpConfig utils.HTTPClientConfig) *TorrentJob {
@@ -75,12 +86,28 @@ func (j *TorrentJob) Run(ctx context.Context, progress chan<- highway.Progress)
 	if j.HTTPConfig.UserAgent != "" {
 		cfg.HTTPUserAgent = j.HTTPConfig.UserAgent
 	}
-	if j.HTTPConfig.ProxyURL != "" {
-		if proxyURL, err := url.Parse(j.HTTPConfig.ProxyURL); err == nil {
-			cfg.HTTPProxy = func(*http.Request) (*url.URL, error) {
-				return proxyURL, nil
-			}
+	// webseeds and .torrent sources honor the TLS options; HTTP trackers
+	// build their own transport inside anacrolix/torrent
+	webTransport, err := utils.NewHTTPTransport(j.HTTPConfig)
+	if err != nil {
+		return err
+	}
+	cfg.WebTransport = webTransport
+	socksDial, useSOCKS := utils.ProxyDialContext(j.HTTPConfig)
+	if useSOCKS {
+		// peers, HTTP trackers and webseeds all go through the SOCKS proxy;
+		// UDP (uTP, DHT, UDP trackers) cannot, so it is turned off to avoid
+		// leaking traffic around the tunnel
+		cfg.DisableUTP = true
+		cfg.NoDHT = true
+		cfg.DialForPeerConns = false
+		cfg.TrackerDialContext = socksDial
+		cfg.HTTPDialContext = socksDial
+		cfg.TrackerListenPacket = func(network, addr string) (net.PacketConn, error) {
+			return nil, errors.New("UDP trackers are disabled behind a SOCKS proxy")
 		}
+	} else {
+		cfg.HTTPProxy = utils.ProxyForRequest(j.HTTPConfig)
 	}
 
 	client, err := torrent.NewClient(cfg)
@@ -88,6 +115,9 @@ func (j *TorrentJob) Run(ctx context.Context, progress chan<- highway.Progress)
 		return fmt.Errorf("failed to create torrent client: %v", err)
 	}
 	defer client.Close()
+	if useSOCKS {
+		client.AddDialer(torrent.NetworkDialer{Network: "tcp", Dialer: dialFunc(socksDial)})
+	}
 
 	var t *torrent.Torrent
 	if strings.HasPrefix(j.URI, "magnet:") {
@@ -175,14 +205,31 @@ func (j *TorrentJob) Run(ctx context.Context, progress chan<- highway.Progress)
 	}
 }
 
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
 func (j *TorrentJob) Marshal() ([]byte, error) {
 	return json.Marshal(torrentJobState{
-		URI:         j.URI,
-		OutputPath:  j.OutputPath,
-		Connections: j.Connections,
-		ProxyURL:    j.HTTPConfig.ProxyURL,
-		UserAgent:   j.HTTPConfig.UserAgent,
-		Headers:     j.HTTPConfig.Headers,
+		URI:           j.URI,
+		OutputPath:    j.OutputPath,
+		Connections:   j.Connections,
+		ProxyURL:      j.HTTPConfig.ProxyURL,
+		NoProxy:       j.HTTPConfig.NoProxy,
+		UserAgent:     j.HTTPConfig.UserAgent,
+		Headers:       utils.RedactHeaders(j.HTTPConfig.Headers),
+		CACertFile:    j.HTTPConfig.CACertFile,
+		CertFile:      j.HTTPConfig.CertFile,
+		KeyFile:       j.HTTPConfig.KeyFile,
+		Insecure:      j.HTTPConfig.Insecure,
+		MinTLSVersion: j.HTTPConfig.MinTLSVersion,
+		Resolve:       j.HTTPConfig.Resolve,
+		IPVersion:     j.HTTPConfig.IPVersion,
+		Interface:     j.HTTPConfig.Interface,
+		LocalAddress:  j.HTTPConfig.LocalAddress,
+		HTTPVersion:   j.HTTPConfig.HTTPVersion,
 	})
 }
 
@@ -191,9 +238,24 @@ func Unmarshal(data []byte) (highway.Job, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
-	return New(state.URI, state.OutputPath, state.Connections, utils.HTTPClientConfig{
-		ProxyURL:  state.ProxyURL,
-		UserAgent: state.UserAgent,
-		Headers:   state.Headers,
-	}), nil
+	job := New(state.URI, state.OutputPath, state.Connections, utils.HTTPClientConfig{
+		ProxyURL:      state.ProxyURL,
+		NoProxy:       state.NoProxy,
+		UserAgent:     state.UserAgent,
+		Headers:       state.Headers,
+		CACertFile:    state.CACertFile,
+		CertFile:      state.CertFile,
+		KeyFile:       state.KeyFile,
+		Insecure:      state.Insecure,
+		MinTLSVersion: state.MinTLSVersion,
+		Resolve:       state.Resolve,
+		IPVersion:     state.IPVersion,
+		Interface:     state.Interface,
+		LocalAddress:  state.LocalAddress,
+		HTTPVersion:   state.HTTPVersion,
+	})
+	if err := utils.RestoreCertPassword(&job.HTTPConfig); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -86,8 +87,26 @@ func (j *YTDLPJob) Run(ctx context.Context, prog chan<- highway.Progress) error
 	for k, v := range j.HTTPConfig.Headers {
 		args = append(args, "--add-header", fmt.Sprintf("%s:%s", k, v))
 	}
+	tlsConfig := j.HTTPConfig
+	if tlsConfig.CertFile != "" && utils.IsPKCS12(tlsConfig.CertFile) {
+		// yt-dlp reads PEM only; the converted copy keeps the password off
+		// the command line
+		certDir, err := os.MkdirTemp("", "danzo-cert-")
+		if err != nil {
+			return fmt.Errorf("error creating temp directory: %v", err)
+		}
+		defer os.RemoveAll(certDir)
+		pemPath := filepath.Join(certDir, "client.pem")
+		if err := utils.WriteClientCertificatePEM(tlsConfig.CertFile, tlsConfig.CertPassword, pemPath); err != nil {
+			return err
+		}
+		tlsConfig.CertFile, tlsConfig.KeyFile = pemPath, ""
+	}
+	args = append(args, tlsArgs(tlsConfig)...)
+	args = append(args, networkArgs(j.HTTPConfig)...)
 
 	cmd := exec.CommandContext(ctx, ytdlpBinary, args...)
+	cmd.Env = ytdlpEnv(j.HTTPConfig)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -114,6 +133,58 @@ func (j *YTDLPJob) Run(ctx context.Context, prog chan<- highway.Progress) error
 	return nil
 }
 
+// ytdlpEnv passes options yt-dlp only reads from the environment: NO_PROXY
+// and, since yt-dlp has no CA flag, SSL_CERT_FILE (honored by Python's
+// default verify paths when certifi is disabled).
+func ytdlpEnv(cfg utils.HTTPClientConfig) []string {
+	env := os.Environ()
+	if cfg.NoProxy != "" {
+		env = append(env, "NO_PROXY="+cfg.NoProxy, "no_proxy="+cfg.NoProxy)
+	}
+	if cfg.CACertFile != "" {
+		env = append(env, "SSL_CERT_FILE="+cfg.CACertFile)
+	}
+	return env
+}
+
+// networkArgs maps -4/-6 and the source address; yt-dlp has no --resolve.
+func networkArgs(cfg utils.HTTPClientConfig) []string {
+	var args []string
+	switch cfg.IPVersion {
+	case 4:
+		args = append(args, "--force-ipv4")
+	case 6:
+		args = append(args, "--force-ipv6")
+	}
+	source := cfg.LocalAddress
+	if cfg.Interface != "" {
+		if ip, err := utils.InterfaceAddress(cfg.Interface, cfg.IPVersion); err == nil {
+			source = ip.String()
+		}
+	}
+	if source != "" {
+		args = append(args, "--source-address", source)
+	}
+	return args
+}
+
+func tlsArgs(cfg utils.HTTPClientConfig) []string {
+	var args []string
+	if cfg.Insecure {
+		args = append(args, "--no-check-certificates")
+	}
+	if cfg.CACertFile != "" {
+		args = append(args, "--compat-options", "no-certifi")
+	}
+	if cfg.CertFile != "" {
+		args = append(args, "--client-certificate", cfg.CertFile)
+		if cfg.KeyFile != "" {
+			args = append(args, "--client-certificate-key", cfg.KeyFile)
+		}
+	}
+	return args
+}
+
 func ytdlpError(waitErr error, stderr string) error {
 	stderr = strings.TrimSpace(stderr)
 	if stderr == "" {
@@ -216,8 +287,18 @@ type ytdlpJobState struct {
 	Cookies            string            `json:"cookies,omitempty"`
 	CookiesFromBrowser string            `json:"cookiesFromBrowser,omitempty"`
 	ProxyURL           string            `json:"proxyURL,omitempty"`
+	NoProxy            string            `json:"noProxy,omitempty"`
 	UserAgent          string            `json:"userAgent,omitempty"`
 	Headers            map[string]string `json:"headers,omitempty"`
+	CACertFile         string            `json:"caCertFile,omitempty"`
+	CertFile           string            `json:"certFile,omitempty"`
+	KeyFile            string            `json:"keyFile,omitempty"`
+	Insecure           bool              `json:"insecure,omitempty"`
+	MinTLSVersion      string            `json:"minTLSVersion,omitempty"`
+	Resolve            []string          `json:"resolve,omitempty"`
+	IPVersion          int               `json:"ipVersion,omitempty"`
+	Interface          string            `json:"interface,omitempty"`
+	LocalAddress       string            `json:"localAddress,omitempty"`
 }
 
 func (j *YTDLPJob) Marshal() ([]byte, error) {
@@ -227,8 +308,18 @@ func (j *YTDLPJob) Marshal() ([]byte, error) {
 		Cookies:            j.Cookies,
 		CookiesFromBrowser: j.CookiesFromBrowser,
 		ProxyURL:           j.HTTPConfig.ProxyURL,
+		NoProxy:            j.HTTPConfig.NoProxy,
 		UserAgent:          j.HTTPConfig.UserAgent,
-		Headers:            j.HTTPConfig.Headers,
+		Headers:            utils.RedactHeaders(j.HTTPConfig.Headers),
+		CACertFile:         j.HTTPConfig.CACertFile,
+		CertFile:           j.HTTPConfig.CertFile,
+		KeyFile:            j.HTTPConfig.KeyFile,
+		Insecure:           j.HTTPConfig.Insecure,
+		MinTLSVersion:      j.HTTPConfig.MinTLSVersion,
+		Resolve:            j.HTTPConfig.Resolve,
+		IPVersion:          j.HTTPConfig.IPVersion,
+		Interface:          j.HTTPConfig.Interface,
+		LocalAddress:       j.HTTPConfig.LocalAddress,
 	})
 }
 
@@ -237,9 +328,23 @@ func Unmarshal(data []byte) (highway.Job, error) {
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
-	return New(state.URL, state.OutputPath, state.Cookies, state.CookiesFromBrowser, utils.HTTPClientConfig{
-		ProxyURL:  state.ProxyURL,
-		UserAgent: state.UserAgent,
-		Headers:   state.Headers,
-	}), nil
+	job := New(state.URL, state.OutputPath, state.Cookies, state.CookiesFromBrowser, utils.HTTPClientConfig{
+		ProxyURL:      state.ProxyURL,
+		NoProxy:       state.NoProxy,
+		UserAgent:     state.UserAgent,
+		Headers:       state.Headers,
+		CACertFile:    state.CACertFile,
+		CertFile:      state.CertFile,
+		KeyFile:       state.KeyFile,
+		Insecure:      state.Insecure,
+		MinTLSVersion: state.MinTLSVersion,
+		Resolve:       state.Resolve,
+		IPVersion:     state.IPVersion,
+		Interface:     state.Interface,
+		LocalAddress:  state.LocalAddress,
+	})
+	if err := utils.RestoreCertPassword(&job.HTTPConfig); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
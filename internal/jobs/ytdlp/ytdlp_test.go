@@ -3,6 +3,7 @@ package ytdlpjob
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -226,6 +227,25 @@ func TestMarshalUnmarshalRoundTrip(t *testing.T) {
 	}
 }
 
+func TestTLSArgsMapsClientTLSOptions(t *testing.T) {
+	args := strings.Join(tlsArgs(utils.HTTPClientConfig{
+		Insecure:   true,
+		CACertFile: "ca.pem",
+		CertFile:   "client.pem",
+		KeyFile:    "client.key",
+	}), " ")
+	want := "--no-check-certificates --compat-options no-certifi --client-certificate client.pem --client-certificate-key client.key"
+	if args != want {
+		t.Errorf("tls args: got %q want %q", args, want)
+	}
+	if args := tlsArgs(utils.HTTPClientConfig{}); len(args) != 0 {
+		t.Errorf("expected no TLS args by default, got %v", args)
+	}
+	if args := strings.Join(networkArgs(utils.HTTPClientConfig{IPVersion: 6, LocalAddress: "::1"}), " "); args != "--force-ipv6 --source-address ::1" {
+		t.Errorf("network args: got %q", args)
+	}
+}
+
 func TestRunSurfacesStderrTailWhenBinaryExitsNonZero(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("uses POSIX sh stub")
@@ -303,6 +323,43 @@ exit 0
 	}
 }
 
+func TestRunHandsPKCS12CertificateToYtdlpAsPEMWithoutPassword(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX sh stub")
+	}
+	dir := t.TempDir()
+	stub := writeShellStub(t, fmt.Sprintf(`#!/bin/sh
+echo "$@" > %[1]s/args
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--client-certificate" ]; then echo "$2" > %[1]s/path; cat "$2" > %[1]s/cert; fi
+  shift
+done
+exit 0
+`, dir))
+	swap := swapBinary(t, stub)
+	defer swap()
+
+	progressCh := make(chan highway.Progress, 16)
+	job := New("https://example.com/ok", filepath.Join(dir, "out.mp4"), "", "", utils.HTTPClientConfig{
+		CertFile: "../../../utils/testdata/client.p12", CertPassword: "danzo",
+	})
+	if err := job.Run(context.Background(), progressCh); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if strings.Contains(string(args), "password") || strings.Contains(string(args), "client.p12") {
+		t.Fatalf("expected neither the password nor the bundle on the command line, got %s", args)
+	}
+	cert, _ := os.ReadFile(filepath.Join(dir, "cert"))
+	if !strings.Contains(string(cert), "BEGIN CERTIFICATE") || !strings.Contains(string(cert), "PRIVATE KEY") {
+		t.Fatalf("expected a PEM certificate and key, got %q", cert)
+	}
+	path, _ := os.ReadFile(filepath.Join(dir, "path"))
+	if _, err := os.Stat(strings.TrimSpace(string(path))); !os.IsNotExist(err) {
+		t.Fatalf("expected the converted certificate to be removed, got %v", err)
+	}
+}
+
 func TestRunRenamesExistingNonTemplateOutputPath(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("uses POSIX sh stub")
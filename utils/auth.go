@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sensitiveHeaders are never written to the resume state.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// RedactHeaders returns a copy of headers without credentials, for persisting
+// job configuration. Authentication should come from --netrc-file or
+// --credentials-file, which are re-read when a job resumes.
+func RedactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if isSensitiveHeader(k) {
+			continue
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+func isSensitiveHeader(name string) bool {
+	for _, h := range sensitiveHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// storedCredential returns the credentials file entry (exact host:port first,
+// then hostname) or the matching netrc entry for the request's host.
+func (d *DanzoHTTPClient) storedCredential(req *http.Request) (Credential, bool) {
+	host := req.URL.Hostname()
+	for _, key := range []string{req.URL.Host, host} {
+		if cred, ok := d.credentials[strings.ToLower(key)]; ok {
+			return cred, true
+		}
+	}
+	if entry, ok := LookupNetrc(d.netrc, host); ok && (entry.Login != "" || entry.Password != "") {
+		return Credential{Username: entry.Login, Password: entry.Password}, true
+	}
+	return Credential{}, false
+}
+
+// challengeCredential is the username/password used to answer a 401
+// challenge: --user/--password first, then stored credentials.
+func (d *DanzoHTTPClient) challengeCredential(req *http.Request) (Credential, bool) {
+	if d.config.Username != "" {
+		return Credential{Username: d.config.Username, Password: d.config.Password}, true
+	}
+	cred, ok := d.storedCredential(req)
+	if !ok || cred.Token != "" {
+		return Credential{}, false
+	}
+	return cred, true
+}
+
+// authorize sets Authorization before the request is sent: from a challenge
+// already answered for this user and host, otherwise preemptively from stored
+// credentials. Explicit --user credentials wait for the server's challenge.
+func (d *DanzoHTTPClient) authorize(req *http.Request) {
+	if cred, ok := d.challengeCredential(req); ok {
+		if ch := cachedChallenge(cred.Username, req.URL.Host); ch != nil {
+			req.Header.Set("Authorization", ch.authorization(req, cred))
+			return
+		}
+	}
+	if d.config.Username != "" {
+		return
+	}
+	cred, ok := d.storedCredential(req)
+	if !ok {
+		return
+	}
+	if cred.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cred.Token)
+	} else {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+}
+
+// answerChallenge retries a request that got a 401 with Basic or Digest
+// credentials built from the WWW-Authenticate challenge. The challenge is
+// cached so later requests (e.g. every chunk of a download) authenticate up
+// front. A stale Digest nonce is answered once more with the fresh nonce.
+func (d *DanzoHTTPClient) answerChallenge(req *http.Request, resp *http.Response) (*http.Response, error) {
+	cred, ok := d.challengeCredential(req)
+	if !ok {
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	sent := req.Header.Get("Authorization")
+	for range 2 {
+		ch := selectChallenge(resp.Header.Values("WWW-Authenticate"))
+		if ch == nil {
+			return resp, nil
+		}
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			retry.Body = body
+		}
+		auth := ch.authorization(retry, cred)
+		if ch.scheme == "basic" && auth == sent {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		storeChallenge(cred.Username, req.URL.Host, ch)
+		retry.Header.Set("Authorization", auth)
+		var err error
+		resp, err = d.client.Do(retry)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		if next := selectChallenge(resp.Header.Values("WWW-Authenticate")); next == nil || !next.stale() {
+			return resp, nil
+		}
+		sent = auth
+	}
+	return resp, nil
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CredentialsPassphraseEnv holds the passphrase for the encrypted credentials file.
+const CredentialsPassphraseEnv = "DANZO_CREDENTIALS_PASSPHRASE"
+
+const (
+	credentialsVersion    = 1
+	credentialsIterations = 600000
+	credentialsSaltSize   = 16
+)
+
+// Credential is the authentication stored for a single host. A Token is sent
+// as Bearer auth; otherwise Username/Password are sent as Basic auth.
+type Credential struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+type credentialsEnvelope struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var (
+	credentialsPassphrase string
+	credentialsCache      sync.Map // path -> map[string]Credential
+)
+
+// SetCredentialsPassphrase sets the passphrase used to decrypt credentials
+// files for the rest of the process, e.g. after prompting for it once.
+func SetCredentialsPassphrase(passphrase string) {
+	credentialsPassphrase = passphrase
+}
+
+// CredentialsPassphrase returns the passphrase set for this process, falling
+// back to the DANZO_CREDENTIALS_PASSPHRASE environment variable.
+func CredentialsPassphrase() string {
+	if credentialsPassphrase != "" {
+		return credentialsPassphrase
+	}
+	return os.Getenv(CredentialsPassphraseEnv)
+}
+
+// LoadCredentials decrypts the credentials file at path. A missing file is an
+// empty store so that the first SaveCredentials can create it.
+func LoadCredentials(path, passphrase string) (map[string]Credential, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Credential{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %v", err)
+	}
+	var env credentialsEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("error parsing credentials file: %v", err)
+	}
+	if env.Version != credentialsVersion {
+		return nil, fmt.Errorf("unsupported credentials file version %d", env.Version)
+	}
+	gcm, err := credentialsCipher(passphrase, env.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("error decrypting credentials file: wrong passphrase or corrupted file")
+	}
+	creds := map[string]Credential{}
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("error parsing decrypted credentials: %v", err)
+	}
+	return creds, nil
+}
+
+// SaveCredentials encrypts creds with AES-256-GCM under a PBKDF2-SHA256 key
+// and atomically replaces the file at path (mode 0600).
+func SaveCredentials(path, passphrase string, creds map[string]Credential) error {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, credentialsSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := credentialsCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(credentialsEnvelope{
+		Version:    credentialsVersion,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("error creating credentials directory: %v", err)
+		}
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("error writing credentials file: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error replacing credentials file: %v", err)
+	}
+	credentialsCache.Delete(path)
+	return nil
+}
+
+func credentialsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("no passphrase for credentials file (set %s)", CredentialsPassphraseEnv)
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, credentialsIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// UnlockCredentials decrypts path with the current passphrase and keeps the
+// result for clients created later in the process.
+func UnlockCredentials(path string) error {
+	_, err := cachedCredentials(path)
+	return err
+}
+
+// cachedCredentials decrypts path once per process; key derivation is
+// deliberately slow and clients are created per job.
+func cachedCredentials(path string) (map[string]Credential, error) {
+	if v, ok := credentialsCache.Load(path); ok {
+		return v.(map[string]Credential), nil
+	}
+	creds, err := LoadCredentials(path, CredentialsPassphrase())
+	if err != nil {
+		return nil, err
+	}
+	credentialsCache.Store(path, creds)
+	return creds, nil
+}
+
+// DefaultCredentialsPath is the credentials file managed by `danzo credentials`
+// when --credentials-file is not given.
+func DefaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "danzo", "credentials.enc")
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dialOptions are the connection-level overrides from --resolve, -4/-6,
+// --interface and --local-address.
+type dialOptions struct {
+	resolve   map[string][]string // "host:port" -> addresses
+	network   string              // "tcp", "tcp4" or "tcp6"
+	localAddr *net.TCPAddr
+}
+
+func (o dialOptions) empty() bool {
+	return len(o.resolve) == 0 && o.network == "tcp" && o.localAddr == nil
+}
+
+// parseResolve parses curl-style "host:port:addr[,addr...]" entries. IPv6
+// addresses may be bracketed.
+func parseResolve(entries []string) (map[string][]string, error) {
+	resolve := map[string][]string{}
+	for _, entry := range entries {
+		host, rest, ok1 := strings.Cut(entry, ":")
+		port, addrs, ok2 := strings.Cut(rest, ":")
+		if !ok1 || !ok2 || host == "" || port == "" || addrs == "" {
+			return nil, fmt.Errorf("invalid --resolve entry %q (want host:port:addr)", entry)
+		}
+		var list []string
+		for addr := range strings.SplitSeq(addrs, ",") {
+			addr = strings.Trim(strings.TrimSpace(addr), "[]")
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("invalid address %q in --resolve entry %q", addr, entry)
+			}
+			list = append(list, addr)
+		}
+		key := net.JoinHostPort(strings.ToLower(host), port)
+		resolve[key] = append(resolve[key], list...)
+	}
+	return resolve, nil
+}
+
+func resolveDialOptions(cfg HTTPClientConfig) (dialOptions, error) {
+	opts := dialOptions{network: "tcp"}
+	switch cfg.IPVersion {
+	case 0:
+	case 4:
+		opts.network = "tcp4"
+	case 6:
+		opts.network = "tcp6"
+	default:
+		return opts, fmt.Errorf("invalid IP version %d", cfg.IPVersion)
+	}
+	resolve, err := parseResolve(cfg.Resolve)
+	if err != nil {
+		return opts, err
+	}
+	opts.resolve = resolve
+
+	if cfg.LocalAddress != "" && cfg.Interface != "" {
+		return opts, errors.New("--interface and --local-address are mutually exclusive")
+	}
+	if cfg.LocalAddress != "" {
+		ip := net.ParseIP(cfg.LocalAddress)
+		if ip == nil {
+			return opts, fmt.Errorf("invalid local address %q", cfg.LocalAddress)
+		}
+		opts.localAddr = &net.TCPAddr{IP: ip}
+	}
+	if cfg.Interface != "" {
+		ip, err := InterfaceAddress(cfg.Interface, cfg.IPVersion)
+		if err != nil {
+			return opts, err
+		}
+		opts.localAddr = &net.TCPAddr{IP: ip}
+	}
+	return opts, nil
+}
+
+// InterfaceAddress picks the first address of the named interface that
+// matches the IP version (IPv4 preferred when unrestricted). curl-style, an
+// IP address is accepted as well.
+func InterfaceAddress(name string, ipVersion int) (net.IP, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown interface %q: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("error reading addresses of %s: %v", name, err)
+	}
+	var fallback net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		isV4 := ipNet.IP.To4() != nil
+		switch {
+		case ipVersion == 4 && !isV4, ipVersion == 6 && isV4:
+			continue
+		case ipVersion == 0 && !isV4:
+			if fallback == nil {
+				fallback = ipNet.IP
+			}
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("interface %s has no usable address", name)
+}
+
+// ValidateDialOptions reports invalid --resolve, -4/-6, interface or --bind
+// options.
+func ValidateDialOptions(cfg HTTPClientConfig) error {
+	if _, err := resolveDialOptions(cfg); err != nil {
+		return err
+	}
+	for _, name := range cfg.Bind {
+		if _, err := InterfaceAddress(name, cfg.IPVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDialContext builds the base dial function: socket tuning in high thread
+// mode, address family forcing, --resolve overrides and source binding.
+func newDialContext(cfg HTTPClientConfig) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := newNetDialer(cfg)
+	opts, err := resolveDialOptions(cfg)
+	if err != nil {
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+	dialer.LocalAddr = opts.localAddr
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if opts.network != "tcp" && strings.HasPrefix(network, "tcp") {
+			network = opts.network
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		targets, ok := opts.resolve[net.JoinHostPort(strings.ToLower(host), port)]
+		if !ok {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		var lastErr error
+		for _, ip := range targets {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// authChallenge is a parsed WWW-Authenticate challenge. Digest challenges keep
+// the nonce count so that concurrent chunk requests reusing the same nonce
+// each send a distinct nc value.
+type authChallenge struct {
+	scheme string // "basic" or "digest"
+	params map[string]string
+	mu     sync.Mutex
+	nc     uint32
+}
+
+// authChallenges caches the last answered challenge per user and host for the
+// whole process, since each job may create more than one client.
+var authChallenges sync.Map // "user@host" -> *authChallenge
+
+func cachedChallenge(username, host string) *authChallenge {
+	if v, ok := authChallenges.Load(username + "@" + host); ok {
+		return v.(*authChallenge)
+	}
+	return nil
+}
+
+func storeChallenge(username, host string, ch *authChallenge) {
+	authChallenges.Store(username+"@"+host, ch)
+}
+
+func (c *authChallenge) stale() bool {
+	return c.scheme == "digest" && strings.EqualFold(c.params["stale"], "true")
+}
+
+func (c *authChallenge) authorization(req *http.Request, cred Credential) string {
+	if c.scheme == "basic" {
+		r := &http.Request{Header: http.Header{}}
+		r.SetBasicAuth(cred.Username, cred.Password)
+		return r.Header.Get("Authorization")
+	}
+	c.mu.Lock()
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	c.mu.Unlock()
+	cnonce := make([]byte, 16)
+	rand.Read(cnonce)
+	return c.digestAuthorization(req.Method, req.URL.RequestURI(), cred, nc, hex.EncodeToString(cnonce))
+}
+
+// digestAuthorization builds the RFC 7616 Authorization header value.
+func (c *authChallenge) digestAuthorization(method, uri string, cred Credential, nc, cnonce string) string {
+	algorithm := c.params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	h := digestHash(algorithm)
+	realm, nonce := c.params["realm"], c.params["nonce"]
+	qop := ""
+	if c.params["qop"] != "" {
+		qop = "auth"
+	}
+
+	ha1 := h(cred.Username + ":" + realm + ":" + cred.Password)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	var response string
+	if qop != "" {
+		response = h(strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":"))
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	username := cred.Username
+	if strings.EqualFold(c.params["userhash"], "true") {
+		username = h(cred.Username + ":" + realm)
+	}
+	parts := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", realm),
+		fmt.Sprintf("nonce=%q", nonce),
+		fmt.Sprintf("uri=%q", uri),
+		"algorithm=" + algorithm,
+		fmt.Sprintf("response=%q", response),
+	}
+	if opaque, ok := c.params["opaque"]; ok {
+		parts = append(parts, fmt.Sprintf("opaque=%q", opaque))
+	}
+	if qop != "" {
+		parts = append(parts, "qop="+qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce))
+	}
+	if strings.EqualFold(c.params["userhash"], "true") {
+		parts = append(parts, "userhash=true")
+	}
+	return "Digest " + strings.Join(parts, ", ")
+}
+
+func digestHash(algorithm string) func(string) string {
+	var newHash func() hash.Hash = md5.New
+	if strings.HasPrefix(strings.ToUpper(algorithm), "SHA-256") {
+		newHash = sha256.New
+	}
+	return func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// selectChallenge picks the strongest supported challenge: Digest SHA-256,
+// then Digest MD5, then Basic. Digest challenges that only offer qop=auth-int
+// or an unknown algorithm are skipped.
+func selectChallenge(headers []string) *authChallenge {
+	var best *authChallenge
+	rank := func(c *authChallenge) int {
+		if c == nil {
+			return 0
+		}
+		if c.scheme == "basic" {
+			return 1
+		}
+		if strings.HasPrefix(strings.ToUpper(c.params["algorithm"]), "SHA-256") {
+			return 3
+		}
+		return 2
+	}
+	for _, header := range headers {
+		for _, c := range parseChallenges(header) {
+			if c.scheme == "digest" && !supportedDigest(c) {
+				continue
+			}
+			if c.scheme != "basic" && c.scheme != "digest" {
+				continue
+			}
+			if rank(c) > rank(best) {
+				best = c
+			}
+		}
+	}
+	return best
+}
+
+func supportedDigest(c *authChallenge) bool {
+	switch strings.ToUpper(c.params["algorithm"]) {
+	case "", "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+	default:
+		return false
+	}
+	if c.params["nonce"] == "" {
+		return false
+	}
+	qop := c.params["qop"]
+	if qop == "" {
+		return true
+	}
+	for opt := range strings.SplitSeq(qop, ",") {
+		if strings.TrimSpace(opt) == "auth" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseChallenges splits a WWW-Authenticate value into challenges. A token not
+// followed by "=" starts a new challenge; "key=value" pairs (value quoted or
+// bare) are its parameters.
+func parseChallenges(header string) []*authChallenge {
+	var challenges []*authChallenge
+	var current *authChallenge
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+		end := strings.IndexAny(s, " \t,=")
+		if end < 0 {
+			end = len(s)
+		}
+		token := s[:end]
+		s = strings.TrimLeft(s[end:], " \t")
+		if !strings.HasPrefix(s, "=") {
+			current = &authChallenge{scheme: strings.ToLower(token), params: map[string]string{}}
+			challenges = append(challenges, current)
+			continue
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			s = s[min(i+1, len(s)):]
+		} else {
+			end := strings.IndexAny(s, " \t,")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		if current != nil {
+			current.params[strings.ToLower(token)] = value
+		}
+	}
+	return challenges
+}
@@ -2,8 +2,10 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -53,6 +55,23 @@ func FormatBytes(bytes uint64) string {
 	return fmt.Sprintf("%.2f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// ParseSize parses sizes such as "512", "10K", "1.5MB" or "2GiB" using the
+// same 1024-based units as FormatBytes.
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	multiplier := float64(1)
+	if i := strings.IndexAny(s, "KMGTPE"); i >= 0 && i == len(s)-1 {
+		multiplier = math.Pow(1024, float64(strings.IndexByte("KMGTPE", s[i])+1))
+		s = s[:i]
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(value * multiplier), nil
+}
+
 func FormatSpeed(bytes int64, elapsed float64) string {
 	if elapsed == 0 {
 		return "0 B/s"
@@ -77,6 +96,34 @@ func CleanLocal() error {
 	return nil
 }
 
+// RemovePartFiles deletes the partial files an interrupted download of
+// outputPath left in .danzo-temp, leaving every other job's files alone, and
+// removes the directory once it is empty.
+func RemovePartFiles(outputPath string) error {
+	tempDir := filepath.Join(filepath.Dir(outputPath), ".danzo-temp")
+	files, err := os.ReadDir(tempDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	partPrefix := filepath.Base(outputPath) + ".part"
+	remaining := len(files)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), partPrefix) {
+			if err := os.RemoveAll(filepath.Join(tempDir, file.Name())); err != nil {
+				return err
+			}
+			remaining--
+		}
+	}
+	if remaining == 0 {
+		return os.Remove(tempDir)
+	}
+	return nil
+}
+
 func CleanFunction(outputPath string) error {
 	tempDir := filepath.Join(filepath.Dir(outputPath), ".danzo-temp")
 	files, err := os.ReadDir(tempDir)
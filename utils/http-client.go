@@ -1,10 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
-	"net/url"
 	"syscall"
 	"time"
 
@@ -12,15 +12,35 @@ import (
 )
 
 type HTTPClientConfig struct {
-	Jar            *cookiejar.Jar
-	Timeout        time.Duration
-	KATimeout      time.Duration
-	ProxyURL       string
-	ProxyUsername  string
-	ProxyPassword  string
-	UserAgent      string
-	Headers        map[string]string
-	HighThreadMode bool
+	Jar             *cookiejar.Jar
+	CookiesFile     string
+	NetrcFile       string
+	CredentialsFile string
+	Timeout         time.Duration
+	KATimeout       time.Duration
+	ProxyURL        string
+	ProxyUsername   string
+	ProxyPassword   string
+	NoProxy         string
+	Username        string
+	Password        string
+	CACertFile      string
+	CertFile        string
+	KeyFile         string
+	CertPassword    string
+	Insecure        bool
+	MinTLSVersion   string
+	Resolve         []string
+	IPVersion       int
+	Interface       string
+	LocalAddress    string
+	Bind            []string
+	HTTPVersion     string
+	Xattrs          bool // write origin URL and checksum xattrs on finished files
+	Sync            bool // re-download existing files only when the remote changed
+	UserAgent       string
+	Headers         map[string]string
+	HighThreadMode  bool
 }
 
 type HTTPDoer interface {
@@ -29,8 +49,10 @@ type HTTPDoer interface {
 }
 
 type DanzoHTTPClient struct {
-	client *http.Client
-	config HTTPClientConfig
+	client      *http.Client
+	netrc       []NetrcEntry
+	credentials map[string]Credential
+	config      HTTPClientConfig
 }
 
 func NewDanzoHTTPClient(cfg HTTPClientConfig) *DanzoHTTPClient {
@@ -40,52 +62,40 @@ func NewDanzoHTTPClient(cfg HTTPClientConfig) *DanzoHTTPClient {
 	if cfg.KATimeout == 0 {
 		cfg.KATimeout = 60 * time.Second
 	}
+	if cfg.Jar == nil && cfg.CookiesFile != "" {
+		jar, err := LoadNetscapeCookies(cfg.CookiesFile)
+		if err != nil {
+			log.Debug().Str("package", "utils").Err(err).Msg("Ignoring unreadable cookies file")
+		}
+		cfg.Jar = jar
+	}
 	if cfg.Jar == nil {
 		jar, _ := cookiejar.New(nil)
 		cfg.Jar = jar
 	}
-	transport := &http.Transport{
-		IdleConnTimeout:     cfg.KATimeout,
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		DisableCompression:  true,
-		MaxConnsPerHost:     0,
-	}
-	if cfg.HighThreadMode {
-		transport.DialContext = (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-			Control: func(network, address string, c syscall.RawConn) error {
-				return c.Control(func(fd uintptr) {
-					setSocketOptions(fd)
-				})
-			},
-		}).DialContext
-		log.Debug().Str("package", "utils").Msg("Using high thread mode")
-	}
-	if cfg.ProxyURL != "" {
-		proxyURL, err := url.Parse(cfg.ProxyURL)
-		if err == nil {
-			if cfg.ProxyUsername != "" {
-				if cfg.ProxyPassword != "" {
-					proxyURL.User = url.UserPassword(cfg.ProxyUsername, cfg.ProxyPassword)
-				} else {
-					proxyURL.User = url.User(cfg.ProxyUsername)
-				}
-			}
-			log.Debug().Str("package", "utils").Msgf("Using proxy: %s", proxyURL.String())
-			transport.Proxy = http.ProxyURL(proxyURL)
-		}
-	}
-	return &DanzoHTTPClient{
+	d := &DanzoHTTPClient{
 		client: &http.Client{
 			Timeout:   cfg.Timeout,
-			Transport: transport,
+			Transport: newRoundTripper(cfg),
 			Jar:       cfg.Jar,
 		},
 		config: cfg,
 	}
+	if cfg.NetrcFile != "" {
+		entries, err := LoadNetrc(cfg.NetrcFile)
+		if err != nil {
+			log.Debug().Str("package", "utils").Err(err).Msg("Ignoring unreadable netrc file")
+		}
+		d.netrc = entries
+	}
+	if cfg.CredentialsFile != "" {
+		creds, err := cachedCredentials(cfg.CredentialsFile)
+		if err != nil {
+			log.Debug().Str("package", "utils").Err(err).Msg("Ignoring unusable credentials file")
+		}
+		d.credentials = creds
+	}
+	return d
 }
 
 func (d *DanzoHTTPClient) SetHeader(key, value string) {
@@ -103,5 +113,73 @@ func (d *DanzoHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	for k, v := range d.config.Headers {
 		req.Header.Set(k, v)
 	}
-	return d.client.Do(req)
+	if req.Header.Get("Authorization") != "" {
+		return d.client.Do(req)
+	}
+	d.authorize(req)
+	resp, err := d.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	return d.answerChallenge(req, resp)
+}
+
+// NewHTTPTransport builds the transport used by DanzoHTTPClient (timeouts,
+// socket options, proxy and TLS options), for libraries that take their own
+// http.RoundTripper. Invalid options are an error rather than being skipped,
+// so a download never goes out without the TLS or proxy settings it was
+// given.
+func NewHTTPTransport(cfg HTTPClientConfig) (*http.Transport, error) {
+	if cfg.KATimeout == 0 {
+		cfg.KATimeout = 60 * time.Second
+	}
+	transport := &http.Transport{
+		IdleConnTimeout:     cfg.KATimeout,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		DisableCompression:  true,
+		MaxConnsPerHost:     0,
+	}
+	tlsConfig, err := BuildTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS options: %w", err)
+	}
+	transport.TLSClientConfig = tlsConfig
+	proxies, err := resolveProxySettings(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy configuration: %w", err)
+	}
+	transport.Proxy = proxies.forRequest
+	transport.Protocols = httpProtocols(cfg.HTTPVersion)
+	dialOpts, err := resolveDialOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HighThreadMode || proxies.socks != nil || !dialOpts.empty() {
+		transport.DialContext = proxies.dialer(newDialContext(cfg))
+	}
+	if cfg.HighThreadMode {
+		log.Debug().Str("package", "utils").Msg("Using high thread mode")
+	}
+	if proxies.socks != nil {
+		log.Debug().Str("package", "utils").Msgf("Using SOCKS proxy: %s", proxies.socks.Redacted())
+	} else if proxies.http != nil || proxies.https != nil {
+		log.Debug().Str("package", "utils").Msg("Using HTTP proxy")
+	}
+	return transport, nil
+}
+
+func newNetDialer(cfg HTTPClientConfig) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if cfg.HighThreadMode {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				setSocketOptions(fd)
+			})
+		}
+	}
+	return dialer
 }
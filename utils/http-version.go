@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/rs/zerolog/log"
+)
+
+// HTTP2PriorKnowledge is the HTTP version set by --http2-prior-knowledge:
+// HTTP/2 spoken from the start, including h2c on plain http:// URLs.
+const HTTP2PriorKnowledge = "2-prior-knowledge"
+
+// ValidateHTTPVersion reports an unsupported --http-version, or HTTP/3 combined
+// with a proxy (proxies cannot carry QUIC).
+func ValidateHTTPVersion(cfg HTTPClientConfig) error {
+	switch cfg.HTTPVersion {
+	case "", "1.1", "2", HTTP2PriorKnowledge:
+		return nil
+	case "3":
+	default:
+		return fmt.Errorf("unsupported HTTP version %q (use 1.1, 2 or 3)", cfg.HTTPVersion)
+	}
+	proxies, err := resolveProxySettings(cfg)
+	if err != nil {
+		return err
+	}
+	if proxies.socks != nil || proxies.http != nil || proxies.https != nil {
+		return errors.New("HTTP/3 cannot be used through a proxy")
+	}
+	return nil
+}
+
+// httpProtocols restricts the TCP transport to HTTP/1.1 or HTTP/2, so that
+// chunk requests share one connection as multiplexed streams. HTTP/2 is
+// negotiated over TLS, and plain http:// URLs stay on HTTP/1.1 since most
+// servers don't speak h2c; with prior knowledge HTTP/2 is used for both, as
+// with curl's --http2-prior-knowledge. Without a version, Go's defaults apply.
+func httpProtocols(version string) *http.Protocols {
+	var p http.Protocols
+	switch version {
+	case "1.1":
+		p.SetHTTP1(true)
+	case "2":
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+	case HTTP2PriorKnowledge:
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+	default:
+		return nil
+	}
+	return &p
+}
+
+// newRoundTripper returns the transport for DanzoHTTPClient: QUIC for HTTP/3,
+// otherwise the TCP transport from NewHTTPTransport. When the options are
+// invalid, every request fails with the reason.
+func newRoundTripper(cfg HTTPClientConfig) http.RoundTripper {
+	if cfg.HTTPVersion == "3" {
+		transport, err := newHTTP3Transport(cfg)
+		if err != nil {
+			return failingTransport{err}
+		}
+		return transport
+	}
+	transport, err := NewHTTPTransport(cfg)
+	if err != nil {
+		return failingTransport{err}
+	}
+	return transport
+}
+
+// failingTransport stands in for a transport that could not be built.
+type failingTransport struct{ err error }
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
+
+func newHTTP3Transport(cfg HTTPClientConfig) (*http3.Transport, error) {
+	if cfg.KATimeout == 0 {
+		cfg.KATimeout = 60 * time.Second
+	}
+	tlsConfig, err := BuildTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS options: %w", err)
+	}
+	transport := &http3.Transport{
+		TLSClientConfig:    tlsConfig,
+		QUICConfig:         &quic.Config{MaxIdleTimeout: cfg.KATimeout, KeepAlivePeriod: 15 * time.Second},
+		DisableCompression: true,
+	}
+	opts, err := resolveDialOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.empty() {
+		transport.Dial = opts.dialQUIC
+	}
+	log.Debug().Str("package", "utils").Msg("Using HTTP/3")
+	return transport, nil
+}
+
+// dialQUIC opens a QUIC connection honoring --resolve, -4/-6 and the source
+// address. Each connection gets its own UDP socket, closed with the connection.
+func (o dialOptions) dialQUIC(ctx context.Context, addr string, tlsCfg *tls.Config, quicCfg *quic.Config) (*quic.Conn, error) {
+	network := "udp" + strings.TrimPrefix(o.network, "tcp")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	targets, ok := o.resolve[net.JoinHostPort(strings.ToLower(host), port)]
+	if !ok {
+		targets = []string{host}
+	}
+	var local *net.UDPAddr
+	if o.localAddr != nil {
+		local = &net.UDPAddr{IP: o.localAddr.IP}
+	}
+	var lastErr error
+	for _, target := range targets {
+		remote, err := net.ResolveUDPAddr(network, net.JoinHostPort(target, port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		udpConn, err := net.ListenUDP(network, local)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		tr := &quic.Transport{Conn: udpConn}
+		conn, err := tr.DialEarly(ctx, remote, tlsCfg, quicCfg)
+		if err != nil {
+			tr.Close()
+			udpConn.Close()
+			lastErr = err
+			continue
+		}
+		go func() {
+			<-conn.Context().Done()
+			tr.Close()
+			udpConn.Close()
+		}()
+		return conn, nil
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Extended attributes written with --xattr. user.xdg.origin.url is the
+// freedesktop.org name browsers and wget use for the download source.
+const (
+	XattrOriginURL = "user.xdg.origin.url"
+	XattrSHA256    = "user.checksum.sha256"
+	// XattrValidator is what --sync compares on the next run: the ETag of an
+	// HTTP or S3 download, or the tag and upload time of a release asset.
+	XattrValidator = "user.danzo.validator"
+)
+
+// ErrXattrsNotWritten is returned by SetFileMetadata when only the extended
+// attributes failed, as on filesystems without user xattrs (NFS, vfat, some
+// FUSE mounts). The file is complete, so downloads report it and succeed.
+var ErrXattrsNotWritten = errors.New("xattrs not written")
+
+var writeXattr = setXattr // replaced in tests
+
+// sensitiveQueryParams are fragments of query parameter names that carry
+// credentials, such as presigned S3 and GCS signatures or CDN tokens.
+var sensitiveQueryParams = []string{"x-amz-", "x-goog-", "token", "sig", "key", "secret", "pass", "auth", "credential", "session", "policy"}
+
+// redactOriginURL strips the user info, credential-like query parameters and
+// the fragment from a URL before it is stored with the file, as curl does for
+// --xattr. Unparseable URLs are not stored at all.
+func redactOriginURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.Fragment, u.RawFragment = "", ""
+	var kept []string
+	for param := range strings.SplitSeq(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		name = strings.ToLower(name)
+		sensitive := slices.ContainsFunc(sensitiveQueryParams, func(fragment string) bool {
+			return strings.Contains(name, fragment)
+		})
+		if param != "" && !sensitive {
+			kept = append(kept, param)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	return u.String()
+}
+
+// ParseLastModified reads a Last-Modified header, returning the zero time when
+// it is missing or malformed.
+func ParseLastModified(header http.Header) time.Time {
+	t, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// SetFileMetadata applies what is known about the remote copy of a finished
+// download: its modification time (when non-zero) and, with xattrs, the
+// origin URL (without credentials) and SHA-256 of the file as extended
+// attributes. Failing to write those returns ErrXattrsNotWritten.
+func SetFileMetadata(path string, modTime time.Time, originURL string, xattrs bool) error {
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return fmt.Errorf("error setting modification time: %v", err)
+		}
+	}
+	if !xattrs {
+		return nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if originURL = redactOriginURL(originURL); originURL != "" {
+		if err := writeXattr(path, XattrOriginURL, originURL); err != nil {
+			return fmt.Errorf("%w: %v", ErrXattrsNotWritten, err)
+		}
+	}
+	if err := writeXattr(path, XattrSHA256, sum); err != nil {
+		return fmt.Errorf("%w: %v", ErrXattrsNotWritten, err)
+	}
+	return nil
+}
+
+// SetSyncValidator records the validator of a synced file. Failures are
+// ignored: without the attribute (e.g. off Linux) --sync compares mtimes.
+func SetSyncValidator(path, validator string) {
+	if validator != "" {
+		setXattr(path, XattrValidator, validator)
+	}
+}
+
+// SyncValidator returns the validator recorded by the last sync, if any.
+func SyncValidator(path string) string {
+	validator, err := getXattr(path, XattrValidator)
+	if err != nil {
+		return ""
+	}
+	return validator
+}
+
+// RemoteUnchanged reports whether the file at path still matches a remote
+// copy with the given validator, modification time and size (-1 if unknown).
+// A recorded validator decides; otherwise the mtime, which downloads take
+// from the remote, has to match to the second.
+func RemoteUnchanged(path, validator string, modTime time.Time, size int64) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if size >= 0 && info.Size() != size {
+		return false
+	}
+	if stored := SyncValidator(path); stored != "" && validator != "" {
+		return stored == validator
+	}
+	return !modTime.IsZero() && info.ModTime().Truncate(time.Second).Equal(modTime.Truncate(time.Second))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error hashing %s: %v", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
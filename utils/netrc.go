@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// NetrcEntry is a single machine (or default) block of a .netrc file.
+type NetrcEntry struct {
+	Machine  string // empty for the "default" entry
+	Login    string
+	Password string
+	Account  string
+}
+
+// DefaultNetrcPath returns $NETRC if set, otherwise ~/.netrc (~/_netrc on Windows).
+func DefaultNetrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads and parses a .netrc file.
+func LoadNetrc(path string) ([]NetrcEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening netrc file: %v", err)
+	}
+	defer f.Close()
+	return ParseNetrc(f)
+}
+
+// ParseNetrc parses the machine/default/login/password/account tokens of a
+// .netrc file. macdef bodies are skipped.
+func ParseNetrc(r io.Reader) ([]NetrcEntry, error) {
+	var entries []NetrcEntry
+	var current *NetrcEntry
+	scanner := bufio.NewScanner(r)
+	inMacdef := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacdef {
+			// a macro definition ends at the first empty line
+			if strings.TrimSpace(line) == "" {
+				inMacdef = false
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			next := func() (string, error) {
+				if i+1 >= len(tokens) {
+					return "", fmt.Errorf("missing value for netrc token %q", tokens[i])
+				}
+				i++
+				return tokens[i], nil
+			}
+			switch tokens[i] {
+			case "machine":
+				name, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, NetrcEntry{Machine: name})
+				current = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, NetrcEntry{})
+				current = &entries[len(entries)-1]
+			case "login", "password", "account":
+				key := tokens[i]
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if current == nil {
+					return nil, fmt.Errorf("netrc token %q outside of a machine entry", key)
+				}
+				switch key {
+				case "login":
+					current.Login = value
+				case "password":
+					current.Password = value
+				case "account":
+					current.Account = value
+				}
+			case "macdef":
+				inMacdef = true
+				i = len(tokens)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading netrc file: %v", err)
+	}
+	return entries, nil
+}
+
+// LookupNetrc returns the entry for host, falling back to the default entry.
+func LookupNetrc(entries []NetrcEntry, host string) (NetrcEntry, bool) {
+	var fallback *NetrcEntry
+	for i := range entries {
+		if entries[i].Machine == "" {
+			if fallback == nil {
+				fallback = &entries[i]
+			}
+			continue
+		}
+		if strings.EqualFold(entries[i].Machine, host) {
+			return entries[i], true
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return NetrcEntry{}, false
+}
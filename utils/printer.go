@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -16,13 +17,17 @@ var (
 	warnStyle    = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(11))
 )
 
+// Messages is where status messages and logs are printed. It is switched to
+// stderr when a download is streamed to stdout.
+var Messages io.Writer = os.Stdout
+
 func PrintInfo(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[INFO] " + msg)
+		fmt.Fprintln(Messages, "[INFO] "+msg)
 	} else {
-		fmt.Println(infoStyle.Render("-> " + msg))
+		fmt.Fprintln(Messages, infoStyle.Render("-> "+msg))
 	}
 }
 
@@ -30,9 +35,9 @@ func PrintSuccess(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[OK] " + msg)
+		fmt.Fprintln(Messages, "[OK] "+msg)
 	} else {
-		fmt.Println(successStyle.Render("✓ " + msg))
+		fmt.Fprintln(Messages, successStyle.Render("✓ "+msg))
 	}
 }
 
@@ -44,9 +49,9 @@ func PrintError(msg string, err error) {
 			log.Error().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[ERROR] " + msg)
+		fmt.Fprintln(Messages, "[ERROR] "+msg)
 	} else {
-		fmt.Println(errorStyle.Render("✗ " + msg))
+		fmt.Fprintln(Messages, errorStyle.Render("✗ "+msg))
 	}
 }
 
@@ -58,9 +63,9 @@ func PrintFatal(msg string, err error) {
 			log.Error().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[ERROR] " + msg)
+		fmt.Fprintln(Messages, "[ERROR] "+msg)
 	} else {
-		fmt.Println(errorStyle.Render("✗ " + msg))
+		fmt.Fprintln(Messages, errorStyle.Render("✗ "+msg))
 	}
 	os.Exit(1)
 }
@@ -73,23 +78,23 @@ func PrintWarn(msg string, err error) {
 			log.Warn().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[WARN] " + msg)
+		fmt.Fprintln(Messages, "[WARN] "+msg)
 	} else {
-		fmt.Println(warnStyle.Render("! " + msg))
+		fmt.Fprintln(Messages, warnStyle.Render("! "+msg))
 	}
 }
 
 func PrintGeneric(msg string) {
-	fmt.Println(msg)
+	fmt.Fprintln(Messages, msg)
 }
 
 func PrintRunning(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[RUNNING] " + msg)
+		fmt.Fprintln(Messages, "[RUNNING] "+msg)
 	} else {
-		fmt.Println(infoStyle.Render("~ " + msg))
+		fmt.Fprintln(Messages, infoStyle.Render("~ "+msg))
 	}
 }
 
@@ -97,9 +102,9 @@ func PrintIndentedSuccess(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[OK] " + msg)
+		fmt.Fprintln(Messages, "[OK] "+msg)
 	} else {
-		fmt.Println(successStyle.Render("  ✓ " + msg))
+		fmt.Fprintln(Messages, successStyle.Render("  ✓ "+msg))
 	}
 }
 
@@ -111,9 +116,9 @@ func PrintIndentedError(msg string, err error) {
 			log.Error().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[ERROR] " + msg)
+		fmt.Fprintln(Messages, "[ERROR] "+msg)
 	} else {
-		fmt.Println(errorStyle.Render("  ✗ " + msg))
+		fmt.Fprintln(Messages, errorStyle.Render("  ✗ "+msg))
 	}
 }
 
@@ -125,9 +130,9 @@ func PrintIndentedWarn(msg string, err error) {
 			log.Warn().Str("package", "utils").Msg(msg)
 		}
 	} else if GlobalForAIFlag {
-		fmt.Println("[WARN] " + msg)
+		fmt.Fprintln(Messages, "[WARN] "+msg)
 	} else {
-		fmt.Println(warnStyle.Render("  ! " + msg))
+		fmt.Fprintln(Messages, warnStyle.Render("  ! "+msg))
 	}
 }
 
@@ -135,9 +140,9 @@ func PrintIndentedRunning(msg string) {
 	if GlobalDebugFlag {
 		log.Info().Str("package", "utils").Msg(msg)
 	} else if GlobalForAIFlag {
-		fmt.Println("[RUNNING] " + msg)
+		fmt.Fprintln(Messages, "[RUNNING] "+msg)
 	} else {
-		fmt.Println(infoStyle.Render("  ~ " + msg))
+		fmt.Fprintln(Messages, infoStyle.Render("  ~ "+msg))
 	}
 }
 
@@ -146,7 +151,7 @@ func ClearLines(n int) {
 		return
 	}
 	for range n {
-		fmt.Print("\033[A\033[2K")
+		fmt.Fprint(Messages, "\033[A\033[2K")
 	}
 }
 
@@ -154,7 +159,7 @@ func ClearPreviousLine() {
 	if GlobalDebugFlag || GlobalForAIFlag {
 		return
 	}
-	fmt.Print("\033[A\033[2K")
+	fmt.Fprint(Messages, "\033[A\033[2K")
 }
 
 func PrintProgress(label string, percent int) {
@@ -170,7 +175,7 @@ func PrintProgress(label string, percent int) {
 		return
 	}
 	if GlobalForAIFlag {
-		fmt.Printf("[PROGRESS] %s: %d%%\n", label, percent)
+		fmt.Fprintf(Messages, "[PROGRESS] %s: %d%%\n", label, percent)
 		return
 	}
 
@@ -178,5 +183,5 @@ func PrintProgress(label string, percent int) {
 	filled := barWidth * percent / 100
 	empty := barWidth - filled
 	bar := strings.Repeat("⣿", filled) + strings.Repeat("⣀", empty)
-	fmt.Println(infoStyle.Render(fmt.Sprintf("  ~ %s: %s %d%%", label, bar, percent)))
+	fmt.Fprintln(Messages, infoStyle.Render(fmt.Sprintf("  ~ %s: %s %d%%", label, bar, percent)))
 }
@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxySettings is the resolved proxy configuration. A SOCKS proxy carries
+// every connection (it is applied in the dialer); HTTP(S) proxies are chosen
+// per request scheme.
+type proxySettings struct {
+	http    *url.URL
+	https   *url.URL
+	socks   *url.URL
+	noProxy []string
+	// environment proxies never apply to loopback, as with Go's defaults
+	skipLoopback bool
+	// address family for names resolved locally for socks5: "ip", or "ip4"
+	// and "ip6" for -4/-6
+	ipNetwork string
+}
+
+func getenvAny(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func isSOCKS(u *url.URL) bool {
+	return u != nil && (u.Scheme == "socks5" || u.Scheme == "socks5h")
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https, socks5 or socks5h)", u.Scheme)
+	}
+	return u, nil
+}
+
+// resolveProxySettings uses --proxy when set, otherwise HTTP_PROXY,
+// HTTPS_PROXY and ALL_PROXY from the environment. NO_PROXY (or --noproxy)
+// applies to both.
+func resolveProxySettings(cfg HTTPClientConfig) (proxySettings, error) {
+	s := proxySettings{ipNetwork: "ip"}
+	if cfg.IPVersion == 4 || cfg.IPVersion == 6 {
+		s.ipNetwork = fmt.Sprintf("ip%d", cfg.IPVersion)
+	}
+	noProxy := cfg.NoProxy
+	if noProxy == "" {
+		noProxy = getenvAny("NO_PROXY", "no_proxy")
+	}
+	for entry := range strings.SplitSeq(noProxy, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			s.noProxy = append(s.noProxy, entry)
+		}
+	}
+
+	if cfg.ProxyURL != "" {
+		u, err := parseProxyURL(cfg.ProxyURL)
+		if err != nil {
+			return s, err
+		}
+		if cfg.ProxyUsername != "" {
+			if cfg.ProxyPassword != "" {
+				u.User = url.UserPassword(cfg.ProxyUsername, cfg.ProxyPassword)
+			} else {
+				u.User = url.User(cfg.ProxyUsername)
+			}
+		}
+		if isSOCKS(u) {
+			s.socks = u
+		} else {
+			s.http, s.https = u, u
+		}
+		return s, nil
+	}
+
+	s.skipLoopback = true
+	env := map[string]string{
+		"http":  getenvAny("HTTP_PROXY", "http_proxy"),
+		"https": getenvAny("HTTPS_PROXY", "https_proxy"),
+		"all":   getenvAny("ALL_PROXY", "all_proxy"),
+	}
+	parsed := map[string]*url.URL{}
+	for k, v := range env {
+		if v == "" {
+			continue
+		}
+		u, err := parseProxyURL(v)
+		if err != nil {
+			return s, err
+		}
+		parsed[k] = u
+	}
+	for _, k := range []string{"all", "https", "http"} {
+		if isSOCKS(parsed[k]) {
+			s.socks = parsed[k]
+			return s, nil
+		}
+	}
+	s.http, s.https = parsed["http"], parsed["https"]
+	if s.http == nil {
+		s.http = parsed["all"]
+	}
+	if s.https == nil {
+		s.https = parsed["all"]
+	}
+	return s, nil
+}
+
+// bypass reports whether host (optionally with port) matches NO_PROXY: "*",
+// a domain (also matching subdomains, with or without a leading dot), an IP,
+// or a CIDR range, each optionally with ":port".
+func (s proxySettings) bypass(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	if s.skipLoopback && (host == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return true
+	}
+	for _, entry := range s.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryIP := net.ParseIP(strings.Trim(entry, "[]")); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s proxySettings) forRequest(req *http.Request) (*url.URL, error) {
+	if s.socks != nil || s.bypass(req.URL.Host) {
+		return nil, nil
+	}
+	if req.URL.Scheme == "https" {
+		return s.https, nil
+	}
+	return s.http, nil
+}
+
+// dialer wraps base so that connections go through the SOCKS proxy unless the
+// target matches NO_PROXY. socks5 resolves the target locally and sends the IP;
+// socks5h leaves name resolution to the proxy.
+func (s proxySettings) dialer(base func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.socks == nil {
+		return base
+	}
+	var auth *proxy.Auth
+	if s.socks.User != nil {
+		password, _ := s.socks.User.Password()
+		auth = &proxy.Auth{User: s.socks.User.Username(), Password: password}
+	}
+	socksDialer, err := proxy.SOCKS5("tcp", s.socks.Host, auth, contextDialerFunc(base))
+	if err != nil {
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, fmt.Errorf("error creating SOCKS dialer: %v", err)
+		}
+	}
+	remoteDNS := s.socks.Scheme == "socks5h"
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if s.bypass(addr) {
+			return base(ctx, network, addr)
+		}
+		if !remoteDNS {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if net.ParseIP(host) == nil {
+				ips, err := net.DefaultResolver.LookupIP(ctx, s.ipNetwork, host)
+				if err != nil {
+					return nil, err
+				}
+				if len(ips) == 0 {
+					return nil, fmt.Errorf("no addresses found for %s", host)
+				}
+				addr = net.JoinHostPort(ips[0].String(), port)
+			}
+		}
+		return socksDialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+}
+
+type contextDialerFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f contextDialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f contextDialerFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// ValidateProxy reports an invalid --proxy or proxy environment variable.
+func ValidateProxy(cfg HTTPClientConfig) error {
+	_, err := resolveProxySettings(cfg)
+	return err
+}
+
+// ProxyDialContext returns a dial function for raw TCP connections (e.g.
+// torrent peers) that goes through the configured SOCKS proxy, and whether
+// one is configured. HTTP proxies cannot carry raw connections.
+func ProxyDialContext(cfg HTTPClientConfig) (func(ctx context.Context, network, addr string) (net.Conn, error), bool) {
+	s, err := resolveProxySettings(cfg)
+	if err != nil || s.socks == nil {
+		return nil, false
+	}
+	return s.dialer(newDialContext(cfg)), true
+}
+
+// ProxyForRequest returns a proxy selector for http.Transport-like settings
+// in other libraries, honoring NO_PROXY. It returns no proxy when a SOCKS
+// proxy is configured, since that one is applied by ProxyDialContext.
+func ProxyForRequest(cfg HTTPClientConfig) func(*http.Request) (*url.URL, error) {
+	s, _ := resolveProxySettings(cfg)
+	return s.forRequest
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion maps "1.0" through "1.3" to the crypto/tls constant.
+func ParseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q (use 1.0, 1.1, 1.2 or 1.3)", version)
+	}
+	return v, nil
+}
+
+// BuildTLSConfig returns the client TLS configuration for cfg, or nil when no
+// TLS option is set so the transport keeps Go's defaults.
+func BuildTLSConfig(cfg HTTPClientConfig) (*tls.Config, error) {
+	if cfg.CACertFile == "" && cfg.CertFile == "" && !cfg.Insecure && cfg.MinTLSVersion == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+	if cfg.MinTLSVersion != "" {
+		v, err := ParseTLSVersion(cfg.MinTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = v
+	}
+	if cfg.CACertFile != "" {
+		data, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificates: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := loadClientCertificate(cfg.CertFile, cfg.KeyFile, cfg.CertPassword)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// loadClientCertificate loads a PEM certificate (with the key in keyFile or in
+// the same file) or a PKCS#12 bundle (.p12/.pfx) protected by password.
+func loadClientCertificate(certFile, keyFile, password string) (tls.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error reading client certificate: %v", err)
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return loadPKCS12(data, password)
+	}
+	keyData := data
+	if keyFile != "" {
+		if keyData, err = os.ReadFile(keyFile); err != nil {
+			return tls.Certificate{}, fmt.Errorf("error reading client key: %v", err)
+		}
+	}
+	cert, err := tls.X509KeyPair(data, keyData)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error loading client certificate: %v", err)
+	}
+	return cert, nil
+}
+
+func loadPKCS12(data []byte, password string) (tls.Certificate, error) {
+	certPEM, keyPEM, err := pkcs12PEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error loading PKCS#12 client certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// pkcs12PEM decodes a PKCS#12 bundle into PEM certificates and an
+// unencrypted PEM key.
+func pkcs12PEM(data []byte, password string) ([]byte, []byte, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding PKCS#12 client certificate: %v", err)
+	}
+	var certPEM, keyPEM bytes.Buffer
+	for _, block := range blocks {
+		if block.Type == "CERTIFICATE" {
+			pem.Encode(&certPEM, block)
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			pem.Encode(&keyPEM, block)
+		}
+	}
+	if keyPEM.Len() == 0 {
+		return nil, nil, errors.New("PKCS#12 bundle does not contain a private key")
+	}
+	return certPEM.Bytes(), keyPEM.Bytes(), nil
+}
+
+// IsPKCS12 reports whether certFile holds a PKCS#12 bundle rather than PEM.
+func IsPKCS12(certFile string) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	return block == nil
+}
+
+// WriteClientCertificatePEM converts a PKCS#12 client certificate into one
+// PEM file at path, readable only by the user, for tools that take PEM only.
+// The key in it is not encrypted, so the file should be removed after use.
+func WriteClientCertificatePEM(certFile, password, path string) error {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return fmt.Errorf("error reading client certificate: %v", err)
+	}
+	certPEM, keyPEM, err := pkcs12PEM(data, password)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(certPEM, keyPEM...), 0600)
+}
+
+// PromptCertPassword asks for the password of a client certificate; it is
+// never saved in the resume state.
+var PromptCertPassword = func(certFile string) (string, error) {
+	return PromptPassword("Password for client certificate " + certFile + ":")
+}
+
+var certPasswords sync.Map // certificate file to password, asked once per run
+
+// RestoreCertPassword asks again for the password of cfg's PKCS#12 client
+// certificate when a job is resumed; bundles that open without one are left
+// alone.
+func RestoreCertPassword(cfg *HTTPClientConfig) error {
+	if cfg.CertFile == "" || cfg.CertPassword != "" || !IsPKCS12(cfg.CertFile) {
+		return nil
+	}
+	if password, ok := certPasswords.Load(cfg.CertFile); ok {
+		cfg.CertPassword = password.(string)
+		return nil
+	}
+	data, err := os.ReadFile(cfg.CertFile)
+	if err != nil {
+		return nil // reported when the job connects
+	}
+	if _, _, err := pkcs12PEM(data, ""); err == nil {
+		return nil
+	}
+	password, err := PromptCertPassword(cfg.CertFile)
+	if err != nil {
+		return fmt.Errorf("error reading client certificate password: %v", err)
+	}
+	certPasswords.Store(cfg.CertFile, password)
+	cfg.CertPassword = password
+	return nil
+}
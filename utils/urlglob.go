@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxGlobExpansion caps how many URLs a single glob pattern may produce.
+const maxGlobExpansion = 100000
+
+// URLGlobMatch is one URL produced by ExpandURLGlob, with the value each glob
+// took (in order) for #1, #2, ... output templates.
+type URLGlobMatch struct {
+	URL    string
+	Values []string
+}
+
+// ExpandURLGlob expands curl-style globs in a URL: {a,b,c} sets and [1-100],
+// [001-100] or [a-z] ranges with an optional step ([0-100:10]). As with curl,
+// the last glob varies fastest. A backslash escapes a glob character, and "["
+// right after "://" or "@" is an IPv6 literal rather than a range. Brackets
+// and braces that hold no valid range or set (such as ?filter[name]=x, ids[]
+// or a brace without a comma) are kept as they are. A URL without globs
+// expands to itself.
+func ExpandURLGlob(pattern string) ([]URLGlobMatch, error) {
+	var literals []string // literals[i] precedes globs[i]; one extra trails
+	var globs [][]string
+	var lit strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern) && strings.IndexByte("[]{}", pattern[i+1]) >= 0:
+			i++
+			lit.WriteByte(pattern[i])
+		case c == '[' && (strings.HasSuffix(pattern[:i], "://") || strings.HasSuffix(pattern[:i], "@")):
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched [ in URL %q", pattern)
+			}
+			lit.WriteString(pattern[i : i+end+1])
+			i += end
+		case c == '[' || c == '{':
+			closing := map[byte]byte{'[': ']', '{': '}'}[c]
+			end := strings.IndexByte(pattern[i+1:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched %c in URL %q", c, pattern)
+			}
+			body := pattern[i+1 : i+1+end]
+			var values []string
+			var err error
+			if c == '{' {
+				if strings.Contains(body, ",") {
+					values = strings.Split(body, ",")
+				}
+			} else if values, err = expandRange(body); err != nil {
+				return nil, err
+			}
+			if values == nil {
+				lit.WriteString(pattern[i : i+end+2])
+				i += end + 1
+				continue
+			}
+			literals = append(literals, lit.String())
+			lit.Reset()
+			globs = append(globs, values)
+			i += end + 1
+		case c == ']' || c == '}':
+			return nil, fmt.Errorf("unmatched %c in URL %q", c, pattern)
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	literals = append(literals, lit.String())
+
+	total := 1
+	for _, values := range globs {
+		total *= len(values)
+		if total > maxGlobExpansion {
+			return nil, fmt.Errorf("URL %q expands to more than %d URLs", pattern, maxGlobExpansion)
+		}
+	}
+	matches := make([]URLGlobMatch, 0, total)
+	index := make([]int, len(globs))
+	for range total {
+		var u strings.Builder
+		values := make([]string, len(globs))
+		for g := range globs {
+			values[g] = globs[g][index[g]]
+			u.WriteString(literals[g])
+			u.WriteString(values[g])
+		}
+		u.WriteString(literals[len(globs)])
+		matches = append(matches, URLGlobMatch{URL: u.String(), Values: values})
+		for g := len(globs) - 1; g >= 0; g-- {
+			if index[g]++; index[g] < len(globs[g]) {
+				break
+			}
+			index[g] = 0
+		}
+	}
+	return matches, nil
+}
+
+// expandRange expands the body of a [start-end:step] glob. Numeric ranges keep
+// the zero padding of the start value; letter ranges stay within one case. It
+// returns nil when body is not a valid range.
+func expandRange(body string) ([]string, error) {
+	spec, stepStr, hasStep := strings.Cut(body, ":")
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+			return nil, nil
+		}
+	}
+	start, end, ok := strings.Cut(spec, "-")
+	if !ok || start == "" || end == "" {
+		return nil, nil
+	}
+
+	if isLetter(start) && isLetter(end) {
+		from, to := start[0], end[0]
+		if (from >= 'a') != (to >= 'a') || from > to {
+			return nil, nil
+		}
+		var values []string
+		for c := int(from); c <= int(to); c += step {
+			values = append(values, string(rune(c)))
+		}
+		return values, nil
+	}
+
+	from, err1 := strconv.Atoi(start)
+	to, err2 := strconv.Atoi(end)
+	if err1 != nil || err2 != nil || from < 0 || from > to {
+		return nil, nil
+	}
+	if (to-from)/step+1 > maxGlobExpansion {
+		return nil, errors.New("range [" + body + "] is too large")
+	}
+	width := 0
+	if len(start) > 1 && start[0] == '0' {
+		width = len(start)
+	}
+	var values []string
+	for n := from; n <= to; n += step {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, nil
+}
+
+func isLetter(s string) bool {
+	return len(s) == 1 && (s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z')
+}
+
+// HasOutputTemplate reports whether an output path uses #1-style placeholders.
+func HasOutputTemplate(output string) bool {
+	for i := 0; i+1 < len(output); i++ {
+		if output[i] == '#' && output[i+1] >= '1' && output[i+1] <= '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyOutputTemplate replaces #1, #2, ... in output with the glob values of a
+// match, as curl does for -o. Placeholders without a matching glob are kept.
+func ApplyOutputTemplate(output string, values []string) string {
+	var b strings.Builder
+	for i := 0; i < len(output); i++ {
+		if output[i] != '#' {
+			b.WriteByte(output[i])
+			continue
+		}
+		j := i + 1
+		for j < len(output) && output[j] >= '0' && output[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(output[i+1 : j])
+		if err != nil || n < 1 || n > len(values) {
+			b.WriteByte('#')
+			continue
+		}
+		b.WriteString(values[n-1])
+		i = j - 1
+	}
+	return b.String()
+}
@@ -8,6 +8,9 @@ import (
 const DefaultBufferSize = 1024 * 1024 * 8
 const LogFile = ".danzo.log"
 
+// StdoutPath is the output path that streams a download to stdout instead of a file.
+const StdoutPath = "-"
+
 var ErrRangeRequestsNotSupported = errors.New("range requests are not supported")
 var ChunkIDRegex = regexp.MustCompile(`\.part(\d+)$`)
 
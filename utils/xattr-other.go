@@ -0,0 +1,15 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are only written on Linux")
+
+func setXattr(path, name, value string) error {
+	return errXattrUnsupported
+}
+
+func getXattr(path, name string) (string, error) {
+	return "", errXattrUnsupported
+}